@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/krasvl/market/internal/accrualmock"
+	"go.uber.org/zap"
+)
+
+// env maps the flags to the environment variables overriding them.
+var env = map[string]string{
+	"a":              "RUN_ADDRESS",
+	"rate-limit":     "RATE_LIMIT",
+	"registered-for": "REGISTERED_FOR",
+	"processing-for": "PROCESSING_FOR",
+	"fail-every":     "FAIL_EVERY",
+}
+
+func main() {
+	// gophermart listens on :8080 by default.
+	addr := ":8081"
+	cfg := accrualmock.Config{RegisteredFor: time.Second, ProcessingFor: 2 * time.Second}
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.StringVar(&addr, "a", addr, "address")
+	fs.IntVar(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "order requests allowed per minute, 0 for no limit")
+	fs.DurationVar(&cfg.RegisteredFor, "registered-for", cfg.RegisteredFor, "how long an order stays REGISTERED")
+	fs.DurationVar(&cfg.ProcessingFor, "processing-for", cfg.ProcessingFor, "how long an order stays PROCESSING")
+	fs.IntVar(&cfg.FailEvery, "fail-every", cfg.FailEvery, "fail every n-th order request with 500, 0 never")
+	err := fs.Parse(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+	if err := setFromEnv(fs); err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		log.Fatalf("Logger error: %v", err)
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           accrualmock.New(logger, cfg).Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	// ListenAndServe returns as soon as the shutdown starts, wait for it to
+	// finish the requests in flight.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	context.AfterFunc(ctx, func() {
+		defer close(stopped)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Error("failed to stop accrual mock", zap.Error(err))
+		}
+	})
+
+	logger.Info("accrual mock started",
+		zap.String("address", addr),
+		zap.Int("rate_limit", cfg.RateLimit),
+		zap.Duration("registered_for", cfg.RegisteredFor),
+		zap.Duration("processing_for", cfg.ProcessingFor),
+		zap.Int("fail_every", cfg.FailEvery),
+	)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		stop()
+		log.Fatalf("Accrual mock error: %v", err)
+	}
+	<-stopped
+	stop()
+}
+
+// setFromEnv sets the flags from the environment, which goes over the
+// command line.
+func setFromEnv(fs *flag.FlagSet) error {
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := env[f.Name]
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			if err := fs.Set(f.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("invalid %s: %w", name, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
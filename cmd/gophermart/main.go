@@ -1,20 +1,57 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/krasvl/market/internal/config"
 	"github.com/krasvl/market/internal/server"
+	"github.com/krasvl/market/internal/storage"
+	"go.uber.org/zap"
 )
 
 func main() {
-	addrDefault := ""
-	databaseDefault := ""
-	secretDefault := ""
+	cfg, err := config.Load(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+	if err := cfg.ValidateServer(); err != nil {
+		log.Fatal(err)
+	}
+
+	logger, err := zap.NewProduction()
+	if err != nil {
+		log.Fatalf("Logger error: %v", err)
+	}
 
-	server, err := server.GetConfiguredServer(addrDefault, databaseDefault, secretDefault)
+	db, err := storage.NewDB(cfg.Database)
 	if err != nil {
+		log.Fatalf("Database error: %v", err)
+	}
+
+	server, err := server.GetConfiguredServer(cfg, db, logger)
+	if err != nil {
+		_ = db.Close()
 		log.Fatalf("Server configure error: %v", err)
 	}
 
-	server.Start()
+	// SIGTERM starts a graceful shutdown, a second one kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	err = server.Start(ctx)
+	stop()
+	if closeErr := errors.Join(server.Close(), db.Close()); closeErr != nil {
+		log.Printf("Server close error: %v", closeErr)
+	}
+	if err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
 }
@@ -1,19 +1,78 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/krasvl/market/internal/config"
 	"github.com/krasvl/market/internal/scheduler"
+	"github.com/krasvl/market/internal/storage"
+	"go.uber.org/zap"
 )
 
 func main() {
-	databaseDefault := ""
-	accrualAddrDefault := ""
+	cfg, err := config.Load(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Config error: %v", err)
+	}
+	if err := cfg.ValidateScheduler(); err != nil {
+		log.Fatal(err)
+	}
 
-	scheduler, err := scheduler.GetConfiguredScheduler(databaseDefault, accrualAddrDefault)
+	logger, err := zap.NewProduction()
 	if err != nil {
+		log.Fatalf("Logger error: %v", err)
+	}
+
+	db, err := storage.NewDB(cfg.Database)
+	if err != nil {
+		log.Fatalf("Database error: %v", err)
+	}
+
+	scheduler, err := scheduler.GetConfiguredScheduler(cfg.Scheduler, cfg.Database, db, logger)
+	if err != nil {
+		_ = db.Close()
 		log.Fatalf("Scheduler configure error: %v", err)
 	}
 
-	scheduler.Start()
+	// "scheduler requeue [number...]" puts stuck orders back in the queue,
+	// all of them when no numbers are given.
+	if args := cfg.Args; len(args) > 0 {
+		if args[0] != "requeue" {
+			closeScheduler(scheduler, db)
+			log.Fatalf("Unknown command: %s", args[0])
+		}
+		requeued, err := scheduler.Requeue(args[1:])
+		closeScheduler(scheduler, db)
+		if err != nil {
+			log.Fatalf("Requeue error: %v", err)
+		}
+		log.Printf("Requeued %d stuck orders", requeued)
+		return
+	}
+
+	// SIGTERM stops claiming new work, a second one kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	err = scheduler.Start(ctx)
+	stop()
+	closeScheduler(scheduler, db)
+	if err != nil {
+		log.Fatalf("Scheduler error: %v", err)
+	}
+}
+
+func closeScheduler(s *scheduler.Scheduler, db *sql.DB) {
+	if err := errors.Join(s.Close(), db.Close()); err != nil {
+		log.Printf("Scheduler close error: %v", err)
+	}
 }
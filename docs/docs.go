@@ -15,26 +15,46 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/api/user/balance": {
-            "get": {
+        "/api/service/holds": {
+            "post": {
                 "security": [
                     {
-                        "BearerAuth": []
+                        "ServiceAuth": []
                     }
                 ],
-                "description": "Get current balance and total withdrawn points.",
+                "description": "Reserve points for a shop order while its payment is in flight.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "balance"
+                    "service"
+                ],
+                "summary": "Reserve a user's points.",
+                "parameters": [
+                    {
+                        "description": "Hold",
+                        "name": "hold",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.ServiceHoldRequest"
+                        }
+                    }
                 ],
-                "summary": "Get user balance.",
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "201": {
+                        "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/handlers.BalanceResponse"
+                            "$ref": "#/definitions/handlers.HoldResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request\".",
+                        "schema": {
+                            "type": "string"
                         }
                     },
                     "401": {
@@ -43,6 +63,24 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "402": {
+                        "description": "Insufficient funds\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid order number or sum\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Internal server error\".",
                         "schema": {
@@ -52,38 +90,103 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/user/balance/withdraw": {
+        "/api/service/holds/{id}/capture": {
             "post": {
                 "security": [
                     {
-                        "BearerAuth": []
+                        "ServiceAuth": []
                     }
                 ],
-                "description": "Withdraw points from balance for a new order.",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Turn any user's hold into a withdrawal once the payment succeeded.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "balance"
+                    "service"
                 ],
-                "summary": "Withdraw points from balance.",
+                "summary": "Capture reserved points.",
                 "parameters": [
                     {
-                        "description": "Withdrawal",
-                        "name": "withdrawal",
-                        "in": "body",
-                        "required": true,
+                        "type": "integer",
+                        "description": "Hold ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/handlers.WithdrawRequest"
+                            "$ref": "#/definitions/handlers.HoldResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Hold not found\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Hold already captured, released or expired\".",
+                        "schema": {
+                            "type": "string"
                         }
+                    },
+                    "500": {
+                        "description": "Internal server error\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/service/holds/{id}/release": {
+            "post": {
+                "security": [
+                    {
+                        "ServiceAuth": []
+                    }
+                ],
+                "description": "Return any user's held points once the payment failed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "service"
+                ],
+                "summary": "Release reserved points.",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Hold ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Withdrawal successful\".",
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.HoldResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request\".",
                         "schema": {
                             "type": "string"
                         }
@@ -94,14 +197,14 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
-                    "402": {
-                        "description": "Insufficient funds\".",
+                    "404": {
+                        "description": "Hold not found\".",
                         "schema": {
                             "type": "string"
                         }
                     },
-                    "422": {
-                        "description": "Invalid order number\".",
+                    "409": {
+                        "description": "Hold already captured or released\".",
                         "schema": {
                             "type": "string"
                         }
@@ -115,9 +218,14 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/user/login": {
+        "/api/service/refunds": {
             "post": {
-                "description": "Login a user with login and password.",
+                "security": [
+                    {
+                        "ServiceAuth": []
+                    }
+                ],
+                "description": "Return points spent on a cancelled order, in full or in part.\nRetrying with the same refund id does not return the points twice.",
                 "consumes": [
                     "application/json"
                 ],
@@ -125,25 +233,25 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "service"
                 ],
-                "summary": "Login a user.",
+                "summary": "Refund a withdrawal.",
                 "parameters": [
                     {
-                        "description": "User",
-                        "name": "user",
+                        "description": "Refund",
+                        "name": "refund",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/handlers.LoginRequest"
+                            "$ref": "#/definitions/handlers.RefundRequest"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "JWT token\".",
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/handlers.RefundResponse"
                         }
                     },
                     "400": {
@@ -153,7 +261,25 @@ const docTemplate = `{
                         }
                     },
                     "401": {
-                        "description": "Invalid login or password\".",
+                        "description": "Unauthorized\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "User or withdrawal not found\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Withdrawal already refunded, several withdrawals for the order or refund id reused\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid sum\".",
                         "schema": {
                             "type": "string"
                         }
@@ -167,118 +293,105 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/user/orders": {
+        "/api/user/balance": {
             "get": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Get list of orders submitted by the user.",
+                "description": "Get current balance, total withdrawn and held points and points expiring within 30 days.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "order"
+                    "balance"
                 ],
-                "summary": "Get list of orders.",
+                "summary": "Get user balance.",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/handlers.OrderResponse"
-                            }
-                        }
-                    },
-                    "204": {
-                        "description": "No content.\".",
-                        "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/handlers.BalanceResponse"
                         }
                     },
                     "401": {
-                        "description": "Unauthorized.\".",
+                        "description": "Unauthorized\".",
                         "schema": {
                             "type": "string"
                         }
                     },
                     "500": {
-                        "description": "Internal server error.\".",
+                        "description": "Internal server error\".",
                         "schema": {
                             "type": "string"
                         }
                     }
                 }
-            },
+            }
+        },
+        "/api/user/balance/holds": {
             "post": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Submit an order number for loyalty points calculation.",
+                "description": "Reserve points for an order while its payment is in flight.",
                 "consumes": [
-                    "text/plain"
+                    "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "order"
+                    "hold"
                 ],
-                "summary": "Submit an order number.",
+                "summary": "Reserve points.",
                 "parameters": [
                     {
-                        "description": "Order Number",
-                        "name": "order",
+                        "description": "Hold",
+                        "name": "hold",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/handlers.HoldRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "Order already uploaded by this user.\".",
-                        "schema": {
-                            "type": "string"
-                        }
-                    },
-                    "202": {
-                        "description": "Order accepted for processing.\".",
+                    "201": {
+                        "description": "Created",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/handlers.HoldResponse"
                         }
                     },
                     "400": {
-                        "description": "Invalid request.\".",
+                        "description": "Invalid request\".",
                         "schema": {
                             "type": "string"
                         }
                     },
                     "401": {
-                        "description": "Unauthorized.\".",
+                        "description": "Unauthorized\".",
                         "schema": {
                             "type": "string"
                         }
                     },
-                    "409": {
-                        "description": "Order number already exists.\".",
+                    "402": {
+                        "description": "Insufficient funds\".",
                         "schema": {
                             "type": "string"
                         }
                     },
                     "422": {
-                        "description": "Invalid order number.\".",
+                        "description": "Invalid order number or sum\".",
                         "schema": {
                             "type": "string"
                         }
                     },
                     "500": {
-                        "description": "Internal server error.\".",
+                        "description": "Internal server error\".",
                         "schema": {
                             "type": "string"
                         }
@@ -286,35 +399,35 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/user/register": {
+        "/api/user/balance/holds/{id}/capture": {
             "post": {
-                "description": "Register a new user with login and password.",
-                "consumes": [
-                    "application/json"
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
                 ],
+                "description": "Turn a hold into a withdrawal once the payment succeeded.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "hold"
                 ],
-                "summary": "Register a new user.",
+                "summary": "Capture reserved points.",
                 "parameters": [
                     {
-                        "description": "User",
-                        "name": "user",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/handlers.RegisterRequest"
-                        }
+                        "type": "integer",
+                        "description": "Hold ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "JWT token\".",
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/handlers.HoldResponse"
                         }
                     },
                     "400": {
@@ -323,8 +436,20 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Hold not found\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "409": {
-                        "description": "Login already exists\".",
+                        "description": "Hold already captured, released or expired\".",
                         "schema": {
                             "type": "string"
                         }
@@ -338,33 +463,39 @@ const docTemplate = `{
                 }
             }
         },
-        "/api/user/withdrawals": {
-            "get": {
+        "/api/user/balance/holds/{id}/release": {
+            "post": {
                 "security": [
                     {
                         "BearerAuth": []
                     }
                 ],
-                "description": "Get list of withdrawals made by the user.",
+                "description": "Return held points to the balance once the payment failed.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "withdrawal"
+                    "hold"
+                ],
+                "summary": "Release reserved points.",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Hold ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "Get list of withdrawals.",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/handlers.WithdrawalResponse"
-                            }
+                            "$ref": "#/definitions/handlers.HoldResponse"
                         }
                     },
-                    "204": {
-                        "description": "No content\".",
+                    "400": {
+                        "description": "Invalid request\".",
                         "schema": {
                             "type": "string"
                         }
@@ -375,6 +506,18 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "404": {
+                        "description": "Hold not found\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Hold already captured or released\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Internal server error\".",
                         "schema": {
@@ -383,20 +526,1196 @@ const docTemplate = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "handlers.BalanceResponse": {
+        },
+        "/api/user/balance/transfer": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Gift points to another user, within the daily transfer limit.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transfer"
+                ],
+                "summary": "Transfer points to another user.",
+                "parameters": [
+                    {
+                        "description": "Transfer",
+                        "name": "transfer",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.TransferRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.TransferResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "402": {
+                        "description": "Insufficient funds\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Daily transfer limit exceeded\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "User not found\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid sum or transfer to yourself\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/balance/withdraw": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Withdraw points from balance for a new order.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "balance"
+                ],
+                "summary": "Withdraw points from balance.",
+                "parameters": [
+                    {
+                        "description": "Withdrawal",
+                        "name": "withdrawal",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.WithdrawRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Withdrawal successful\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "402": {
+                        "description": "Insufficient funds\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid order number or sum\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/events": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Push order status changes and balance updates as Server-Sent Events.\nEvent names are \"order\", \"balance\" and \"resync\"; after \"resync\" the client should fetch its state again.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "events"
+                ],
+                "summary": "Stream user events.",
+                "responses": {
+                    "200": {
+                        "description": "Event stream\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/login": {
+            "post": {
+                "description": "Login a user with login and password.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Login a user.",
+                "parameters": [
+                    {
+                        "description": "User",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.LoginRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "JWT token\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Invalid login or password\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/orders": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get list of orders submitted by the user, newest first unless sorted otherwise.\nPass the X-Next-Cursor response header as cursor to get the next page.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "order"
+                ],
+                "summary": "Get list of orders.",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page size, up to 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "NEW",
+                            "PROCESSING",
+                            "INVALID",
+                            "PROCESSED"
+                        ],
+                        "type": "string",
+                        "description": "Order status",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Uploaded at or after, YYYY-MM-DD or RFC 3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Uploaded before, YYYY-MM-DD (inclusive) or RFC 3339",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "asc",
+                            "desc"
+                        ],
+                        "type": "string",
+                        "description": "Sort direction",
+                        "name": "sort",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/handlers.OrderResponse"
+                            }
+                        },
+                        "headers": {
+                            "X-Next-Cursor": {
+                                "type": "string",
+                                "description": "Cursor of the next page\"."
+                            }
+                        }
+                    },
+                    "204": {
+                        "description": "No content.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Submit an order number for loyalty points calculation.",
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "order"
+                ],
+                "summary": "Submit an order number.",
+                "parameters": [
+                    {
+                        "description": "Order Number",
+                        "name": "order",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Order already uploaded by this user.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "202": {
+                        "description": "Order accepted for processing.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Order number already exists.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid order number.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/orders/batch": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Submit order numbers as a JSON array of strings or as CSV with a number in the first column,\neither as the request body or as a multipart \"file\" upload. An optional \"number\" header row is skipped.\nEvery number gets its own result, so a batch is accepted even if some numbers are not.",
+                "consumes": [
+                    "application/json",
+                    "text/csv",
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "order"
+                ],
+                "summary": "Submit a batch of order numbers.",
+                "parameters": [
+                    {
+                        "description": "Order numbers",
+                        "name": "orders",
+                        "in": "body",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    {
+                        "type": "file",
+                        "description": "CSV file with order numbers",
+                        "name": "file",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key to safely retry the request",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/handlers.OrderUploadResponse"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "Too many order numbers.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/orders/{number}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get an order of the user with its status history and how many times the accrual system was polled.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "order"
+                ],
+                "summary": "Get an order.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order Number",
+                        "name": "number",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.OrderDetailResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Order not found.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error.\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/register": {
+            "post": {
+                "description": "Register a new user with login and password.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Register a new user.",
+                "parameters": [
+                    {
+                        "description": "User",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.RegisterRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "JWT token\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Login already exists\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/statement": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get accruals, withdrawals and other balance movements in chronological order with a running balance.\nDates are either YYYY-MM-DD or RFC 3339, a date in \"to\" includes the whole day.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "balance"
+                ],
+                "summary": "Get account statement.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Start of the period",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "End of the period",
+                        "name": "to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.StatementResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid period\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/transfers": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get transfers sent or received by the user, newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "transfer"
+                ],
+                "summary": "Get list of transfers.",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/handlers.TransferResponse"
+                            }
+                        }
+                    },
+                    "204": {
+                        "description": "No content\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/webhooks": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get the webhooks registered by the user.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Get list of webhooks.",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/handlers.WebhookResponse"
+                            }
+                        }
+                    },
+                    "204": {
+                        "description": "No content\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Register a public HTTPS endpoint to receive order and withdrawal events.\nRedirects are not followed.\nDeliveries are signed: X-Gophermart-Signature is \"sha256=\" followed by the hex HMAC-SHA256\nof X-Gophermart-Timestamp, \".\" and the body, keyed with the returned secret.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Register a webhook.",
+                "parameters": [
+                    {
+                        "description": "Webhook",
+                        "name": "webhook",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handlers.WebhookRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.WebhookResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "Invalid webhook URL\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/webhooks/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Delete the webhook and drop its pending deliveries.",
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Delete a webhook.",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "Deleted\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Webhook not found\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/webhooks/{id}/deliveries": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get the latest deliveries of the webhook, newest first.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "webhooks"
+                ],
+                "summary": "Get webhook delivery log.",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Webhook ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/handlers.DeliveryResponse"
+                            }
+                        }
+                    },
+                    "204": {
+                        "description": "No content\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Webhook not found\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/user/withdrawals": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get list of withdrawals made by the user, newest first unless sorted otherwise.\nPass the X-Next-Cursor response header as cursor to get the next page.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "withdrawal"
+                ],
+                "summary": "Get list of withdrawals.",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page size, up to 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Processed at or after, YYYY-MM-DD or RFC 3339",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Processed before, YYYY-MM-DD (inclusive) or RFC 3339",
+                        "name": "to",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "asc",
+                            "desc"
+                        ],
+                        "type": "string",
+                        "description": "Sort direction",
+                        "name": "sort",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/handlers.WithdrawalResponse"
+                            }
+                        },
+                        "headers": {
+                            "X-Next-Cursor": {
+                                "type": "string",
+                                "description": "Cursor of the next page\"."
+                            }
+                        }
+                    },
+                    "204": {
+                        "description": "No content\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal server error\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/healthz": {
+            "get": {
+                "description": "Answer as long as the server is running.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Liveness probe.",
+                "responses": {
+                    "200": {
+                        "description": "OK\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "Check that the database answers and its migrations are applied.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Readiness probe.",
+                "responses": {
+                    "200": {
+                        "description": "OK\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "Not ready\".",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "handlers.BalanceResponse": {
             "type": "object",
             "properties": {
                 "current": {
                     "type": "number"
                 },
+                "expiring": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.ExpiringPointsResponse"
+                    }
+                },
+                "held": {
+                    "type": "number"
+                },
                 "withdrawn": {
                     "type": "number"
                 }
             }
         },
+        "handlers.DeliveryResponse": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "delivered_at": {
+                    "type": "string"
+                },
+                "event": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "last_status_code": {
+                    "type": "integer"
+                },
+                "next_attempt_at": {
+                    "type": "string"
+                },
+                "payload": {
+                    "type": "object"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "PENDING",
+                        "DELIVERED",
+                        "FAILED"
+                    ]
+                }
+            }
+        },
+        "handlers.ExpiringPointsResponse": {
+            "type": "object",
+            "properties": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "sum": {
+                    "type": "number"
+                }
+            }
+        },
+        "handlers.HoldRequest": {
+            "type": "object",
+            "properties": {
+                "order": {
+                    "type": "string"
+                },
+                "sum": {
+                    "type": "number"
+                }
+            }
+        },
+        "handlers.HoldResponse": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "order": {
+                    "type": "string"
+                },
+                "status": {
+                    "$ref": "#/definitions/storage.HoldStatus"
+                },
+                "sum": {
+                    "type": "number"
+                }
+            }
+        },
         "handlers.LoginRequest": {
             "type": "object",
             "required": [
@@ -412,6 +1731,61 @@ const docTemplate = `{
                 }
             }
         },
+        "handlers.OrderDetailResponse": {
+            "type": "object",
+            "properties": {
+                "accrual": {
+                    "type": "number"
+                },
+                "events": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.OrderEventResponse"
+                    }
+                },
+                "last_polled_at": {
+                    "type": "string"
+                },
+                "number": {
+                    "type": "string"
+                },
+                "polls": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "NEW",
+                        "PROCESSING",
+                        "INVALID",
+                        "PROCESSED"
+                    ]
+                },
+                "uploaded_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.OrderEventResponse": {
+            "type": "object",
+            "properties": {
+                "accrual": {
+                    "type": "number"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "NEW",
+                        "PROCESSING",
+                        "INVALID",
+                        "PROCESSED"
+                    ]
+                }
+            }
+        },
         "handlers.OrderResponse": {
             "type": "object",
             "properties": {
@@ -422,13 +1796,73 @@ const docTemplate = `{
                     "type": "string"
                 },
                 "status": {
-                    "$ref": "#/definitions/storage.OrderStatus"
+                    "type": "string",
+                    "enum": [
+                        "NEW",
+                        "PROCESSING",
+                        "INVALID",
+                        "PROCESSED"
+                    ]
                 },
                 "uploaded_at": {
                     "type": "string"
                 }
             }
         },
+        "handlers.OrderUploadResponse": {
+            "type": "object",
+            "properties": {
+                "number": {
+                    "type": "string"
+                },
+                "result": {
+                    "enum": [
+                        "ACCEPTED",
+                        "ALREADY_UPLOADED",
+                        "OWNED_BY_ANOTHER_USER",
+                        "INVALID"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/storage.UploadResult"
+                        }
+                    ]
+                }
+            }
+        },
+        "handlers.RefundRequest": {
+            "type": "object",
+            "required": [
+                "login",
+                "order",
+                "refund_id"
+            ],
+            "properties": {
+                "login": {
+                    "type": "string"
+                },
+                "order": {
+                    "type": "string"
+                },
+                "refund_id": {
+                    "type": "string"
+                },
+                "sum": {
+                    "type": "number"
+                }
+            }
+        },
+        "handlers.RefundResponse": {
+            "type": "object",
+            "properties": {
+                "processed_at": {
+                    "type": "string"
+                },
+                "sum": {
+                    "type": "number"
+                }
+            }
+        },
         "handlers.RegisterRequest": {
             "type": "object",
             "required": [
@@ -444,6 +1878,132 @@ const docTemplate = `{
                 }
             }
         },
+        "handlers.ServiceHoldRequest": {
+            "type": "object",
+            "required": [
+                "login"
+            ],
+            "properties": {
+                "login": {
+                    "type": "string"
+                },
+                "order": {
+                    "type": "string"
+                },
+                "sum": {
+                    "type": "number"
+                }
+            }
+        },
+        "handlers.StatementLineResponse": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "number"
+                },
+                "balance": {
+                    "type": "number"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "operation": {
+                    "$ref": "#/definitions/storage.LedgerOperation"
+                },
+                "order": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.StatementResponse": {
+            "type": "object",
+            "properties": {
+                "closing_balance": {
+                    "type": "number"
+                },
+                "from": {
+                    "type": "string"
+                },
+                "lines": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.StatementLineResponse"
+                    }
+                },
+                "opening_balance": {
+                    "type": "number"
+                },
+                "to": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.TransferRequest": {
+            "type": "object",
+            "required": [
+                "login"
+            ],
+            "properties": {
+                "login": {
+                    "type": "string"
+                },
+                "sum": {
+                    "type": "number"
+                }
+            }
+        },
+        "handlers.TransferResponse": {
+            "type": "object",
+            "properties": {
+                "direction": {
+                    "type": "string",
+                    "enum": [
+                        "OUTGOING",
+                        "INCOMING"
+                    ]
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "login": {
+                    "type": "string"
+                },
+                "processed_at": {
+                    "type": "string"
+                },
+                "sum": {
+                    "type": "number"
+                }
+            }
+        },
+        "handlers.WebhookRequest": {
+            "type": "object",
+            "required": [
+                "url"
+            ],
+            "properties": {
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "handlers.WebhookResponse": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "secret": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
         "handlers.WithdrawRequest": {
             "type": "object",
             "properties": {
@@ -464,24 +2024,71 @@ const docTemplate = `{
                 "processed_at": {
                     "type": "string"
                 },
+                "refunded": {
+                    "type": "number"
+                },
+                "refunds": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/handlers.RefundResponse"
+                    }
+                },
                 "sum": {
                     "type": "number"
                 }
             }
         },
-        "storage.OrderStatus": {
+        "storage.HoldStatus": {
             "type": "string",
             "enum": [
-                "NEW",
-                "PROCESSING",
-                "INVALID",
-                "PROCESSED"
+                "HELD",
+                "CAPTURED",
+                "RELEASED"
             ],
             "x-enum-varnames": [
-                "StatusNew",
-                "StatusProcessing",
-                "StatusInvalid",
-                "StatusProcessed"
+                "HoldStatusHeld",
+                "HoldStatusCaptured",
+                "HoldStatusReleased"
+            ]
+        },
+        "storage.LedgerOperation": {
+            "type": "string",
+            "enum": [
+                "OPENING",
+                "ACCRUAL",
+                "WITHDRAWAL",
+                "REFUND",
+                "EXPIRATION",
+                "HOLD",
+                "CAPTURE",
+                "RELEASE",
+                "TRANSFER"
+            ],
+            "x-enum-varnames": [
+                "OperationOpening",
+                "OperationAccrual",
+                "OperationWithdrawal",
+                "OperationRefund",
+                "OperationExpiration",
+                "OperationHold",
+                "OperationCapture",
+                "OperationRelease",
+                "OperationTransfer"
+            ]
+        },
+        "storage.UploadResult": {
+            "type": "string",
+            "enum": [
+                "ACCEPTED",
+                "ALREADY_UPLOADED",
+                "OWNED_BY_ANOTHER_USER",
+                "INVALID"
+            ],
+            "x-enum-varnames": [
+                "UploadAccepted",
+                "UploadAlreadyUploaded",
+                "UploadConflict",
+                "UploadInvalid"
             ]
         }
     },
@@ -490,6 +2097,11 @@ const docTemplate = `{
             "type": "apiKey",
             "name": "Authorization.",
             "in": "header."
+        },
+        "ServiceAuth.": {
+            "type": "apiKey",
+            "name": "Authorization.",
+            "in": "header."
         }
     }
 }`
@@ -0,0 +1,224 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type BreakerState string
+
+const (
+	// BreakerClosed lets every request through.
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen rejects requests until the open timeout passes.
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets a few probe requests through to see whether the
+	// accrual system is back.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// ErrCircuitOpen is returned without calling the accrual system while it is
+// considered down.
+var ErrCircuitOpen = errors.New("accrual circuit breaker is open")
+
+// breakerMetrics are published at /debug/vars as "accrual_breaker".
+var breakerMetrics = expvar.NewMap("accrual_breaker")
+
+// BreakerStatus is a snapshot of the breaker for the status endpoint.
+type BreakerStatus struct {
+	OpenedAt *time.Time   `json:"opened_at,omitempty"`
+	RetryAt  *time.Time   `json:"retry_at,omitempty"`
+	State    BreakerState `json:"state"`
+	Failures int          `json:"failures"`
+}
+
+// Breaker stops calling the accrual system after failureThreshold failures in
+// a row. After openTimeout it lets up to probes requests through and closes
+// again once all of them succeed; a failed probe opens it again.
+type Breaker struct {
+	openedAt         time.Time
+	logger           *zap.Logger
+	now              func() time.Time
+	state            BreakerState
+	openTimeout      time.Duration
+	failureThreshold int
+	probes           int
+	failures         int
+	probesInFlight   int
+	probesPassed     int
+	mu               sync.Mutex
+}
+
+func NewBreaker(logger *zap.Logger, failureThreshold int, openTimeout time.Duration, probes int) *Breaker {
+	breakerMetrics.Set("state", stringVar(string(BreakerClosed)))
+	return &Breaker{
+		logger:           logger,
+		now:              time.Now,
+		state:            BreakerClosed,
+		openTimeout:      openTimeout,
+		failureThreshold: max(failureThreshold, 1),
+		probes:           max(probes, 1),
+	}
+}
+
+// State returns the current state. An open breaker whose timeout passed is
+// reported as half-open, as the next request will be a probe.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.currentState()
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{State: b.currentState(), Failures: b.failures}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		retryAt := b.openedAt.Add(b.openTimeout)
+		status.OpenedAt = &openedAt
+		status.RetryAt = &retryAt
+	}
+	return status
+}
+
+func (b *Breaker) currentState() BreakerState {
+	if b.state == BreakerOpen && !b.now().Before(b.openedAt.Add(b.openTimeout)) {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// allow reports whether a request may be sent and whether it is a probe.
+func (b *Breaker) allow() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if b.now().Before(b.openedAt.Add(b.openTimeout)) {
+			return false, ErrCircuitOpen
+		}
+		b.setState(BreakerHalfOpen)
+		b.probesInFlight = 0
+		b.probesPassed = 0
+	}
+	if b.state == BreakerHalfOpen {
+		if b.probesInFlight+b.probesPassed >= b.probes {
+			return false, ErrCircuitOpen
+		}
+		b.probesInFlight++
+		return true, nil
+	}
+	return false, nil
+}
+
+// record counts the outcome of a request allowed by allow.
+func (b *Breaker) record(probe, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe && b.probesInFlight > 0 {
+		b.probesInFlight--
+	}
+	// Requests sent before the breaker opened do not count any more.
+	if (!probe && b.state != BreakerClosed) || (probe && b.state != BreakerHalfOpen) {
+		return
+	}
+
+	if failed {
+		b.failures++
+		if probe || b.failures >= b.failureThreshold {
+			b.openedAt = b.now()
+			b.setState(BreakerOpen)
+		}
+		return
+	}
+
+	b.failures = 0
+	if probe {
+		b.probesPassed++
+		if b.probesPassed >= b.probes {
+			b.setState(BreakerClosed)
+		}
+	}
+}
+
+// abandon forgets a request given up by the caller, which says nothing about
+// the accrual system.
+func (b *Breaker) abandon(probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe && b.probesInFlight > 0 {
+		b.probesInFlight--
+	}
+}
+
+func (b *Breaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	b.state = state
+	breakerMetrics.Set("state", stringVar(string(state)))
+
+	switch state {
+	case BreakerOpen:
+		breakerMetrics.Add("opened", 1)
+		b.logger.Warn("accrual system is failing, circuit breaker opened",
+			zap.Int("failures", b.failures),
+			zap.Time("retry_at", b.openedAt.Add(b.openTimeout)),
+		)
+	case BreakerHalfOpen:
+		b.logger.Info("circuit breaker half-open, probing accrual system")
+	case BreakerClosed:
+		b.logger.Info("accrual system is back, circuit breaker closed")
+	}
+}
+
+// BreakerClient sends requests through a circuit breaker.
+type BreakerClient struct {
+	client  Client
+	breaker *Breaker
+}
+
+func NewBreakerClient(client Client, breaker *Breaker) *BreakerClient {
+	return &BreakerClient{
+		client:  client,
+		breaker: breaker,
+	}
+}
+
+func (c *BreakerClient) GetOrder(ctx context.Context, number string) (Result, error) {
+	probe, err := c.breaker.allow()
+	if err != nil {
+		return Result{}, err
+	}
+
+	result, err := c.client.GetOrder(ctx, number)
+	if ctx.Err() != nil {
+		c.breaker.abandon(probe)
+		return result, err
+	}
+	c.breaker.record(probe, isFailure(err))
+	return result, err
+}
+
+// isFailure tells errors of an accrual system in trouble from answers it gave
+// about the order.
+func isFailure(err error) bool {
+	var rateLimit *RateLimitError
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, ErrOrderNotRegistered), errors.Is(err, ErrUnknownStatus), errors.As(err, &rateLimit):
+		return false
+	default:
+		return true
+	}
+}
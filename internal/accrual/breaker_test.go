@@ -0,0 +1,115 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeClient struct {
+	err   error
+	calls int
+}
+
+func (c *fakeClient) GetOrder(context.Context, string) (Result, error) {
+	c.calls++
+	return Result{}, c.err
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	breaker := NewBreaker(zap.NewNop(), 3, 30*time.Second, 1)
+	breaker.now = func() time.Time { return now }
+
+	accrual := &fakeClient{err: &ServerError{StatusCode: 500}}
+	client := NewBreakerClient(accrual, breaker)
+	ctx := context.Background()
+
+	t.Run("Opens After Threshold", func(t *testing.T) {
+		for range 3 {
+			_, err := client.GetOrder(ctx, "2377225624")
+			assert.Error(t, err)
+		}
+		assert.Equal(t, BreakerOpen, breaker.State())
+
+		_, err := client.GetOrder(ctx, "2377225624")
+		assert.ErrorIs(t, err, ErrCircuitOpen)
+		assert.Equal(t, 3, accrual.calls)
+
+		status := breaker.Status()
+		require.NotNil(t, status.RetryAt)
+		assert.Equal(t, now.Add(30*time.Second), *status.RetryAt)
+	})
+
+	t.Run("Failed Probe Reopens", func(t *testing.T) {
+		now = now.Add(30 * time.Second)
+		assert.Equal(t, BreakerHalfOpen, breaker.State())
+
+		_, err := client.GetOrder(ctx, "2377225624")
+		var serverErr *ServerError
+		assert.ErrorAs(t, err, &serverErr)
+		assert.Equal(t, BreakerOpen, breaker.State())
+		assert.Equal(t, 4, accrual.calls)
+	})
+
+	t.Run("Probe Closes", func(t *testing.T) {
+		now = now.Add(30 * time.Second)
+		accrual.err = nil
+
+		_, err := client.GetOrder(ctx, "2377225624")
+		assert.NoError(t, err)
+		assert.Equal(t, BreakerClosed, breaker.State())
+		assert.Nil(t, breaker.Status().OpenedAt)
+	})
+
+	t.Run("Success Resets Failures", func(t *testing.T) {
+		accrual.err = errors.New("connection refused")
+		_, _ = client.GetOrder(ctx, "2377225624")
+		_, _ = client.GetOrder(ctx, "2377225624")
+		accrual.err = nil
+		_, _ = client.GetOrder(ctx, "2377225624")
+		accrual.err = errors.New("connection refused")
+		_, _ = client.GetOrder(ctx, "2377225624")
+
+		assert.Equal(t, BreakerClosed, breaker.State())
+		assert.Equal(t, 1, breaker.Status().Failures)
+	})
+}
+
+func TestBreakerIgnores(t *testing.T) {
+	tests := []struct {
+		err  error
+		name string
+	}{
+		{name: "Not Registered", err: ErrOrderNotRegistered},
+		{name: "Unknown Status", err: ErrUnknownStatus},
+		{name: "Rate Limit", err: &RateLimitError{RetryAfter: time.Minute}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker := NewBreaker(zap.NewNop(), 1, time.Minute, 1)
+			client := NewBreakerClient(&fakeClient{err: tt.err}, breaker)
+
+			_, err := client.GetOrder(context.Background(), "2377225624")
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, BreakerClosed, breaker.State())
+		})
+	}
+
+	t.Run("Canceled", func(t *testing.T) {
+		breaker := NewBreaker(zap.NewNop(), 1, time.Minute, 1)
+		client := NewBreakerClient(&fakeClient{err: context.Canceled}, breaker)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := client.GetOrder(ctx, "2377225624")
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, BreakerClosed, breaker.State())
+	})
+}
@@ -0,0 +1,208 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"github.com/krasvl/market/internal/storage"
+)
+
+// Statuses the accrual system reports for an order.
+const (
+	StatusRegistered = "REGISTERED"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+)
+
+// DefaultRetryAfter is how long to wait after a 429 without a usable
+// Retry-After header.
+const DefaultRetryAfter = 60 * time.Second
+
+var (
+	// ErrOrderNotRegistered is returned when the accrual system does not
+	// know the order (204 No Content).
+	ErrOrderNotRegistered = errors.New("order is not registered in the accrual system")
+	ErrUnknownStatus      = errors.New("unknown accrual status")
+)
+
+// quotaPattern finds the quota in a 429 body such as
+// "No more than 10 requests per minute allowed".
+var quotaPattern = regexp.MustCompile(`(?i)(\d+)\s+requests?\s+per\s+minute`)
+
+// RateLimitError is returned when the accrual system asks to slow down (429).
+// Quota is the number of requests per minute it allows, zero if unknown.
+type RateLimitError struct {
+	RetryAfter time.Duration
+	Quota      int
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("accrual system rate limit exceeded, retry after %s", e.RetryAfter)
+}
+
+// ServerError is returned when the accrual system fails (5xx).
+type ServerError struct {
+	StatusCode int
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("accrual system error: status %d", e.StatusCode)
+}
+
+// Result is the state of an order in the accrual system. Status is already
+// mapped to the order statuses of the loyalty system.
+type Result struct {
+	Order   string
+	Status  storage.OrderStatus
+	Accrual money.Amount
+}
+
+// Client asks the accrual system about orders.
+type Client interface {
+	GetOrder(ctx context.Context, number string) (Result, error)
+}
+
+type HTTPClient struct {
+	client *http.Client
+	now    func() time.Time
+	addr   string
+}
+
+// NewClient creates a client of the accrual system at addr. timeout bounds a
+// whole request; idleTimeout is how long an idle connection is kept alive for
+// the next request, zero disables keep-alive.
+func NewClient(addr string, timeout, idleTimeout time.Duration) *HTTPClient {
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		IdleConnTimeout:       idleTimeout,
+		MaxIdleConnsPerHost:   16,
+		DisableKeepAlives:     idleTimeout == 0,
+	}
+	return &HTTPClient{
+		client: &http.Client{Transport: transport, Timeout: timeout},
+		now:    time.Now,
+		addr:   addr,
+	}
+}
+
+func (c *HTTPClient) GetOrder(ctx context.Context, number string) (Result, error) {
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodGet, c.addr+"/api/orders/"+url.PathEscape(number), http.NoBody,
+	)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to get order: %w", err)
+	}
+	defer func() {
+		// Reading the rest of the body lets the connection be reused.
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		_ = resp.Body.Close()
+	}()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return decodeResult(resp.Body)
+	case resp.StatusCode == http.StatusNoContent:
+		return Result{}, ErrOrderNotRegistered
+	case resp.StatusCode == http.StatusTooManyRequests:
+		retryAfter, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), c.now())
+		if !ok {
+			retryAfter = DefaultRetryAfter
+		}
+		return Result{}, &RateLimitError{RetryAfter: retryAfter, Quota: parseQuota(resp.Body)}
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return Result{}, &ServerError{StatusCode: resp.StatusCode}
+	default:
+		return Result{}, fmt.Errorf("unexpected status from accrual system: %d", resp.StatusCode)
+	}
+}
+
+func decodeResult(body io.Reader) (Result, error) {
+	var response struct {
+		Order   string       `json:"order"`
+		Status  string       `json:"status"`
+		Accrual money.Amount `json:"accrual,omitempty"`
+	}
+	if err := json.NewDecoder(body).Decode(&response); err != nil {
+		return Result{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	status, err := orderStatus(response.Status)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{Order: response.Order, Status: status}
+	// Only processed orders have an accrual.
+	if status == storage.StatusProcessed {
+		result.Accrual = response.Accrual
+	}
+	return result, nil
+}
+
+// orderStatus maps an accrual status to the order status. A registered order
+// is not being calculated yet, for the user it is processing all the same.
+func orderStatus(status string) (storage.OrderStatus, error) {
+	switch status {
+	case StatusRegistered, StatusProcessing:
+		return storage.StatusProcessing, nil
+	case StatusInvalid:
+		return storage.StatusInvalid, nil
+	case StatusProcessed:
+		return storage.StatusProcessed, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownStatus, status)
+	}
+}
+
+func parseQuota(body io.Reader) int {
+	text, err := io.ReadAll(io.LimitReader(body, 1024))
+	if err != nil {
+		return 0
+	}
+	match := quotaPattern.FindSubmatch(text)
+	if match == nil {
+		return 0
+	}
+	quota, err := strconv.Atoi(string(match[1]))
+	if err != nil {
+		return 0
+	}
+	return quota
+}
+
+// ParseRetryAfter reads a Retry-After header given either in seconds or as
+// an HTTP date. A date in the past means no wait.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,165 @@
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"github.com/krasvl/market/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOrder(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		wantErr    error
+		headers    map[string]string
+		name       string
+		body       string
+		want       Result
+		status     int
+		retryAfter time.Duration
+	}{
+		{
+			name:   "Registered",
+			status: http.StatusOK,
+			body:   `{"order": "2377225624", "status": "REGISTERED"}`,
+			want:   Result{Order: "2377225624", Status: storage.StatusProcessing},
+		},
+		{
+			name:   "Processing",
+			status: http.StatusOK,
+			body:   `{"order": "2377225624", "status": "PROCESSING"}`,
+			want:   Result{Order: "2377225624", Status: storage.StatusProcessing},
+		},
+		{
+			name:   "Invalid",
+			status: http.StatusOK,
+			body:   `{"order": "2377225624", "status": "INVALID"}`,
+			want:   Result{Order: "2377225624", Status: storage.StatusInvalid},
+		},
+		{
+			name:   "Processed",
+			status: http.StatusOK,
+			body:   `{"order": "2377225624", "status": "PROCESSED", "accrual": 729.98}`,
+			want:   Result{Order: "2377225624", Status: storage.StatusProcessed, Accrual: money.Amount(72998)},
+		},
+		{
+			name:    "Unknown Status",
+			status:  http.StatusOK,
+			body:    `{"order": "2377225624", "status": "LOST"}`,
+			wantErr: ErrUnknownStatus,
+		},
+		{
+			name:    "Not Registered",
+			status:  http.StatusNoContent,
+			wantErr: ErrOrderNotRegistered,
+		},
+		{
+			name:       "Retry After Seconds",
+			status:     http.StatusTooManyRequests,
+			headers:    map[string]string{"Retry-After": "30"},
+			retryAfter: 30 * time.Second,
+		},
+		{
+			name:       "Retry After Date",
+			status:     http.StatusTooManyRequests,
+			headers:    map[string]string{"Retry-After": now.Add(2 * time.Minute).Format(http.TimeFormat)},
+			retryAfter: 2 * time.Minute,
+		},
+		{
+			name:       "Retry After Missing",
+			status:     http.StatusTooManyRequests,
+			retryAfter: DefaultRetryAfter,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/api/orders/2377225624", r.URL.Path)
+				for key, value := range tt.headers {
+					w.Header().Set(key, value)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			client := NewClient(server.URL, time.Second, time.Minute)
+			client.now = func() time.Time { return now }
+
+			result, err := client.GetOrder(context.Background(), "2377225624")
+			switch {
+			case tt.retryAfter != 0:
+				var rateLimit *RateLimitError
+				require.ErrorAs(t, err, &rateLimit)
+				assert.Equal(t, tt.retryAfter, rateLimit.RetryAfter)
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, result)
+			}
+		})
+	}
+
+	t.Run("Server Error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		_, err := NewClient(server.URL, time.Second, 0).GetOrder(context.Background(), "2377225624")
+		var serverErr *ServerError
+		require.ErrorAs(t, err, &serverErr)
+		assert.Equal(t, http.StatusServiceUnavailable, serverErr.StatusCode)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			<-release
+		}))
+		defer server.Close()
+		defer close(release)
+
+		start := time.Now()
+		_, err := NewClient(server.URL, 50*time.Millisecond, 0).GetOrder(context.Background(), "2377225624")
+		assert.Error(t, err)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{name: "Seconds", value: "120", want: 2 * time.Minute, ok: true},
+		{name: "Zero", value: "0", ok: true},
+		{name: "Date", value: "Wed, 01 May 2024 12:00:45 GMT", want: 45 * time.Second, ok: true},
+		{name: "Past Date", value: "Wed, 01 May 2024 11:00:00 GMT", ok: true},
+		{name: "Negative", value: "-5"},
+		{name: "Garbage", value: "soon"},
+		{name: "Empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseRetryAfter(tt.value, now)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// quotaTTL is how long a quota learned from the accrual system is kept. After
+// that the configured rate applies again, in case the quota was raised.
+const quotaTTL = 10 * time.Minute
+
+// limiterMetrics are published at /debug/vars as "accrual_limiter".
+var limiterMetrics = expvar.NewMap("accrual_limiter")
+
+// LimiterStatus is a snapshot of the limiter for the status endpoint.
+type LimiterStatus struct {
+	PausedUntil   *time.Time `json:"paused_until,omitempty"`
+	RatePerMinute float64    `json:"rate_per_minute"`
+	Paused        bool       `json:"paused"`
+}
+
+// Limiter is a token bucket shared by everyone calling the accrual system.
+// When the accrual system answers 429 it pauses all callers until Retry-After
+// passes and slows down to the quota it reported.
+type Limiter struct {
+	last        time.Time
+	pausedUntil time.Time
+	quotaUntil  time.Time
+	logger      *zap.Logger
+	now         func() time.Time
+	// rate and quota are in requests per second, zero means no limit.
+	rate   float64
+	quota  float64
+	burst  float64
+	tokens float64
+	mu     sync.Mutex
+}
+
+// NewLimiter creates a limiter allowing perMinute requests per minute with
+// bursts of up to burst requests. perMinute zero sets no limit until the
+// accrual system reports its quota.
+func NewLimiter(logger *zap.Logger, perMinute, burst int) *Limiter {
+	l := &Limiter{
+		logger: logger,
+		now:    time.Now,
+		rate:   float64(perMinute) / 60,
+		burst:  float64(max(burst, 1)),
+		tokens: float64(max(burst, 1)),
+	}
+	l.last = l.now()
+	limiterMetrics.Set("rate_per_minute", floatVar(float64(perMinute)))
+	return l
+}
+
+// Wait blocks until a request may be sent or ctx is done.
+func (l *Limiter) Wait(ctx context.Context) error {
+	delay := l.reserve()
+	if delay > 0 {
+		limiterMetrics.Add("throttled", 1)
+	}
+	for delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		delay = l.reserve()
+	}
+	return nil
+}
+
+// Paused reports whether the accrual system asked to wait.
+func (l *Limiter) Paused() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.now().Before(l.pausedUntil)
+}
+
+// Status reports whether requests are paused and the current rate, zero if
+// there is no limit.
+func (l *Limiter) Status() LimiterStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	status := LimiterStatus{RatePerMinute: l.currentRate(now) * 60}
+	if now.Before(l.pausedUntil) {
+		pausedUntil := l.pausedUntil
+		status.Paused = true
+		status.PausedUntil = &pausedUntil
+	}
+	return status
+}
+
+// Pause stops all requests for retryAfter. A known quota, in requests per
+// minute, becomes the rate limit for a while.
+func (l *Limiter) Pause(retryAfter time.Duration, quota int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if until := now.Add(retryAfter); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+	if quota > 0 {
+		l.quota = float64(quota) / 60
+		l.quotaUntil = now.Add(quotaTTL)
+	}
+	// Only one request goes out right after the pause, the rest follow at
+	// the allowed rate.
+	l.tokens = 1
+	l.last = l.pausedUntil
+
+	rate := l.currentRate(now) * 60
+	limiterMetrics.Add("pauses", 1)
+	limiterMetrics.Set("paused_until", stringVar(l.pausedUntil.UTC().Format(time.RFC3339)))
+	limiterMetrics.Set("rate_per_minute", floatVar(rate))
+	l.logger.Warn("accrual system rate limit, pausing requests",
+		zap.Duration("retry_after", retryAfter),
+		zap.Int("quota_per_minute", quota),
+		zap.Float64("rate_per_minute", rate),
+	)
+}
+
+// reserve takes a token and returns zero, or returns how long to wait before
+// trying again.
+func (l *Limiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+
+	rate := l.currentRate(now)
+	if rate == 0 {
+		return 0
+	}
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = min(l.burst, l.tokens+elapsed.Seconds()*rate)
+		l.last = now
+	}
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / rate * float64(time.Second))
+}
+
+func (l *Limiter) currentRate(now time.Time) float64 {
+	if l.quota == 0 || !now.Before(l.quotaUntil) {
+		if l.quota != 0 {
+			l.quota = 0
+			limiterMetrics.Set("rate_per_minute", floatVar(l.rate*60))
+			l.logger.Info("accrual quota expired, back to the configured rate",
+				zap.Float64("rate_per_minute", l.rate*60),
+			)
+		}
+		return l.rate
+	}
+	if l.rate == 0 {
+		return l.quota
+	}
+	return min(l.rate, l.quota)
+}
+
+// LimitedClient sends requests through a limiter and pauses it when the
+// accrual system answers 429.
+type LimitedClient struct {
+	client  Client
+	limiter *Limiter
+}
+
+func NewLimitedClient(client Client, limiter *Limiter) *LimitedClient {
+	return &LimitedClient{
+		client:  client,
+		limiter: limiter,
+	}
+}
+
+func (c *LimitedClient) GetOrder(ctx context.Context, number string) (Result, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return Result{}, err
+	}
+
+	result, err := c.client.GetOrder(ctx, number)
+	var rateLimit *RateLimitError
+	if errors.As(err, &rateLimit) {
+		c.limiter.Pause(rateLimit.RetryAfter, rateLimit.Quota)
+	}
+	return result, err
+}
+
+func floatVar(value float64) *expvar.Float {
+	v := new(expvar.Float)
+	v.Set(value)
+	return v
+}
+
+func stringVar(value string) *expvar.String {
+	v := new(expvar.String)
+	v.Set(value)
+	return v
+}
@@ -0,0 +1,94 @@
+package accrual
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(zap.NewNop(), 60, 2)
+	limiter.now = func() time.Time { return now }
+	limiter.last = now
+
+	t.Run("Burst Then Pace", func(t *testing.T) {
+		assert.Zero(t, limiter.reserve())
+		assert.Zero(t, limiter.reserve())
+		assert.Equal(t, time.Second, limiter.reserve())
+
+		now = now.Add(time.Second)
+		assert.Zero(t, limiter.reserve())
+	})
+
+	t.Run("Pause", func(t *testing.T) {
+		limiter.Pause(30*time.Second, 0)
+		assert.True(t, limiter.Paused())
+		assert.Equal(t, 30*time.Second, limiter.reserve())
+
+		now = now.Add(30 * time.Second)
+		assert.False(t, limiter.Paused())
+		assert.Zero(t, limiter.reserve())
+	})
+
+	t.Run("Quota Slows Down", func(t *testing.T) {
+		limiter.Pause(time.Second, 10)
+		now = now.Add(time.Second)
+		assert.Zero(t, limiter.reserve())
+		assert.Equal(t, 6*time.Second, limiter.reserve())
+	})
+
+	t.Run("Quota Expires", func(t *testing.T) {
+		now = now.Add(quotaTTL)
+		assert.Zero(t, limiter.reserve())
+		assert.Zero(t, limiter.reserve())
+		assert.Equal(t, time.Second, limiter.reserve())
+	})
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	limiter := NewLimiter(zap.NewNop(), 0, 1)
+	for range 100 {
+		assert.Zero(t, limiter.reserve())
+	}
+
+	limiter.Pause(time.Minute, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, limiter.Wait(ctx), context.DeadlineExceeded)
+}
+
+func TestLimitedClient(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = w.Write([]byte("No more than 10 requests per minute allowed"))
+	}))
+	defer server.Close()
+
+	limiter := NewLimiter(zap.NewNop(), 0, 1)
+	client := NewLimitedClient(NewClient(server.URL, time.Second, 0), limiter)
+
+	_, err := client.GetOrder(context.Background(), "2377225624")
+	var rateLimit *RateLimitError
+	require.ErrorAs(t, err, &rateLimit)
+	assert.Equal(t, 10, rateLimit.Quota)
+	assert.Equal(t, time.Minute, rateLimit.RetryAfter)
+	assert.True(t, limiter.Paused())
+
+	// Everyone waits for the pause, nothing reaches the accrual system.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = client.GetOrder(ctx, "2377225624")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int32(1), requests.Load())
+}
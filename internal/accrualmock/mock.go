@@ -0,0 +1,334 @@
+// Package accrualmock stands in for the accrual system in development and
+// tests. It serves GET /api/orders/{number} as the specification describes,
+// and registers goods rules and orders like the real system:
+//
+//	POST /api/goods  {"match": "Bork", "reward": 10, "reward_type": "%"}
+//	POST /api/orders {"order": "2377225624", "goods": [{"description": "Bork kettle", "price": 7000}]}
+//
+// Faults are injected with POST /api/mock/faults {"status": 503, "count": 3}.
+package accrualmock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"github.com/krasvl/market/internal/utils"
+	"go.uber.org/zap"
+)
+
+// Statuses of an order in the accrual system.
+const (
+	StatusRegistered = "REGISTERED"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+)
+
+// Reward types of a goods rule: a percentage of the price or fixed points.
+const (
+	RewardPercent = "%"
+	RewardPoints  = "pt"
+)
+
+var (
+	ErrInvalidRule     = errors.New("invalid goods rule")
+	ErrRuleExists      = errors.New("goods rule already exists")
+	ErrInvalidOrder    = errors.New("invalid order")
+	ErrOrderRegistered = errors.New("order is already registered")
+)
+
+// Config sets how the mock behaves.
+type Config struct {
+	// RegisteredFor and ProcessingFor are how long an order stays
+	// REGISTERED and then PROCESSING before its accrual is calculated.
+	RegisteredFor time.Duration
+	ProcessingFor time.Duration
+	// RateLimit is the number of order requests allowed per minute, zero
+	// for no limit.
+	RateLimit int
+	// FailEvery makes every n-th order request fail with 500, zero never.
+	FailEvery int
+}
+
+// Rule rewards goods whose description contains Match, ignoring case.
+type Rule struct {
+	Match      string       `json:"match"`
+	RewardType string       `json:"reward_type"`
+	Reward     money.Amount `json:"reward"`
+}
+
+type Good struct {
+	Description string       `json:"description"`
+	Price       money.Amount `json:"price"`
+}
+
+type order struct {
+	registeredAt time.Time
+	goods        []Good
+}
+
+// OrderResponse is the answer to GET /api/orders/{number}.
+type OrderResponse struct {
+	Order   string       `json:"order"`
+	Status  string       `json:"status"`
+	Accrual money.Amount `json:"accrual,omitempty"`
+}
+
+// Mock keeps everything in memory. Statuses follow from the registration time
+// and the clock, so a fake clock makes tests deterministic.
+type Mock struct {
+	windowStart time.Time
+	logger      *zap.Logger
+	now         func() time.Time
+	orders      map[string]order
+	rules       []Rule
+	cfg         Config
+	windowCount int
+	requests    int
+	faultStatus int
+	faultCount  int
+	mu          sync.Mutex
+}
+
+func New(logger *zap.Logger, cfg Config) *Mock {
+	return &Mock{
+		logger: logger,
+		now:    time.Now,
+		orders: make(map[string]order),
+		cfg:    cfg,
+	}
+}
+
+// AddRule adds a goods rule. Each match may only be added once.
+func (m *Mock) AddRule(rule Rule) error {
+	if rule.Match == "" || rule.Reward < 0 || (rule.RewardType != RewardPercent && rule.RewardType != RewardPoints) {
+		return ErrInvalidRule
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, r := range m.rules {
+		if strings.EqualFold(r.Match, rule.Match) {
+			return ErrRuleExists
+		}
+	}
+	m.rules = append(m.rules, rule)
+	return nil
+}
+
+// RegisterOrder registers an order for calculation.
+func (m *Mock) RegisterOrder(number string, goods []Good) error {
+	if !utils.IsValidLuhn(number) {
+		return fmt.Errorf("%w: bad number %q", ErrInvalidOrder, number)
+	}
+	for _, good := range goods {
+		if good.Price < 0 {
+			return fmt.Errorf("%w: negative price of %q", ErrInvalidOrder, good.Description)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.orders[number]; ok {
+		return ErrOrderRegistered
+	}
+	m.orders[number] = order{registeredAt: m.now(), goods: goods}
+	return nil
+}
+
+// InjectFaults makes the next count order requests fail with status.
+func (m *Mock) InjectFaults(status, count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.faultStatus = status
+	m.faultCount = count
+}
+
+// Order returns the state of an order, false if it is not registered.
+func (m *Mock) Order(number string) (OrderResponse, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	o, ok := m.orders[number]
+	if !ok {
+		return OrderResponse{}, false
+	}
+
+	response := OrderResponse{Order: number}
+	elapsed := m.now().Sub(o.registeredAt)
+	switch {
+	case elapsed < m.cfg.RegisteredFor:
+		response.Status = StatusRegistered
+	case elapsed < m.cfg.RegisteredFor+m.cfg.ProcessingFor:
+		response.Status = StatusProcessing
+	default:
+		accrual, matched := m.accrual(o.goods)
+		if !matched {
+			response.Status = StatusInvalid
+			break
+		}
+		response.Status = StatusProcessed
+		response.Accrual = accrual
+	}
+	return response, true
+}
+
+// accrual adds up the rewards of the goods, each rewarded by the first rule
+// matching it. An order without rewarded goods is not accepted.
+func (m *Mock) accrual(goods []Good) (money.Amount, bool) {
+	var total money.Amount
+	matched := false
+	for _, good := range goods {
+		for _, rule := range m.rules {
+			if !strings.Contains(strings.ToLower(good.Description), strings.ToLower(rule.Match)) {
+				continue
+			}
+			matched = true
+			if rule.RewardType == RewardPoints {
+				total += rule.Reward
+			} else {
+				// Both are in hundredths, so the product is in millionths of
+				// a point; round it to hundredths.
+				total += money.Amount((int64(good.Price)*int64(rule.Reward) + 5000) / 10000)
+			}
+			break
+		}
+	}
+	return total, matched
+}
+
+// admit counts an order request against the rate limit and the injected
+// faults. It returns zero if the request may be answered, otherwise the
+// status to fail it with and, for 429, how long to wait.
+func (m *Mock) admit() (int, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if m.cfg.RateLimit > 0 {
+		if now.Sub(m.windowStart) >= time.Minute {
+			m.windowStart = now
+			m.windowCount = 0
+		}
+		if m.windowCount >= m.cfg.RateLimit {
+			return http.StatusTooManyRequests, m.windowStart.Add(time.Minute).Sub(now)
+		}
+		m.windowCount++
+	}
+
+	m.requests++
+	if m.faultCount > 0 {
+		m.faultCount--
+		return m.faultStatus, 0
+	}
+	if m.cfg.FailEvery > 0 && m.requests%m.cfg.FailEvery == 0 {
+		return http.StatusInternalServerError, 0
+	}
+	return 0, 0
+}
+
+// Handler serves the accrual system API and the fault injection.
+func (m *Mock) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/orders/{number}", m.getOrder)
+	mux.HandleFunc("POST /api/orders", m.registerOrder)
+	mux.HandleFunc("POST /api/goods", m.addRule)
+	mux.HandleFunc("POST /api/mock/faults", m.injectFaults)
+	return mux
+}
+
+func (m *Mock) getOrder(w http.ResponseWriter, r *http.Request) {
+	status, retryAfter := m.admit()
+	switch {
+	case status == http.StatusTooManyRequests:
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		_, _ = fmt.Fprintf(w, "No more than %d requests per minute allowed", m.cfg.RateLimit)
+		return
+	case status != 0:
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	response, ok := m.Order(r.PathValue("number"))
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	m.writeJSON(w, http.StatusOK, response)
+}
+
+func (m *Mock) registerOrder(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Order string `json:"order"`
+		Goods []Good `json:"goods"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err := m.RegisterOrder(req.Order, req.Goods)
+	switch {
+	case errors.Is(err, ErrOrderRegistered):
+		http.Error(w, "Order already registered", http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (m *Mock) addRule(w http.ResponseWriter, r *http.Request) {
+	var rule Rule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	err := m.AddRule(rule)
+	switch {
+	case errors.Is(err, ErrRuleExists):
+		http.Error(w, "Rule already exists", http.StatusConflict)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (m *Mock) injectFaults(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Status int `json:"status"`
+		Count  int `json:"count"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil ||
+		req.Status < http.StatusInternalServerError || req.Status > 599 || req.Count < 0 {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	m.InjectFaults(req.Status, req.Count)
+	m.logger.Info("faults injected", zap.Int("status", req.Status), zap.Int("count", req.Count))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (m *Mock) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		m.logger.Error("failed to write response", zap.Error(err))
+	}
+}
@@ -0,0 +1,265 @@
+package accrualmock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krasvl/market/internal/accrual"
+	"github.com/krasvl/market/internal/money"
+	"github.com/krasvl/market/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newMock(t *testing.T, cfg Config) (*Mock, *fakeClock) {
+	t.Helper()
+
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	mock := New(zap.NewNop(), cfg)
+	mock.now = clock.Now
+	return mock, clock
+}
+
+func do(t *testing.T, handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+func TestOrderLifecycle(t *testing.T) {
+	mock, clock := newMock(t, Config{RegisteredFor: time.Second, ProcessingFor: 2 * time.Second})
+	handler := mock.Handler()
+
+	require.Equal(t, http.StatusOK,
+		do(t, handler, http.MethodPost, "/api/goods", `{"match": "bork", "reward": 10, "reward_type": "%"}`).Code)
+	require.Equal(t, http.StatusOK,
+		do(t, handler, http.MethodPost, "/api/goods", `{"match": "Spoon", "reward": 5.5, "reward_type": "pt"}`).Code)
+
+	w := do(t, handler, http.MethodPost, "/api/orders",
+		`{"order": "2377225624", "goods": [
+			{"description": "Bork kettle", "price": 7000},
+			{"description": "Silver spoon", "price": 100},
+			{"description": "Bread", "price": 3}
+		]}`)
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	tests := []struct {
+		name    string
+		want    string
+		advance time.Duration
+	}{
+		{name: "Registered", want: `{"order": "2377225624", "status": "REGISTERED"}`},
+		{name: "Processing", advance: time.Second, want: `{"order": "2377225624", "status": "PROCESSING"}`},
+		{
+			name:    "Processed",
+			advance: 2 * time.Second,
+			want:    `{"order": "2377225624", "status": "PROCESSED", "accrual": 705.5}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+
+			w := do(t, handler, http.MethodGet, "/api/orders/2377225624", "")
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			assert.JSONEq(t, tt.want, w.Body.String())
+		})
+	}
+
+	t.Run("Not Registered", func(t *testing.T) {
+		w := do(t, handler, http.MethodGet, "/api/orders/12345678903", "")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+}
+
+func TestOrderInvalid(t *testing.T) {
+	mock, _ := newMock(t, Config{})
+	require.NoError(t, mock.AddRule(Rule{Match: "Bork", Reward: 1000, RewardType: RewardPercent}))
+	require.NoError(t, mock.RegisterOrder("2377225624", []Good{{Description: "Bread", Price: 300}}))
+
+	response, ok := mock.Order("2377225624")
+	require.True(t, ok)
+	assert.Equal(t, OrderResponse{Order: "2377225624", Status: StatusInvalid}, response)
+}
+
+func TestAccrualRounding(t *testing.T) {
+	mock, _ := newMock(t, Config{})
+	require.NoError(t, mock.AddRule(Rule{Match: "Bork", Reward: 333, RewardType: RewardPercent}))
+	// The first matching rule wins.
+	require.NoError(t, mock.AddRule(Rule{Match: "kettle", Reward: 10000, RewardType: RewardPoints}))
+	require.NoError(t, mock.RegisterOrder("2377225624", []Good{{Description: "Bork kettle", Price: 1015}}))
+
+	response, ok := mock.Order("2377225624")
+	require.True(t, ok)
+	// 3.33% of 10.15 is 0.337995.
+	assert.Equal(t, money.Amount(34), response.Accrual)
+}
+
+func TestRegistrationErrors(t *testing.T) {
+	mock, _ := newMock(t, Config{})
+	handler := mock.Handler()
+	require.NoError(t, mock.AddRule(Rule{Match: "Bork", Reward: 1000, RewardType: RewardPercent}))
+	require.NoError(t, mock.RegisterOrder("2377225624", nil))
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		status int
+	}{
+		{name: "Invalid JSON", target: "/api/goods", body: "{", status: http.StatusBadRequest},
+		{
+			name:   "Unknown Reward Type",
+			target: "/api/goods",
+			body:   `{"match": "Spoon", "reward": 1, "reward_type": "x"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "Rule Exists",
+			target: "/api/goods",
+			body:   `{"match": "bork", "reward": 1, "reward_type": "pt"}`,
+			status: http.StatusConflict,
+		},
+		{name: "Bad Number", target: "/api/orders", body: `{"order": "2377225625"}`, status: http.StatusBadRequest},
+		{
+			name:   "Negative Price",
+			target: "/api/orders",
+			body:   `{"order": "12345678903", "goods": [{"description": "Bork", "price": -1}]}`,
+			status: http.StatusBadRequest,
+		},
+		{name: "Order Exists", target: "/api/orders", body: `{"order": "2377225624"}`, status: http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(t, handler, http.MethodPost, tt.target, tt.body)
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	mock, clock := newMock(t, Config{RateLimit: 2})
+	handler := mock.Handler()
+
+	for range 2 {
+		assert.Equal(t, http.StatusNoContent, do(t, handler, http.MethodGet, "/api/orders/2377225624", "").Code)
+	}
+
+	clock.Advance(15500 * time.Millisecond)
+	w := do(t, handler, http.MethodGet, "/api/orders/2377225624", "")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "45", w.Header().Get("Retry-After"))
+	assert.Equal(t, "No more than 2 requests per minute allowed", w.Body.String())
+
+	// Rejected requests do not count, the next window starts afresh.
+	clock.Advance(45 * time.Second)
+	assert.Equal(t, http.StatusNoContent, do(t, handler, http.MethodGet, "/api/orders/2377225624", "").Code)
+}
+
+func TestFaults(t *testing.T) {
+	t.Run("Injected", func(t *testing.T) {
+		mock, _ := newMock(t, Config{})
+		handler := mock.Handler()
+
+		require.Equal(t, http.StatusOK,
+			do(t, handler, http.MethodPost, "/api/mock/faults", `{"status": 503, "count": 2}`).Code)
+		assert.Equal(t, http.StatusServiceUnavailable, do(t, handler, http.MethodGet, "/api/orders/2377225624", "").Code)
+		assert.Equal(t, http.StatusServiceUnavailable, do(t, handler, http.MethodGet, "/api/orders/2377225624", "").Code)
+		assert.Equal(t, http.StatusNoContent, do(t, handler, http.MethodGet, "/api/orders/2377225624", "").Code)
+	})
+
+	t.Run("Not A Server Error", func(t *testing.T) {
+		mock, _ := newMock(t, Config{})
+
+		w := do(t, mock.Handler(), http.MethodPost, "/api/mock/faults", `{"status": 404, "count": 1}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Every Third", func(t *testing.T) {
+		mock, _ := newMock(t, Config{FailEvery: 3})
+		handler := mock.Handler()
+
+		var statuses []int
+		for range 6 {
+			statuses = append(statuses, do(t, handler, http.MethodGet, "/api/orders/2377225624", "").Code)
+		}
+		assert.Equal(t, []int{204, 204, 500, 204, 204, 500}, statuses)
+	})
+}
+
+// TestClient checks the mock against the client the scheduler uses.
+func TestClient(t *testing.T) {
+	mock, clock := newMock(t, Config{RegisteredFor: time.Second, RateLimit: 3})
+	require.NoError(t, mock.AddRule(Rule{Match: "Bork", Reward: 1000, RewardType: RewardPercent}))
+	require.NoError(t, mock.RegisterOrder("2377225624", []Good{{Description: "Bork kettle", Price: 700000}}))
+
+	server := httptest.NewServer(mock.Handler())
+	defer server.Close()
+	client := accrual.NewClient(server.URL, time.Second, 0)
+	ctx := context.Background()
+
+	result, err := client.GetOrder(ctx, "2377225624")
+	require.NoError(t, err)
+	assert.Equal(t, storage.StatusProcessing, result.Status)
+
+	clock.Advance(time.Second)
+	result, err = client.GetOrder(ctx, "2377225624")
+	require.NoError(t, err)
+	assert.Equal(t, accrual.Result{Order: "2377225624", Status: storage.StatusProcessed, Accrual: 70000}, result)
+
+	_, err = client.GetOrder(ctx, "12345678903")
+	assert.ErrorIs(t, err, accrual.ErrOrderNotRegistered)
+
+	_, err = client.GetOrder(ctx, "2377225624")
+	var rateLimit *accrual.RateLimitError
+	require.ErrorAs(t, err, &rateLimit)
+	assert.Equal(t, 3, rateLimit.Quota)
+	assert.Equal(t, 59*time.Second, rateLimit.RetryAfter)
+
+	clock.Advance(time.Minute)
+	mock.InjectFaults(http.StatusBadGateway, 1)
+	_, err = client.GetOrder(ctx, "2377225624")
+	var serverErr *accrual.ServerError
+	require.ErrorAs(t, err, &serverErr)
+	assert.Equal(t, http.StatusBadGateway, serverErr.StatusCode)
+}
+
+// TestClientBreaker checks that injected faults open the circuit breaker.
+func TestClientBreaker(t *testing.T) {
+	mock, _ := newMock(t, Config{})
+	mock.InjectFaults(http.StatusInternalServerError, 2)
+
+	server := httptest.NewServer(mock.Handler())
+	defer server.Close()
+	breaker := accrual.NewBreaker(zap.NewNop(), 2, time.Minute, 1)
+	client := accrual.NewBreakerClient(accrual.NewClient(server.URL, time.Second, 0), breaker)
+
+	for range 2 {
+		_, err := client.GetOrder(context.Background(), "2377225624")
+		var serverErr *accrual.ServerError
+		require.ErrorAs(t, err, &serverErr)
+	}
+	_, err := client.GetOrder(context.Background(), "2377225624")
+	assert.ErrorIs(t, err, accrual.ErrCircuitOpen)
+	assert.Equal(t, accrual.BreakerOpen, breaker.State())
+}
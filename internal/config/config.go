@@ -0,0 +1,379 @@
+// Package config reads the configuration of gophermart and the scheduler.
+//
+// Values come from, in increasing order of precedence: the defaults, a YAML
+// config file, command line flags and environment variables. Secrets may be
+// read from files named by *_FILE variables, e.g. SECRET_FILE.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	// Database is the DSN of the Postgres database.
+	Database string `yaml:"database"`
+	// File is the config file the configuration was read from, if any.
+	File string `yaml:"-"`
+	// Args are the command line arguments left after the flags.
+	Args      []string  `yaml:"-"`
+	Server    Server    `yaml:"server"`
+	Scheduler Scheduler `yaml:"scheduler"`
+}
+
+// Server configures the HTTP API.
+type Server struct {
+	Address      string `yaml:"address"`
+	Secret       string `yaml:"secret"`
+	ServiceToken string `yaml:"service_token"`
+	// TransferDailyLimit is the points a user may transfer per day, zero
+	// for no limit.
+	TransferDailyLimit money.Amount  `yaml:"transfer_daily_limit"`
+	IdempotencyTTL     time.Duration `yaml:"idempotency_ttl"`
+	IdempotencyLease   time.Duration `yaml:"idempotency_lease"`
+	HoldTTL            time.Duration `yaml:"hold_ttl"`
+	ReadTimeout        time.Duration `yaml:"read_timeout"`
+	WriteTimeout       time.Duration `yaml:"write_timeout"`
+	IdleTimeout        time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout    time.Duration `yaml:"shutdown_timeout"`
+	EventsHeartbeat    time.Duration `yaml:"events_heartbeat"`
+	// RunScheduler runs the scheduler in the server process.
+	RunScheduler bool `yaml:"run_scheduler"`
+}
+
+// Scheduler configures the background work: checking orders with the
+// accrual system, expiring points, releasing holds and sending webhooks.
+type Scheduler struct {
+	AccrualAddress string `yaml:"accrual_address"`
+	// StatusAddress serves the scheduler status, empty to disable it.
+	StatusAddress      string        `yaml:"status_address"`
+	AccrualTimeout     time.Duration `yaml:"accrual_timeout"`
+	AccrualIdleTimeout time.Duration `yaml:"accrual_idle_timeout"`
+	AccrualInterval    time.Duration `yaml:"accrual_interval"`
+	PollInterval       time.Duration `yaml:"poll_interval"`
+	ExpireInterval     time.Duration `yaml:"expire_interval"`
+	ReleaseInterval    time.Duration `yaml:"release_interval"`
+	PurgeInterval      time.Duration `yaml:"purge_interval"`
+	WebhookInterval    time.Duration `yaml:"webhook_interval"`
+	WebhookTimeout     time.Duration `yaml:"webhook_timeout"`
+	OrderLease         time.Duration `yaml:"order_lease"`
+	RetryBaseDelay     time.Duration `yaml:"retry_base_delay"`
+	RetryMaxDelay      time.Duration `yaml:"retry_max_delay"`
+	BreakerTimeout     time.Duration `yaml:"breaker_timeout"`
+	DrainTimeout       time.Duration `yaml:"drain_timeout"`
+	// PointsLifetime is the number of months accrued points stay
+	// spendable, zero means they never expire.
+	PointsLifetime int `yaml:"points_lifetime_months"`
+	// AccrualRateLimit is in requests per minute, zero follows the quota
+	// reported by the accrual system.
+	AccrualRateLimit int `yaml:"accrual_rate_limit"`
+	BreakerThreshold int `yaml:"breaker_threshold"`
+	BreakerProbes    int `yaml:"breaker_probes"`
+	WorkerPoolSize   int `yaml:"worker_pool_size"`
+	WebhookWorkers   int `yaml:"webhook_workers"`
+	ClaimBatchSize   int `yaml:"claim_batch_size"`
+	MaxAttempts      int `yaml:"max_attempts"`
+}
+
+// Default returns the configuration used for everything that is not set.
+func Default() Config {
+	return Config{
+		Server: Server{
+			Address:            ":8080",
+			TransferDailyLimit: money.FromPoints(10000),
+			IdempotencyTTL:     24 * time.Hour,
+			IdempotencyLease:   time.Minute,
+			HoldTTL:            15 * time.Minute,
+			ReadTimeout:        10 * time.Second,
+			WriteTimeout:       30 * time.Second,
+			IdleTimeout:        2 * time.Minute,
+			ShutdownTimeout:    15 * time.Second,
+			EventsHeartbeat:    25 * time.Second,
+		},
+		Scheduler: Scheduler{
+			StatusAddress:      ":8082",
+			AccrualTimeout:     5 * time.Second,
+			AccrualIdleTimeout: 90 * time.Second,
+			AccrualInterval:    10 * time.Second,
+			PollInterval:       10 * time.Second,
+			ExpireInterval:     time.Hour,
+			ReleaseInterval:    time.Minute,
+			PurgeInterval:      time.Hour,
+			WebhookInterval:    5 * time.Second,
+			WebhookTimeout:     10 * time.Second,
+			OrderLease:         time.Minute,
+			RetryBaseDelay:     10 * time.Second,
+			RetryMaxDelay:      time.Hour,
+			BreakerTimeout:     30 * time.Second,
+			DrainTimeout:       15 * time.Second,
+			PointsLifetime:     12,
+			BreakerThreshold:   5,
+			BreakerProbes:      1,
+			WorkerPoolSize:     5,
+			WebhookWorkers:     10,
+			ClaimBatchSize:     100,
+			MaxAttempts:        15,
+		},
+	}
+}
+
+// env maps flags to the environment variables overriding them.
+var env = map[string]string{
+	"config":                    "CONFIG_FILE",
+	"d":                         "DATABASE_URI",
+	"a":                         "RUN_ADDRESS",
+	"s":                         "SECRET",
+	"service-token":             "SERVICE_TOKEN",
+	"transfer-daily-limit":      "TRANSFER_DAILY_LIMIT",
+	"idempotency-ttl":           "IDEMPOTENCY_TTL",
+	"idempotency-lease":         "IDEMPOTENCY_LEASE",
+	"hold-ttl":                  "HOLD_TTL",
+	"read-timeout":              "READ_TIMEOUT",
+	"write-timeout":             "WRITE_TIMEOUT",
+	"idle-timeout":              "IDLE_TIMEOUT",
+	"shutdown-timeout":          "SHUTDOWN_TIMEOUT",
+	"events-heartbeat":          "EVENTS_HEARTBEAT",
+	"scheduler":                 "RUN_SCHEDULER",
+	"r":                         "ACCRUAL_SYSTEM_ADDRESS",
+	"status-address":            "STATUS_ADDRESS",
+	"accrual-timeout":           "ACCRUAL_TIMEOUT",
+	"accrual-idle-timeout":      "ACCRUAL_IDLE_TIMEOUT",
+	"accrual-interval":          "ACCRUAL_INTERVAL",
+	"poll-interval":             "POLL_INTERVAL",
+	"expire-interval":           "EXPIRE_INTERVAL",
+	"release-interval":          "RELEASE_INTERVAL",
+	"purge-interval":            "PURGE_INTERVAL",
+	"webhook-interval":          "WEBHOOK_INTERVAL",
+	"webhook-timeout":           "WEBHOOK_TIMEOUT",
+	"order-lease":               "ORDER_LEASE",
+	"retry-base-delay":          "RETRY_BASE_DELAY",
+	"retry-max-delay":           "RETRY_MAX_DELAY",
+	"accrual-breaker-timeout":   "ACCRUAL_BREAKER_TIMEOUT",
+	"drain-timeout":             "DRAIN_TIMEOUT",
+	"points-lifetime":           "POINTS_LIFETIME_MONTHS",
+	"accrual-rate-limit":        "ACCRUAL_RATE_LIMIT",
+	"accrual-breaker-threshold": "ACCRUAL_BREAKER_THRESHOLD",
+	"accrual-breaker-probes":    "ACCRUAL_BREAKER_PROBES",
+	"worker-pool-size":          "WORKER_POOL_SIZE",
+	"webhook-workers":           "WEBHOOK_WORKERS",
+	"claim-batch-size":          "CLAIM_BATCH_SIZE",
+	"max-attempts":              "MAX_ATTEMPTS",
+}
+
+// emptyEnv lists the variables whose empty value means something, the others
+// are ignored when empty.
+var emptyEnv = map[string]bool{
+	"STATUS_ADDRESS": true,
+}
+
+func (c *Config) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&c.File, "config", c.File, "YAML config file")
+	fs.StringVar(&c.Database, "d", c.Database, "database-dsn")
+
+	s := &c.Server
+	fs.StringVar(&s.Address, "a", s.Address, "address")
+	fs.StringVar(&s.Secret, "s", s.Secret, "secret")
+	fs.StringVar(&s.ServiceToken, "service-token", s.ServiceToken, "token for the service API")
+	fs.TextVar(
+		&s.TransferDailyLimit, "transfer-daily-limit", s.TransferDailyLimit,
+		"points a user may transfer per day, 0 for no limit",
+	)
+	fs.DurationVar(&s.IdempotencyTTL, "idempotency-ttl", s.IdempotencyTTL, "idempotency key lifetime")
+	fs.DurationVar(
+		&s.IdempotencyLease, "idempotency-lease", s.IdempotencyLease,
+		"time before the key of an unfinished request may be reserved again",
+	)
+	fs.DurationVar(&s.HoldTTL, "hold-ttl", s.HoldTTL, "time before an unresolved hold is released")
+	fs.DurationVar(&s.ReadTimeout, "read-timeout", s.ReadTimeout, "time to read a request")
+	fs.DurationVar(&s.WriteTimeout, "write-timeout", s.WriteTimeout, "time to write a response, event streams excluded")
+	fs.DurationVar(&s.IdleTimeout, "idle-timeout", s.IdleTimeout, "how long idle connections are kept alive")
+	fs.DurationVar(
+		&s.ShutdownTimeout, "shutdown-timeout", s.ShutdownTimeout, "time requests in flight get to finish on shutdown",
+	)
+	fs.DurationVar(
+		&s.EventsHeartbeat, "events-heartbeat", s.EventsHeartbeat,
+		"interval of keep-alive comments on idle event streams",
+	)
+	fs.BoolVar(&s.RunScheduler, "scheduler", s.RunScheduler, "run the scheduler in the server process")
+
+	sc := &c.Scheduler
+	fs.StringVar(&sc.AccrualAddress, "r", sc.AccrualAddress, "acccural-address")
+	fs.StringVar(&sc.StatusAddress, "status-address", sc.StatusAddress, "address of the status endpoint, empty to disable")
+	fs.DurationVar(&sc.AccrualTimeout, "accrual-timeout", sc.AccrualTimeout, "timeout of a request to the accrual system")
+	fs.DurationVar(
+		&sc.AccrualIdleTimeout, "accrual-idle-timeout", sc.AccrualIdleTimeout,
+		"how long idle accrual connections are kept alive, 0 to disable",
+	)
+	fs.DurationVar(&sc.AccrualInterval, "accrual-interval", sc.AccrualInterval, "interval of the sweep for pending orders")
+	fs.DurationVar(&sc.PollInterval, "poll-interval", sc.PollInterval, "time before a processing order is checked again")
+	fs.DurationVar(&sc.ExpireInterval, "expire-interval", sc.ExpireInterval, "interval of the points expiry")
+	fs.DurationVar(&sc.ReleaseInterval, "release-interval", sc.ReleaseInterval, "interval of the release of expired holds")
+	fs.DurationVar(
+		&sc.PurgeInterval, "purge-interval", sc.PurgeInterval, "interval of the purge of expired idempotency keys",
+	)
+	fs.DurationVar(&sc.WebhookInterval, "webhook-interval", sc.WebhookInterval, "interval of webhook deliveries")
+	fs.DurationVar(&sc.WebhookTimeout, "webhook-timeout", sc.WebhookTimeout, "timeout of a webhook delivery")
+	fs.DurationVar(&sc.OrderLease, "order-lease", sc.OrderLease, "time an order is leased to a scheduler")
+	fs.DurationVar(
+		&sc.RetryBaseDelay, "retry-base-delay", sc.RetryBaseDelay,
+		"delay before the first retry of a failed check",
+	)
+	fs.DurationVar(
+		&sc.RetryMaxDelay, "retry-max-delay", sc.RetryMaxDelay,
+		"longest delay between retries of a failed check",
+	)
+	fs.DurationVar(
+		&sc.BreakerTimeout, "accrual-breaker-timeout", sc.BreakerTimeout,
+		"time before an open breaker is probed",
+	)
+	fs.DurationVar(&sc.DrainTimeout, "drain-timeout", sc.DrainTimeout, "time in-flight work gets to finish on shutdown")
+	fs.IntVar(
+		&sc.PointsLifetime, "points-lifetime", sc.PointsLifetime,
+		"months before accrued points expire, 0 to never expire",
+	)
+	fs.IntVar(
+		&sc.AccrualRateLimit, "accrual-rate-limit", sc.AccrualRateLimit,
+		"accrual requests per minute, 0 to follow the quota reported by the accrual system",
+	)
+	fs.IntVar(
+		&sc.BreakerThreshold, "accrual-breaker-threshold", sc.BreakerThreshold,
+		"accrual failures in a row that open the circuit breaker",
+	)
+	fs.IntVar(
+		&sc.BreakerProbes, "accrual-breaker-probes", sc.BreakerProbes,
+		"successful probes needed to close the circuit breaker",
+	)
+	fs.IntVar(&sc.WorkerPoolSize, "worker-pool-size", sc.WorkerPoolSize, "orders checked at the same time")
+	fs.IntVar(&sc.WebhookWorkers, "webhook-workers", sc.WebhookWorkers, "webhook deliveries sent at the same time")
+	fs.IntVar(&sc.ClaimBatchSize, "claim-batch-size", sc.ClaimBatchSize, "orders leased at once")
+	fs.IntVar(&sc.MaxAttempts, "max-attempts", sc.MaxAttempts, "failed checks before an order is set aside as stuck")
+	return fs
+}
+
+// Load reads the configuration of the program name from the command line
+// arguments args and the environment. It does not validate it.
+func Load(name string, args []string) (*Config, error) {
+	cfg := Default()
+	fs := cfg.flagSet(name)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	flags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		flags[f.Name] = true
+	})
+
+	file := cfg.File
+	if value, ok := os.LookupEnv("CONFIG_FILE"); ok && value != "" {
+		file = value
+	}
+	if file != "" {
+		// The file goes under the flags: read it over the defaults and set
+		// the flags given on the command line again. The flags are bound to
+		// cfg, so they set the values read from the file.
+		set := make(map[string]string)
+		fs.Visit(func(f *flag.Flag) {
+			set[f.Name] = f.Value.String()
+		})
+
+		cfg = Default()
+		if err := cfg.readFile(file); err != nil {
+			return nil, err
+		}
+		for name, value := range set {
+			if err := fs.Set(name, value); err != nil {
+				return nil, err
+			}
+		}
+		cfg.File = file
+	}
+
+	if err := loadEnv(fs); err != nil {
+		return nil, err
+	}
+	if err := cfg.loadSecretFiles(flags); err != nil {
+		return nil, err
+	}
+
+	if addr := cfg.Scheduler.AccrualAddress; addr != "" &&
+		!strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		cfg.Scheduler.AccrualAddress = "http://" + addr
+	}
+	cfg.Args = fs.Args()
+	return &cfg, nil
+}
+
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cant open config file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	decoder := yaml.NewDecoder(f)
+	// A misspelled key would silently leave the default in place.
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv sets the flags from their environment variables.
+func loadEnv(fs *flag.FlagSet) error {
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := env[f.Name]
+		value, ok := os.LookupEnv(name)
+		if !ok || (value == "" && !emptyEnv[name]) {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// loadSecretFiles reads the secrets named by NAME_FILE variables, which keeps
+// them out of the environment and the process list. A secret may come from
+// only one place, so neither its variable nor its flag, given in flags, may
+// be set too.
+func (c *Config) loadSecretFiles(flags map[string]bool) error {
+	secrets := []struct {
+		value *string
+		name  string
+		flag  string
+	}{
+		{value: &c.Database, name: "DATABASE_URI", flag: "d"},
+		{value: &c.Server.Secret, name: "SECRET", flag: "s"},
+		{value: &c.Server.ServiceToken, name: "SERVICE_TOKEN", flag: "service-token"},
+	}
+	for _, secret := range secrets {
+		fileVar := secret.name + "_FILE"
+		path, ok := os.LookupEnv(fileVar)
+		if !ok || path == "" {
+			continue
+		}
+		if value, ok := os.LookupEnv(secret.name); ok && value != "" {
+			return fmt.Errorf("both %s and %s are set, use one of them", secret.name, fileVar)
+		}
+		if flags[secret.flag] {
+			return fmt.Errorf("both -%s and %s are set, use one of them", secret.flag, fileVar)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("cant read %s: %w", fileVar, err)
+		}
+		*secret.value = strings.TrimSpace(string(data))
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load("gophermart", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, Default().Server, cfg.Server)
+	assert.Equal(t, 5, cfg.Scheduler.WorkerPoolSize)
+	assert.Empty(t, cfg.Args)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+database: postgres://file
+server:
+  address: ":9000"
+  hold_ttl: 5m
+  transfer_daily_limit: 500.5
+scheduler:
+  accrual_address: accrual:8080
+  worker_pool_size: 8
+  poll_interval: 30s
+`)
+
+	t.Setenv("WORKER_POOL_SIZE", "12")
+	cfg, err := Load("gophermart", []string{"-config", file, "-a", ":9001", "-worker-pool-size", "10", "requeue"})
+	require.NoError(t, err)
+
+	assert.Equal(t, file, cfg.File)
+	assert.Equal(t, "postgres://file", cfg.Database)
+	// Flags go over the file, the environment over the flags.
+	assert.Equal(t, ":9001", cfg.Server.Address)
+	assert.Equal(t, 12, cfg.Scheduler.WorkerPoolSize)
+	assert.Equal(t, 5*time.Minute, cfg.Server.HoldTTL)
+	assert.Equal(t, money.Amount(50050), cfg.Server.TransferDailyLimit)
+	assert.Equal(t, 30*time.Second, cfg.Scheduler.PollInterval)
+	assert.Equal(t, "http://accrual:8080", cfg.Scheduler.AccrualAddress)
+	// Everything else keeps its default.
+	assert.Equal(t, 24*time.Hour, cfg.Server.IdempotencyTTL)
+	assert.Equal(t, []string{"requeue"}, cfg.Args)
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Run("Overrides Flags", func(t *testing.T) {
+		t.Setenv("RUN_ADDRESS", "0.0.0.0:8081")
+		t.Setenv("ACCRUAL_TIMEOUT", "2s")
+		t.Setenv("RUN_SCHEDULER", "true")
+
+		cfg, err := Load("gophermart", []string{"-a", ":9001", "-accrual-timeout", "1s"})
+		require.NoError(t, err)
+		assert.Equal(t, "0.0.0.0:8081", cfg.Server.Address)
+		assert.Equal(t, 2*time.Second, cfg.Scheduler.AccrualTimeout)
+		assert.True(t, cfg.Server.RunScheduler)
+	})
+
+	t.Run("Empty Ignored", func(t *testing.T) {
+		t.Setenv("RUN_ADDRESS", "")
+
+		cfg, err := Load("gophermart", nil)
+		require.NoError(t, err)
+		assert.Equal(t, ":8080", cfg.Server.Address)
+	})
+
+	t.Run("Empty Status Address Disables", func(t *testing.T) {
+		t.Setenv("STATUS_ADDRESS", "")
+
+		cfg, err := Load("scheduler", nil)
+		require.NoError(t, err)
+		assert.Empty(t, cfg.Scheduler.StatusAddress)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Setenv("HOLD_TTL", "soon")
+
+		_, err := Load("gophermart", nil)
+		assert.ErrorContains(t, err, "invalid HOLD_TTL")
+	})
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	t.Run("Read", func(t *testing.T) {
+		t.Setenv("SECRET_FILE", writeFile(t, "secret", "s3cr3t\n"))
+		t.Setenv("DATABASE_URI_FILE", writeFile(t, "dsn", "postgres://secret"))
+
+		cfg, err := Load("gophermart", nil)
+		require.NoError(t, err)
+		assert.Equal(t, "s3cr3t", cfg.Server.Secret)
+		assert.Equal(t, "postgres://secret", cfg.Database)
+	})
+
+	t.Run("Both Set", func(t *testing.T) {
+		t.Setenv("SECRET", "s3cr3t")
+		t.Setenv("SECRET_FILE", writeFile(t, "secret", "s3cr3t"))
+
+		_, err := Load("gophermart", nil)
+		assert.ErrorContains(t, err, "both SECRET and SECRET_FILE are set")
+	})
+
+	t.Run("Flag And File", func(t *testing.T) {
+		t.Setenv("SECRET_FILE", writeFile(t, "secret", "s3cr3t"))
+
+		_, err := Load("gophermart", []string{"-s", "from-flag"})
+		assert.ErrorContains(t, err, "both -s and SECRET_FILE are set")
+	})
+
+	t.Run("Missing File", func(t *testing.T) {
+		t.Setenv("SECRET_FILE", filepath.Join(t.TempDir(), "missing"))
+
+		_, err := Load("gophermart", nil)
+		assert.ErrorContains(t, err, "cant read SECRET_FILE")
+	})
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	t.Run("Unknown Key", func(t *testing.T) {
+		file := writeFile(t, "config.yaml", "server:\n  adress: \":9000\"\n")
+
+		_, err := Load("gophermart", []string{"-config", file})
+		assert.ErrorContains(t, err, "field adress not found")
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.yaml"))
+
+		_, err := Load("gophermart", nil)
+		assert.ErrorContains(t, err, "cant open config file")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		cfg := Default()
+		cfg.Database = "postgres://localhost/market"
+		cfg.Server.Secret = "s3cr3t"
+		cfg.Scheduler.AccrualAddress = "http://localhost:8081"
+		return &cfg
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		assert.NoError(t, valid().ValidateServer())
+		assert.NoError(t, valid().ValidateScheduler())
+	})
+
+	t.Run("Empty Secret", func(t *testing.T) {
+		cfg := valid()
+		cfg.Server.Secret = ""
+		assert.ErrorContains(t, cfg.ValidateServer(), "secret signing the auth tokens is required")
+		// The scheduler does not sign anything.
+		assert.NoError(t, cfg.ValidateScheduler())
+	})
+
+	t.Run("All Problems Reported", func(t *testing.T) {
+		cfg := Default()
+		cfg.Scheduler.WorkerPoolSize = 0
+
+		err := cfg.ValidateScheduler()
+		var validationErr *ValidationError
+		require.ErrorAs(t, err, &validationErr)
+		assert.Equal(t, []string{
+			"database DSN is required (DATABASE_URI, DATABASE_URI_FILE or -d)",
+			"accrual system address is required (ACCRUAL_SYSTEM_ADDRESS or -r)",
+			"worker pool size must be positive, got 0",
+		}, validationErr.Problems)
+	})
+
+	t.Run("Embedded Scheduler", func(t *testing.T) {
+		cfg := valid()
+		cfg.Scheduler.AccrualAddress = ""
+		assert.NoError(t, cfg.ValidateServer())
+
+		cfg.Server.RunScheduler = true
+		assert.ErrorContains(t, cfg.ValidateServer(), "accrual system address is required")
+	})
+
+	t.Run("Status Address Clash", func(t *testing.T) {
+		cfg := valid()
+		cfg.Server.Address = "0.0.0.0:8082"
+		assert.NoError(t, cfg.ValidateServer())
+
+		cfg.Server.RunScheduler = true
+		assert.ErrorContains(t, cfg.ValidateServer(), `status address ":8082" must differ from the server address`)
+
+		cfg.Server.Address = "localhost:8080"
+		assert.NoError(t, cfg.ValidateServer())
+		cfg.Scheduler.StatusAddress = ""
+		assert.NoError(t, cfg.ValidateServer())
+	})
+
+	t.Run("Lease Shorter Than Timeout", func(t *testing.T) {
+		cfg := valid()
+		cfg.Scheduler.OrderLease = cfg.Scheduler.AccrualTimeout
+		assert.ErrorContains(t, cfg.ValidateScheduler(), "order lease 5s must be longer than the accrual timeout 5s")
+	})
+}
+
+func TestExampleFile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "../../config.example.yaml")
+
+	cfg, err := Load("gophermart", nil)
+	require.NoError(t, err)
+
+	want := Default()
+	want.Database = cfg.Database
+	want.File = cfg.File
+	want.Scheduler.AccrualAddress = cfg.Scheduler.AccrualAddress
+	assert.Equal(t, want.Server, cfg.Server)
+	assert.Equal(t, want.Scheduler, cfg.Scheduler)
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ValidationError lists everything wrong with a configuration at once.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) check(ok bool, format string, args ...any) {
+	if !ok {
+		v.problems = append(v.problems, fmt.Sprintf(format, args...))
+	}
+}
+
+func (v *validator) positive(d time.Duration, name, option string) {
+	v.check(d > 0, "%s must be positive, got %s (%s)", name, d, option)
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: v.problems}
+}
+
+// ValidateServer checks the configuration of gophermart, and of the
+// scheduler as well if it runs in the server process.
+func (c *Config) ValidateServer() error {
+	var v validator
+	c.validateDatabase(&v)
+
+	s := c.Server
+	v.check(s.Address != "", "address is required (RUN_ADDRESS or -a)")
+	v.check(s.Secret != "", "secret signing the auth tokens is required (SECRET, SECRET_FILE or -s)")
+	v.check(s.TransferDailyLimit >= 0, "transfer daily limit must not be negative, got %s", s.TransferDailyLimit)
+	v.positive(s.IdempotencyTTL, "idempotency TTL", "IDEMPOTENCY_TTL or -idempotency-ttl")
+	v.positive(s.IdempotencyLease, "idempotency lease", "IDEMPOTENCY_LEASE or -idempotency-lease")
+	v.positive(s.HoldTTL, "hold TTL", "HOLD_TTL or -hold-ttl")
+	v.positive(s.ReadTimeout, "read timeout", "READ_TIMEOUT or -read-timeout")
+	v.positive(s.WriteTimeout, "write timeout", "WRITE_TIMEOUT or -write-timeout")
+	v.positive(s.IdleTimeout, "idle timeout", "IDLE_TIMEOUT or -idle-timeout")
+	v.positive(s.ShutdownTimeout, "shutdown timeout", "SHUTDOWN_TIMEOUT or -shutdown-timeout")
+	v.positive(s.EventsHeartbeat, "events heartbeat", "EVENTS_HEARTBEAT or -events-heartbeat")
+	// A request must not be run again while it may still answer the client.
+	v.check(s.IdempotencyLease > s.WriteTimeout,
+		"idempotency lease %s must be longer than the write timeout %s", s.IdempotencyLease, s.WriteTimeout)
+
+	if s.RunScheduler {
+		c.validateScheduler(&v)
+		v.check(!sameListener(s.Address, c.Scheduler.StatusAddress),
+			"status address %q must differ from the server address %q", c.Scheduler.StatusAddress, s.Address)
+	}
+	return v.err()
+}
+
+// ValidateScheduler checks the configuration of the scheduler.
+func (c *Config) ValidateScheduler() error {
+	var v validator
+	c.validateDatabase(&v)
+	c.validateScheduler(&v)
+	return v.err()
+}
+
+// sameListener reports whether listening on both addresses would clash. An
+// empty address is disabled, an empty host listens on every interface.
+func sameListener(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return portA == portB && (hostA == hostB || isWildcard(hostA) || isWildcard(hostB))
+}
+
+func isWildcard(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "" || (ip != nil && ip.IsUnspecified())
+}
+
+func (c *Config) validateDatabase(v *validator) {
+	v.check(c.Database != "", "database DSN is required (DATABASE_URI, DATABASE_URI_FILE or -d)")
+}
+
+func (c *Config) validateScheduler(v *validator) {
+	s := c.Scheduler
+	if s.AccrualAddress == "" {
+		v.check(false, "accrual system address is required (ACCRUAL_SYSTEM_ADDRESS or -r)")
+	} else if u, err := url.Parse(s.AccrualAddress); err != nil || u.Host == "" {
+		v.check(false, "invalid accrual system address %q", s.AccrualAddress)
+	}
+
+	v.positive(s.AccrualTimeout, "accrual timeout", "ACCRUAL_TIMEOUT or -accrual-timeout")
+	v.check(s.AccrualIdleTimeout >= 0, "accrual idle timeout must not be negative, got %s", s.AccrualIdleTimeout)
+	v.positive(s.AccrualInterval, "accrual interval", "ACCRUAL_INTERVAL or -accrual-interval")
+	v.positive(s.PollInterval, "poll interval", "POLL_INTERVAL or -poll-interval")
+	v.positive(s.ExpireInterval, "expire interval", "EXPIRE_INTERVAL or -expire-interval")
+	v.positive(s.ReleaseInterval, "release interval", "RELEASE_INTERVAL or -release-interval")
+	v.positive(s.PurgeInterval, "purge interval", "PURGE_INTERVAL or -purge-interval")
+	v.positive(s.WebhookInterval, "webhook interval", "WEBHOOK_INTERVAL or -webhook-interval")
+	v.positive(s.WebhookTimeout, "webhook timeout", "WEBHOOK_TIMEOUT or -webhook-timeout")
+	v.positive(s.OrderLease, "order lease", "ORDER_LEASE or -order-lease")
+	v.positive(s.RetryBaseDelay, "retry base delay", "RETRY_BASE_DELAY or -retry-base-delay")
+	v.check(s.RetryMaxDelay >= s.RetryBaseDelay,
+		"retry max delay %s must not be below the base delay %s", s.RetryMaxDelay, s.RetryBaseDelay)
+	v.positive(s.BreakerTimeout, "breaker timeout", "ACCRUAL_BREAKER_TIMEOUT or -accrual-breaker-timeout")
+	v.positive(s.DrainTimeout, "drain timeout", "DRAIN_TIMEOUT or -drain-timeout")
+
+	v.check(s.PointsLifetime >= 0, "points lifetime must not be negative, got %d", s.PointsLifetime)
+	v.check(s.AccrualRateLimit >= 0, "accrual rate limit must not be negative, got %d", s.AccrualRateLimit)
+	v.check(s.BreakerThreshold > 0, "breaker threshold must be positive, got %d", s.BreakerThreshold)
+	v.check(s.BreakerProbes > 0, "breaker probes must be positive, got %d", s.BreakerProbes)
+	v.check(s.WorkerPoolSize > 0, "worker pool size must be positive, got %d", s.WorkerPoolSize)
+	v.check(s.WebhookWorkers > 0, "webhook workers must be positive, got %d", s.WebhookWorkers)
+	v.check(s.ClaimBatchSize > 0, "claim batch size must be positive, got %d", s.ClaimBatchSize)
+	v.check(s.MaxAttempts > 0, "max attempts must be positive, got %d", s.MaxAttempts)
+	// Orders must not be leased again while they are still being checked.
+	v.check(s.OrderLease > s.AccrualTimeout,
+		"order lease %s must be longer than the accrual timeout %s", s.OrderLease, s.AccrualTimeout)
+}
@@ -0,0 +1,148 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// Channel is the Postgres notification channel user events are published on.
+const Channel = "user_events"
+
+const (
+	TypeOrder   = "order"
+	TypeBalance = "balance"
+	// TypeResync tells subscribers that events may have been lost and the
+	// state should be fetched again.
+	TypeResync = "resync"
+)
+
+// subscriberBuffer is how many events a subscriber may lag behind before it
+// is dropped.
+const subscriberBuffer = 32
+
+// Event is a change a user should learn about right away.
+type Event struct {
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data"`
+	UserID int             `json:"user_id"`
+}
+
+// Broker fans out database notifications to the subscribers of each user.
+type Broker struct {
+	logger      *zap.Logger
+	subscribers map[int]map[chan Event]struct{}
+	mu          sync.Mutex
+}
+
+func NewBroker(logger *zap.Logger) *Broker {
+	return &Broker{
+		logger:      logger,
+		subscribers: make(map[int]map[chan Event]struct{}),
+	}
+}
+
+// Subscribe returns the events of the user and a function to stop receiving
+// them. The channel is closed if the subscriber falls too far behind.
+func (b *Broker) Subscribe(userID int) (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan Event]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(userID, ch)
+	}
+}
+
+// Close drops every subscriber, which ends their streams. Streams never go
+// idle on their own, so the server closes the broker when it shuts down.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for userID, subscribers := range b.subscribers {
+		for ch := range subscribers {
+			b.remove(userID, ch)
+		}
+	}
+}
+
+// Run dispatches notifications until ctx is done or the channel is closed.
+func (b *Broker) Run(ctx context.Context, notifications <-chan *pq.Notification) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n, ok := <-notifications:
+			if !ok {
+				return
+			}
+			if n == nil {
+				// The listener reconnected and may have missed notifications.
+				b.broadcast(Event{Type: TypeResync, Data: json.RawMessage("{}")})
+				continue
+			}
+
+			var event Event
+			if err := json.Unmarshal([]byte(n.Extra), &event); err != nil {
+				b.logger.Error("failed to decode notification", zap.String("payload", n.Extra), zap.Error(err))
+				continue
+			}
+			b.publish(event)
+		}
+	}
+}
+
+func (b *Broker) publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers[event.UserID] {
+		b.send(event.UserID, ch, event)
+	}
+}
+
+func (b *Broker) broadcast(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for userID, subscribers := range b.subscribers {
+		event.UserID = userID
+		for ch := range subscribers {
+			b.send(userID, ch, event)
+		}
+	}
+}
+
+// send never blocks: a subscriber that cannot keep up is dropped, so that it
+// reconnects and fetches the current state instead of missing events silently.
+func (b *Broker) send(userID int, ch chan Event, event Event) {
+	select {
+	case ch <- event:
+	default:
+		b.logger.Warn("dropping slow event subscriber", zap.Int("user_id", userID))
+		b.remove(userID, ch)
+	}
+}
+
+func (b *Broker) remove(userID int, ch chan Event) {
+	subscribers := b.subscribers[userID]
+	if _, ok := subscribers[ch]; !ok {
+		return
+	}
+	delete(subscribers, ch)
+	if len(subscribers) == 0 {
+		delete(b.subscribers, userID)
+	}
+	close(ch)
+}
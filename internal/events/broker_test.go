@@ -0,0 +1,100 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func receive(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case event := <-ch:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return Event{}
+	}
+}
+
+func TestBroker(t *testing.T) {
+	broker := NewBroker(zap.NewNop())
+	notifications := make(chan *pq.Notification)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go broker.Run(ctx, notifications)
+
+	first, unsubscribe := broker.Subscribe(1)
+	defer unsubscribe()
+	other, unsubscribeOther := broker.Subscribe(2)
+	defer unsubscribeOther()
+
+	t.Run("Routed To User", func(t *testing.T) {
+		notifications <- &pq.Notification{
+			Channel: Channel,
+			Extra:   `{"user_id": 1, "type": "order", "data": {"number": "2377225624", "status": "PROCESSED"}}`,
+		}
+
+		event := receive(t, first)
+		assert.Equal(t, TypeOrder, event.Type)
+		assert.JSONEq(t, `{"number": "2377225624", "status": "PROCESSED"}`, string(event.Data))
+		assert.Empty(t, other)
+	})
+
+	t.Run("Invalid Payload Skipped", func(t *testing.T) {
+		notifications <- &pq.Notification{Channel: Channel, Extra: "not json"}
+		notifications <- &pq.Notification{Channel: Channel, Extra: `{"user_id": 2, "type": "balance", "data": {}}`}
+
+		assert.Equal(t, TypeBalance, receive(t, other).Type)
+	})
+
+	t.Run("Resync After Reconnect", func(t *testing.T) {
+		notifications <- nil
+
+		assert.Equal(t, TypeResync, receive(t, first).Type)
+		assert.Equal(t, TypeResync, receive(t, other).Type)
+	})
+}
+
+func TestBrokerDropsSlowSubscriber(t *testing.T) {
+	broker := NewBroker(zap.NewNop())
+
+	slow, unsubscribe := broker.Subscribe(1)
+	for range subscriberBuffer + 1 {
+		broker.publish(Event{UserID: 1, Type: TypeBalance})
+	}
+
+	received := 0
+	for range slow {
+		received++
+	}
+	assert.Equal(t, subscriberBuffer, received)
+
+	// Unsubscribing a dropped subscriber is harmless.
+	unsubscribe()
+	require.Empty(t, broker.subscribers)
+}
+
+func TestBrokerClose(t *testing.T) {
+	broker := NewBroker(zap.NewNop())
+
+	first, unsubscribe := broker.Subscribe(1)
+	other, unsubscribeOther := broker.Subscribe(2)
+	broker.Close()
+
+	_, ok := <-first
+	assert.False(t, ok)
+	_, ok = <-other
+	assert.False(t, ok)
+
+	unsubscribe()
+	unsubscribeOther()
+	require.Empty(t, broker.subscribers)
+}
@@ -1,31 +1,44 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/money"
 	"github.com/krasvl/market/internal/storage"
+	"github.com/krasvl/market/internal/utils"
 	"go.uber.org/zap"
 )
 
 // BalanceResponse represents the response body for a user's balance.
 type BalanceResponse struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Expiring  []ExpiringPointsResponse `json:"expiring,omitempty"`
+	Current   money.Amount             `json:"current" swaggertype:"number"`
+	Withdrawn money.Amount             `json:"withdrawn" swaggertype:"number"`
+	Held      money.Amount             `json:"held" swaggertype:"number"`
+}
+
+// ExpiringPointsResponse represents points that expire soon.
+type ExpiringPointsResponse struct {
+	ExpiresAt time.Time    `json:"expires_at"`
+	Sum       money.Amount `json:"sum" swaggertype:"number"`
 }
 
 // WithdrawRequest represents the request body for withdrawing points.
 type WithdrawRequest struct {
-	Order string  `json:"order"`
-	Sum   float64 `json:"sum"`
+	Order string       `json:"order"`
+	Sum   money.Amount `json:"sum" swaggertype:"number"`
 }
 
 // WithdrawalResponse represents the response body for a withdrawal.
 type WithdrawalResponse struct {
-	ProcessedAt time.Time `json:"processed_at"`
-	Order       string    `json:"order"`
-	Sum         float64   `json:"sum"`
+	ProcessedAt time.Time        `json:"processed_at"`
+	Order       string           `json:"order"`
+	Refunds     []RefundResponse `json:"refunds,omitempty"`
+	Sum         money.Amount     `json:"sum" swaggertype:"number"`
+	Refunded    money.Amount     `json:"refunded,omitempty" swaggertype:"number"`
 }
 
 type BalanceHandler struct {
@@ -44,7 +57,7 @@ func NewBalanceHandler(logger *zap.Logger, storage storage.BalanceStorage, secre
 
 // GetBalance godoc.
 // @Summary Get user balance.
-// @Description Get current balance and total withdrawn points.
+// @Description Get current balance, total withdrawn and held points and points expiring within 30 days.
 // @Tags balance
 // @Produce json
 // @Success 200 {object} BalanceResponse
@@ -65,6 +78,13 @@ func (h *BalanceHandler) GetBalance(c *gin.Context) {
 	response := BalanceResponse{
 		Current:   balance.Current,
 		Withdrawn: balance.Withdrawn,
+		Held:      balance.Held,
+	}
+	for _, points := range balance.Expiring {
+		response.Expiring = append(response.Expiring, ExpiringPointsResponse{
+			ExpiresAt: points.ExpiresAt,
+			Sum:       points.Sum,
+		})
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -77,10 +97,11 @@ func (h *BalanceHandler) GetBalance(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param withdrawal body WithdrawRequest true "Withdrawal".
+// @Param Idempotency-Key header string false "Key to safely retry the request".
 // @Success 200 {string} string "Withdrawal successful".
 // @Failure 401 {string} string "Unauthorized".
 // @Failure 402 {string} string "Insufficient funds".
-// @Failure 422 {string} string "Invalid order number".
+// @Failure 422 {string} string "Invalid order number or sum".
 // @Failure 500 {string} string "Internal server error".
 // @Security BearerAuth
 // @Router /api/user/balance/withdraw [post].
@@ -88,11 +109,26 @@ func (h *BalanceHandler) Withdraw(c *gin.Context) {
 	userID := c.GetInt("userID")
 
 	var req WithdrawRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	err := c.ShouldBindJSON(&req)
+	if errors.Is(err, money.ErrInvalidAmount) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid sum"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
+	if req.Order == "" || !utils.IsValidLuhn(req.Order) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid order number"})
+		return
+	}
+
+	if req.Sum <= 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid sum"})
+		return
+	}
+
 	withdrawal := storage.Withdrawal{
 		UserID:      userID,
 		OrderNumber: req.Order,
@@ -100,7 +136,11 @@ func (h *BalanceHandler) Withdraw(c *gin.Context) {
 		ProcessedAt: time.Now(),
 	}
 
-	err := h.storage.Withdraw(userID, withdrawal)
+	err = h.storage.Withdraw(userID, withdrawal)
+	if errors.Is(err, storage.ErrInsufficientFunds) {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Insufficient funds"})
+		return
+	}
 	if err != nil {
 		h.logger.Error("failed to withdraw balance", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
@@ -112,11 +152,19 @@ func (h *BalanceHandler) Withdraw(c *gin.Context) {
 
 // GetWithdrawals godoc.
 // @Summary Get list of withdrawals.
-// @Description Get list of withdrawals made by the user.
+// @Description Get list of withdrawals made by the user, newest first unless sorted otherwise.
+// @Description Pass the X-Next-Cursor response header as cursor to get the next page.
 // @Tags withdrawal
 // @Produce json
+// @Param limit query int false "Page size, up to 1000".
+// @Param cursor query string false "Cursor of the page".
+// @Param from query string false "Processed at or after, YYYY-MM-DD or RFC 3339".
+// @Param to query string false "Processed before, YYYY-MM-DD (inclusive) or RFC 3339".
+// @Param sort query string false "Sort direction" Enums(asc, desc).
 // @Success 200 {array} WithdrawalResponse
+// @Header 200 {string} X-Next-Cursor "Cursor of the next page".
 // @Failure 204 {string} string "No content".
+// @Failure 400 {string} string "Invalid request".
 // @Failure 401 {string} string "Unauthorized".
 // @Failure 500 {string} string "Internal server error".
 // @Security BearerAuth
@@ -124,13 +172,27 @@ func (h *BalanceHandler) Withdraw(c *gin.Context) {
 func (h *BalanceHandler) GetWithdrawals(c *gin.Context) {
 	userID := c.GetInt("userID")
 
-	withdrawals, err := h.storage.GetWithdrawals(userID)
+	opts, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	withdrawals, cursor, err := h.storage.GetWithdrawals(userID, opts)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
 	if err != nil {
 		h.logger.Error("failed to get withdrawals", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
 
+	if cursor != "" {
+		c.Header(NextCursorHeader, cursor)
+	}
+
 	if len(withdrawals) == 0 {
 		c.JSON(http.StatusNoContent, nil)
 		return
@@ -138,9 +200,18 @@ func (h *BalanceHandler) GetWithdrawals(c *gin.Context) {
 
 	var response = make([]WithdrawalResponse, 0, len(withdrawals))
 	for _, withdrawal := range withdrawals {
+		var refunds []RefundResponse
+		for _, refund := range withdrawal.Refunds {
+			refunds = append(refunds, RefundResponse{
+				ProcessedAt: refund.ProcessedAt,
+				Sum:         refund.Sum,
+			})
+		}
 		response = append(response, WithdrawalResponse{
 			Order:       withdrawal.OrderNumber,
 			Sum:         withdrawal.Sum,
+			Refunded:    withdrawal.Refunded,
+			Refunds:     refunds,
 			ProcessedAt: withdrawal.ProcessedAt,
 		})
 	}
@@ -6,8 +6,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/money"
 	"github.com/krasvl/market/internal/storage"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
@@ -36,7 +38,7 @@ func (m *MockBalanceStorage) GetBalance(userID int) (storage.Balance, error) {
 func (m *MockBalanceStorage) Withdraw(userID int, withdrawal storage.Withdrawal) error {
 	balance, exists := m.balances[userID]
 	if !exists || balance.Current < withdrawal.Sum {
-		return errors.New("insufficient funds")
+		return storage.ErrInsufficientFunds
 	}
 	balance.Current -= withdrawal.Sum
 	balance.Withdrawn += withdrawal.Sum
@@ -45,8 +47,59 @@ func (m *MockBalanceStorage) Withdraw(userID int, withdrawal storage.Withdrawal)
 	return nil
 }
 
-func (m *MockBalanceStorage) GetWithdrawals(userID int) ([]storage.Withdrawal, error) {
-	return m.withdrawals[userID], nil
+func (m *MockBalanceStorage) GetWithdrawals(userID int, _ storage.ListOptions) ([]storage.Withdrawal, string, error) {
+	return m.withdrawals[userID], "", nil
+}
+
+func (m *MockBalanceStorage) RefundWithdrawal(
+	userID int,
+	refundID, orderNumber string,
+	sum money.Amount,
+) (storage.Refund, error) {
+	withdrawals := m.withdrawals[userID]
+	found := -1
+	for i := range withdrawals {
+		if withdrawals[i].OrderNumber != orderNumber {
+			continue
+		}
+		if found >= 0 {
+			return storage.Refund{}, storage.ErrWithdrawalAmbiguous
+		}
+		found = i
+	}
+	if found < 0 {
+		return storage.Refund{}, storage.ErrWithdrawalNotFound
+	}
+
+	withdrawal := &withdrawals[found]
+	for _, refund := range withdrawal.Refunds {
+		if refund.RefundID == refundID {
+			if sum != 0 && sum != refund.Sum {
+				return storage.Refund{}, storage.ErrRefundIDReused
+			}
+			return refund, nil
+		}
+	}
+
+	remaining := withdrawal.Sum - withdrawal.Refunded
+	if remaining == 0 {
+		return storage.Refund{}, storage.ErrWithdrawalRefunded
+	}
+	if sum == 0 {
+		sum = remaining
+	}
+	if sum > remaining {
+		return storage.Refund{}, storage.ErrRefundTooLarge
+	}
+	refund := storage.Refund{RefundID: refundID, Sum: sum, ProcessedAt: time.Now()}
+	withdrawal.Refunded += sum
+	withdrawal.Refunds = append(withdrawal.Refunds, refund)
+
+	balance := m.balances[userID]
+	balance.Current += sum
+	balance.Withdrawn -= sum
+	m.balances[userID] = balance
+	return refund, nil
 }
 
 func (m *MockBalanceStorage) AddBalance(balance storage.Balance) error {
@@ -73,7 +126,7 @@ func TestGetBalance(t *testing.T) {
 	router := gin.New()
 	router.GET("/api/user/balance", handler.GetBalance)
 
-	mockStorage.balances[0] = storage.Balance{UserID: 1, Current: 1000, Withdrawn: 0}
+	mockStorage.balances[0] = storage.Balance{UserID: 1, Current: money.FromPoints(1000), Withdrawn: 0}
 
 	t.Run("Valid Request", func(t *testing.T) {
 		w := httptest.NewRecorder()
@@ -92,7 +145,7 @@ func TestWithdraw(t *testing.T) {
 	router := gin.New()
 	router.POST("/api/user/balance/withdraw", handler.Withdraw)
 
-	mockStorage.balances[0] = storage.Balance{UserID: 1, Current: 1000, Withdrawn: 0}
+	mockStorage.balances[0] = storage.Balance{UserID: 1, Current: money.FromPoints(1000), Withdrawn: 0}
 
 	t.Run("Invalid Request", func(t *testing.T) {
 		w := httptest.NewRecorder()
@@ -105,11 +158,48 @@ func TestWithdraw(t *testing.T) {
 	t.Run("Valid Request", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest(http.MethodPost, "/api/user/balance/withdraw",
-			bytes.NewBufferString(`{"order": "123456789012", "sum": 100}`))
+			bytes.NewBufferString(`{"order": "2377225624", "sum": 100}`))
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusOK, w.Code)
 	})
+
+	t.Run("Invalid Order Number", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/user/balance/withdraw",
+			bytes.NewBufferString(`{"order": "123456789012", "sum": 100}`))
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("Non-positive Sum", func(t *testing.T) {
+		for _, body := range []string{`{"order": "2377225624", "sum": 0}`, `{"order": "2377225624", "sum": -5}`} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/api/user/balance/withdraw", bytes.NewBufferString(body))
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		}
+	})
+
+	t.Run("Sum Finer Than Cents", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/user/balance/withdraw",
+			bytes.NewBufferString(`{"order": "2377225624", "sum": 0.005}`))
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("Insufficient Funds", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/user/balance/withdraw",
+			bytes.NewBufferString(`{"order": "2377225624", "sum": 100000}`))
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusPaymentRequired, w.Code)
+	})
 }
 
 func TestGetWithdrawals(t *testing.T) {
@@ -121,7 +211,7 @@ func TestGetWithdrawals(t *testing.T) {
 	router.POST("/api/user/balance/withdraw", handler.Withdraw)
 	router.GET("/api/user/withdrawals", handler.GetWithdrawals)
 
-	mockStorage.balances[0] = storage.Balance{UserID: 1, Current: 1000, Withdrawn: 0}
+	mockStorage.balances[0] = storage.Balance{UserID: 1, Current: money.FromPoints(1000), Withdrawn: 0}
 
 	t.Run("No Withdrawals", func(t *testing.T) {
 		w := httptest.NewRecorder()
@@ -134,7 +224,7 @@ func TestGetWithdrawals(t *testing.T) {
 	t.Run("With Withdrawals", func(t *testing.T) {
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest(http.MethodPost, "/api/user/balance/withdraw",
-			bytes.NewBufferString(`{"order": "123456789012", "sum": 100}`))
+			bytes.NewBufferString(`{"order": "2377225624", "sum": 100}`))
 		router.ServeHTTP(w, req)
 
 		assert.Equal(t, http.StatusOK, w.Code)
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBatchSize limits how many order numbers a single batch upload may carry.
+const maxBatchSize = 1000
+
+// maxBatchBytes limits the size of a batch upload, which is plenty for
+// maxBatchSize numbers.
+const maxBatchBytes = 1 << 20
+
+// errBatchTooLarge is returned when a batch carries more than maxBatchSize
+// numbers or maxBatchBytes bytes.
+var errBatchTooLarge = errors.New("batch too large")
+
+// readOrderNumbers reads the order numbers of a batch upload. Numbers are
+// trimmed and deduplicated, keeping the order they came in. Reading stops as
+// soon as the batch turns out too large.
+func readOrderNumbers(c *gin.Context) ([]string, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBatchBytes)
+
+	var numbers []string
+	var err error
+
+	switch c.ContentType() {
+	case gin.MIMEJSON:
+		numbers, err = readJSONNumbers(c.Request.Body)
+	case "text/csv":
+		numbers, err = readCSVNumbers(c.Request.Body)
+	case gin.MIMEMultipartPOSTForm:
+		header, ferr := c.FormFile("file")
+		if ferr != nil {
+			return nil, tooLarge(ferr)
+		}
+		file, ferr := header.Open()
+		if ferr != nil {
+			return nil, ferr
+		}
+		defer func() { _ = file.Close() }()
+		numbers, err = readCSVNumbers(file)
+	default:
+		return nil, errors.New("unsupported content type")
+	}
+	if err != nil {
+		return nil, tooLarge(err)
+	}
+
+	seen := make(map[string]bool, len(numbers))
+	unique := numbers[:0]
+	for _, number := range numbers {
+		number = strings.TrimSpace(number)
+		if number == "" || seen[number] {
+			continue
+		}
+		seen[number] = true
+		unique = append(unique, number)
+	}
+	return unique, nil
+}
+
+// tooLarge reports a body cut off by the size limit as errBatchTooLarge.
+func tooLarge(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return errBatchTooLarge
+	}
+	return err
+}
+
+// readJSONNumbers reads an array of strings one element at a time.
+func readJSONNumbers(r io.Reader) ([]string, error) {
+	decoder := json.NewDecoder(r)
+	if token, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if token != json.Delim('[') {
+		return nil, errors.New("order numbers must be an array")
+	}
+
+	var numbers []string
+	for decoder.More() {
+		if len(numbers) == maxBatchSize {
+			return nil, errBatchTooLarge
+		}
+		var number string
+		if err := decoder.Decode(&number); err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	return numbers, nil
+}
+
+// readCSVNumbers takes the first column of every record, skipping a "number"
+// header row.
+func readCSVNumbers(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var numbers []string
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return numbers, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "number") {
+			continue
+		}
+		if len(numbers) == maxBatchSize {
+			return nil, errBatchTooLarge
+		}
+		numbers = append(numbers, record[0])
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/events"
+	"go.uber.org/zap"
+)
+
+// EventSubscriber delivers the events of a user.
+type EventSubscriber interface {
+	Subscribe(userID int) (<-chan events.Event, func())
+}
+
+type EventsHandler struct {
+	logger    *zap.Logger
+	events    EventSubscriber
+	heartbeat time.Duration
+}
+
+// NewEventsHandler creates the handler. heartbeat is how often an idle
+// stream gets a comment line, so proxies do not close it.
+func NewEventsHandler(logger *zap.Logger, events EventSubscriber, heartbeat time.Duration) *EventsHandler {
+	return &EventsHandler{
+		logger:    logger,
+		events:    events,
+		heartbeat: heartbeat,
+	}
+}
+
+// Stream godoc.
+// @Summary Stream user events.
+// @Description Push order status changes and balance updates as Server-Sent Events.
+// @Description Event names are "order", "balance" and "resync"; after "resync" the client should fetch its state again.
+// @Tags events
+// @Produce text/event-stream
+// @Success 200 {string} string "Event stream".
+// @Failure 401 {string} string "Unauthorized".
+// @Security BearerAuth
+// @Router /api/user/events [get].
+func (h *EventsHandler) Stream(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	stream, unsubscribe := h.events.Subscribe(userID)
+	defer unsubscribe()
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	// A stream outlives the write timeout of the server, the heartbeat finds
+	// dead connections instead.
+	if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+		h.logger.Warn("failed to clear write deadline", zap.Error(err))
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-stream:
+			if !ok {
+				h.logger.Info("event stream closed", zap.Int("user_id", userID))
+				return false
+			}
+			c.SSEvent(event.Type, string(event.Data))
+			return true
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return false
+			}
+			return true
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type MockEventSubscriber struct {
+	subscribed chan int
+	stream     chan events.Event
+}
+
+func (m *MockEventSubscriber) Subscribe(userID int) (<-chan events.Event, func()) {
+	m.subscribed <- userID
+	return m.stream, func() {}
+}
+
+func TestEventsStream(t *testing.T) {
+	logger := zap.NewNop()
+	subscriber := &MockEventSubscriber{subscribed: make(chan int, 1), stream: make(chan events.Event)}
+	handler := NewEventsHandler(logger, subscriber, 10*time.Millisecond)
+
+	router := gin.New()
+	router.GET("/api/user/events", func(c *gin.Context) {
+		c.Set("userID", 7)
+		handler.Stream(c)
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/user/events", http.NoBody)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	assert.Equal(t, 7, <-subscriber.subscribed)
+
+	subscriber.stream <- events.Event{
+		UserID: 7,
+		Type:   events.TypeOrder,
+		Data:   json.RawMessage(`{"number":"2377225624","status":"PROCESSED"}`),
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event:") || strings.HasPrefix(line, "data:") {
+			lines = append(lines, line)
+		}
+		if strings.HasPrefix(line, "data:") {
+			break
+		}
+	}
+	require.NoError(t, scanner.Err())
+	assert.Equal(t, []string{"event:order", `data:{"number":"2377225624","status":"PROCESSED"}`}, lines)
+
+	close(subscriber.stream)
+}
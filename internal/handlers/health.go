@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/storage"
+	"go.uber.org/zap"
+)
+
+// readyTimeout bounds the database checks of a readiness probe.
+const readyTimeout = 2 * time.Second
+
+type HealthHandler struct {
+	logger   *zap.Logger
+	storage  storage.HealthStorage
+	draining atomic.Bool
+}
+
+func NewHealthHandler(logger *zap.Logger, storage storage.HealthStorage) *HealthHandler {
+	return &HealthHandler{
+		logger:  logger,
+		storage: storage,
+	}
+}
+
+// Drain makes the readiness probe fail from now on, so no new requests are
+// routed here while the server shuts down.
+func (h *HealthHandler) Drain() {
+	h.draining.Store(true)
+}
+
+// Live godoc.
+// @Summary Liveness probe.
+// @Description Answer as long as the server is running.
+// @Tags health
+// @Produce json
+// @Success 200 {string} string "OK".
+// @Router /healthz [get].
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Ready godoc.
+// @Summary Readiness probe.
+// @Description Check that the database answers and its migrations are applied.
+// @Tags health
+// @Produce json
+// @Success 200 {string} string "OK".
+// @Failure 503 {string} string "Not ready".
+// @Router /readyz [get].
+func (h *HealthHandler) Ready(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyTimeout)
+	defer cancel()
+	if err := h.storage.Ready(ctx); err != nil {
+		h.logger.Warn("not ready", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Not ready"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type MockHealthStorage struct {
+	err error
+}
+
+func (m *MockHealthStorage) Ready(_ context.Context) error {
+	return m.err
+}
+
+func TestHealth(t *testing.T) {
+	logger := zap.NewNop()
+	mockStorage := &MockHealthStorage{}
+	handler := NewHealthHandler(logger, mockStorage)
+
+	router := gin.New()
+	router.GET("/healthz", handler.Live)
+	router.GET("/readyz", handler.Ready)
+
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Ready", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, get("/healthz"))
+		assert.Equal(t, http.StatusOK, get("/readyz"))
+	})
+
+	t.Run("Migrations Pending", func(t *testing.T) {
+		mockStorage.err = storage.ErrMigrationsPending
+		assert.Equal(t, http.StatusOK, get("/healthz"))
+		assert.Equal(t, http.StatusServiceUnavailable, get("/readyz"))
+		mockStorage.err = nil
+	})
+
+	t.Run("Draining", func(t *testing.T) {
+		handler.Drain()
+		assert.Equal(t, http.StatusOK, get("/healthz"))
+		assert.Equal(t, http.StatusServiceUnavailable, get("/readyz"))
+	})
+}
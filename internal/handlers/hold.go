@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/money"
+	"github.com/krasvl/market/internal/storage"
+	"github.com/krasvl/market/internal/utils"
+	"go.uber.org/zap"
+)
+
+// HoldRequest represents the request body for reserving points.
+type HoldRequest struct {
+	Order string       `json:"order"`
+	Sum   money.Amount `json:"sum" swaggertype:"number"`
+}
+
+// HoldResponse represents a reservation of points.
+type HoldResponse struct {
+	CreatedAt time.Time          `json:"created_at"`
+	ExpiresAt time.Time          `json:"expires_at"`
+	Order     string             `json:"order"`
+	Status    storage.HoldStatus `json:"status"`
+	ID        int                `json:"id"`
+	Sum       money.Amount       `json:"sum" swaggertype:"number"`
+}
+
+type HoldHandler struct {
+	logger  *zap.Logger
+	storage storage.HoldStorage
+	holdTTL time.Duration
+}
+
+func NewHoldHandler(logger *zap.Logger, storage storage.HoldStorage, holdTTL time.Duration) *HoldHandler {
+	return &HoldHandler{
+		logger:  logger,
+		storage: storage,
+		holdTTL: holdTTL,
+	}
+}
+
+// PlaceHold godoc.
+// @Summary Reserve points.
+// @Description Reserve points for an order while its payment is in flight.
+// @Tags hold
+// @Accept json
+// @Produce json
+// @Param hold body HoldRequest true "Hold".
+// @Success 201 {object} HoldResponse
+// @Failure 400 {string} string "Invalid request".
+// @Failure 401 {string} string "Unauthorized".
+// @Failure 402 {string} string "Insufficient funds".
+// @Failure 422 {string} string "Invalid order number or sum".
+// @Failure 500 {string} string "Internal server error".
+// @Security BearerAuth
+// @Router /api/user/balance/holds [post].
+func (h *HoldHandler) PlaceHold(c *gin.Context) {
+	var req HoldRequest
+	err := c.ShouldBindJSON(&req)
+	if errors.Is(err, money.ErrInvalidAmount) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid sum"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	placeHold(c, h.logger, h.storage, c.GetInt("userID"), req.Order, req.Sum, h.holdTTL)
+}
+
+// CaptureHold godoc.
+// @Summary Capture reserved points.
+// @Description Turn a hold into a withdrawal once the payment succeeded.
+// @Tags hold
+// @Produce json
+// @Param id path int true "Hold ID".
+// @Success 200 {object} HoldResponse
+// @Failure 400 {string} string "Invalid request".
+// @Failure 401 {string} string "Unauthorized".
+// @Failure 404 {string} string "Hold not found".
+// @Failure 409 {string} string "Hold already captured, released or expired".
+// @Failure 500 {string} string "Internal server error".
+// @Security BearerAuth
+// @Router /api/user/balance/holds/{id}/capture [post].
+func (h *HoldHandler) CaptureHold(c *gin.Context) {
+	resolveHold(c, h.logger, c.GetInt("userID"), h.storage.CaptureHold)
+}
+
+// ReleaseHold godoc.
+// @Summary Release reserved points.
+// @Description Return held points to the balance once the payment failed.
+// @Tags hold
+// @Produce json
+// @Param id path int true "Hold ID".
+// @Success 200 {object} HoldResponse
+// @Failure 400 {string} string "Invalid request".
+// @Failure 401 {string} string "Unauthorized".
+// @Failure 404 {string} string "Hold not found".
+// @Failure 409 {string} string "Hold already captured or released".
+// @Failure 500 {string} string "Internal server error".
+// @Security BearerAuth
+// @Router /api/user/balance/holds/{id}/release [post].
+func (h *HoldHandler) ReleaseHold(c *gin.Context) {
+	resolveHold(c, h.logger, c.GetInt("userID"), h.storage.ReleaseHold)
+}
+
+func placeHold(
+	c *gin.Context,
+	logger *zap.Logger,
+	holds storage.HoldStorage,
+	userID int,
+	order string,
+	sum money.Amount,
+	ttl time.Duration,
+) {
+	if order == "" || !utils.IsValidLuhn(order) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid order number"})
+		return
+	}
+
+	if sum <= 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid sum"})
+		return
+	}
+
+	hold, err := holds.Hold(userID, order, sum, ttl)
+	if errors.Is(err, storage.ErrInsufficientFunds) {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Insufficient funds"})
+		return
+	}
+	if err != nil {
+		logger.Error("failed to hold points", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, newHoldResponse(hold))
+}
+
+func resolveHold(
+	c *gin.Context,
+	logger *zap.Logger,
+	userID int,
+	resolve func(userID, holdID int) (storage.Hold, error),
+) {
+	holdID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	hold, err := resolve(userID, holdID)
+	switch {
+	case errors.Is(err, storage.ErrHoldNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Hold not found"})
+		return
+	case errors.Is(err, storage.ErrHoldResolved):
+		c.JSON(http.StatusConflict, gin.H{"error": "Hold already captured or released"})
+		return
+	case errors.Is(err, storage.ErrHoldExpired):
+		c.JSON(http.StatusConflict, gin.H{"error": "Hold expired"})
+		return
+	case err != nil:
+		logger.Error("failed to resolve hold", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newHoldResponse(hold))
+}
+
+func newHoldResponse(hold storage.Hold) HoldResponse {
+	return HoldResponse{
+		ID:        hold.ID,
+		Order:     hold.OrderNumber,
+		Sum:       hold.Sum,
+		Status:    hold.Status,
+		CreatedAt: hold.CreatedAt,
+		ExpiresAt: hold.ExpiresAt,
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/money"
+	"github.com/krasvl/market/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type MockHoldStorage struct {
+	holds     map[int]storage.Hold
+	available money.Amount
+}
+
+func NewMockHoldStorage(available money.Amount) *MockHoldStorage {
+	return &MockHoldStorage{holds: make(map[int]storage.Hold), available: available}
+}
+
+func (m *MockHoldStorage) Hold(
+	userID int,
+	orderNumber string,
+	sum money.Amount,
+	ttl time.Duration,
+) (storage.Hold, error) {
+	if sum > m.available {
+		return storage.Hold{}, storage.ErrInsufficientFunds
+	}
+	m.available -= sum
+	hold := storage.Hold{
+		ID:          len(m.holds) + 1,
+		UserID:      userID,
+		OrderNumber: orderNumber,
+		Sum:         sum,
+		Status:      storage.HoldStatusHeld,
+		CreatedAt:   time.Now(),
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+	m.holds[hold.ID] = hold
+	return hold, nil
+}
+
+func (m *MockHoldStorage) CaptureHold(userID, holdID int) (storage.Hold, error) {
+	return m.resolve(userID, holdID, storage.HoldStatusCaptured)
+}
+
+func (m *MockHoldStorage) ReleaseHold(userID, holdID int) (storage.Hold, error) {
+	hold, err := m.resolve(userID, holdID, storage.HoldStatusReleased)
+	if err == nil {
+		m.available += hold.Sum
+	}
+	return hold, err
+}
+
+func (m *MockHoldStorage) resolve(userID, holdID int, status storage.HoldStatus) (storage.Hold, error) {
+	hold, exists := m.holds[holdID]
+	if !exists || (userID != storage.AnyUser && hold.UserID != userID) {
+		return storage.Hold{}, storage.ErrHoldNotFound
+	}
+	if hold.Status != storage.HoldStatusHeld {
+		return storage.Hold{}, storage.ErrHoldResolved
+	}
+	if status == storage.HoldStatusCaptured && !hold.ExpiresAt.After(time.Now()) {
+		return storage.Hold{}, storage.ErrHoldExpired
+	}
+	hold.Status = status
+	m.holds[holdID] = hold
+	return hold, nil
+}
+
+func TestHolds(t *testing.T) {
+	logger := zap.NewNop()
+	mockStorage := NewMockHoldStorage(money.FromPoints(100))
+	handler := NewHoldHandler(logger, mockStorage, time.Minute)
+
+	router := gin.New()
+	router.POST("/api/user/balance/holds", handler.PlaceHold)
+	router.POST("/api/user/balance/holds/:id/capture", handler.CaptureHold)
+	router.POST("/api/user/balance/holds/:id/release", handler.ReleaseHold)
+
+	send := func(url, body string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Invalid Order Number", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnprocessableEntity,
+			send("/api/user/balance/holds", `{"order": "123456789012", "sum": 10}`))
+	})
+
+	t.Run("Insufficient Funds", func(t *testing.T) {
+		assert.Equal(t, http.StatusPaymentRequired,
+			send("/api/user/balance/holds", `{"order": "2377225624", "sum": 1000}`))
+	})
+
+	t.Run("Capture", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, send("/api/user/balance/holds", `{"order": "2377225624", "sum": 60}`))
+		assert.Equal(t, http.StatusOK, send("/api/user/balance/holds/1/capture", ""))
+		assert.Equal(t, http.StatusConflict, send("/api/user/balance/holds/1/release", ""))
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, send("/api/user/balance/holds", `{"order": "12345678903", "sum": 40}`))
+		assert.Equal(t, http.StatusOK, send("/api/user/balance/holds/2/release", ""))
+		assert.Equal(t, money.FromPoints(40), mockStorage.available)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, send("/api/user/balance/holds", `{"order": "79927398713", "sum": 10}`))
+		hold := mockStorage.holds[3]
+		hold.ExpiresAt = time.Now().Add(-time.Second)
+		mockStorage.holds[3] = hold
+		assert.Equal(t, http.StatusConflict, send("/api/user/balance/holds/3/capture", ""))
+		assert.Equal(t, http.StatusOK, send("/api/user/balance/holds/3/release", ""))
+	})
+
+	t.Run("Unknown Hold", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, send("/api/user/balance/holds/42/capture", ""))
+		assert.Equal(t, http.StatusBadRequest, send("/api/user/balance/holds/abc/capture", ""))
+	})
+}
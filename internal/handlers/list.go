@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/storage"
+)
+
+// NextCursorHeader carries the cursor of the next page of a listing.
+const NextCursorHeader = "X-Next-Cursor"
+
+const maxPageSize = 1000
+
+var errInvalidListOptions = errors.New("invalid list options")
+
+// parseListOptions reads limit, cursor, sort and the from/to range of a
+// listing from the query string. Without them the listing is returned whole,
+// newest first.
+func parseListOptions(c *gin.Context) (storage.ListOptions, error) {
+	var opts storage.ListOptions
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return storage.ListOptions{}, errInvalidListOptions
+		}
+		opts.Limit = limit
+	}
+
+	switch c.Query("sort") {
+	case "", "desc":
+	case "asc":
+		opts.Ascending = true
+	default:
+		return storage.ListOptions{}, errInvalidListOptions
+	}
+
+	var err error
+	if opts.From, err = parseTime(c.Query("from"), false); err != nil {
+		return storage.ListOptions{}, errInvalidListOptions
+	}
+	if opts.To, err = parseTime(c.Query("to"), true); err != nil {
+		return storage.ListOptions{}, errInvalidListOptions
+	}
+	if !opts.From.IsZero() && !opts.To.IsZero() && !opts.From.Before(opts.To) {
+		return storage.ListOptions{}, errInvalidListOptions
+	}
+
+	opts.Cursor = c.Query("cursor")
+	return opts, nil
+}
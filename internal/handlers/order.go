@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/money"
 	"github.com/krasvl/market/internal/storage"
 	"github.com/krasvl/market/internal/utils"
 	"go.uber.org/zap"
@@ -14,8 +16,32 @@ import (
 type OrderResponse struct {
 	UploadedAt time.Time           `json:"uploaded_at"`
 	Number     string              `json:"number"`
-	Status     storage.OrderStatus `json:"status"`
-	Accrual    float64             `json:"accrual,omitempty"`
+	Status     storage.OrderStatus `json:"status" swaggertype:"string" enums:"NEW,PROCESSING,INVALID,PROCESSED"`
+	Accrual    money.Amount        `json:"accrual,omitempty" swaggertype:"number"`
+}
+
+// OrderDetailResponse represents an order with its timeline.
+type OrderDetailResponse struct {
+	UploadedAt   time.Time            `json:"uploaded_at"`
+	LastPolledAt *time.Time           `json:"last_polled_at,omitempty"`
+	Number       string               `json:"number"`
+	Status       storage.OrderStatus  `json:"status" swaggertype:"string" enums:"NEW,PROCESSING,INVALID,PROCESSED"`
+	Events       []OrderEventResponse `json:"events"`
+	Polls        int                  `json:"polls"`
+	Accrual      money.Amount         `json:"accrual,omitempty" swaggertype:"number"`
+}
+
+// OrderEventResponse represents a status or accrual change of an order.
+type OrderEventResponse struct {
+	CreatedAt time.Time           `json:"created_at"`
+	Status    storage.OrderStatus `json:"status" swaggertype:"string" enums:"NEW,PROCESSING,INVALID,PROCESSED"`
+	Accrual   money.Amount        `json:"accrual,omitempty" swaggertype:"number"`
+}
+
+// OrderUploadResponse represents the outcome for a number of a batch upload.
+type OrderUploadResponse struct {
+	Number string               `json:"number"`
+	Result storage.UploadResult `json:"result" enums:"ACCEPTED,ALREADY_UPLOADED,OWNED_BY_ANOTHER_USER,INVALID"`
 }
 
 type OrderHandler struct {
@@ -39,6 +65,7 @@ func NewOrderHandler(logger *zap.Logger, storage storage.OrderStorage, secret st
 // @Accept plain
 // @Produce json
 // @Param order body string true "Order Number".
+// @Param Idempotency-Key header string false "Key to safely retry the request".
 // @Success 200 {string} string "Order already uploaded by this user.".
 // @Success 202 {string} string "Order accepted for processing.".
 // @Failure 400 {string} string "Invalid request.".
@@ -93,13 +120,82 @@ func (h *OrderHandler) AddOrder(c *gin.Context) {
 	c.JSON(http.StatusAccepted, gin.H{"message": "Order accepted for processing."})
 }
 
+// AddOrders godoc.
+// @Summary Submit a batch of order numbers.
+// @Description Submit order numbers as a JSON array of strings or as CSV with a number in the first column,
+// @Description either as the request body or as a multipart "file" upload. An optional "number" header row is skipped.
+// @Description Every number gets its own result, so a batch is accepted even if some numbers are not.
+// @Tags order
+// @Accept json,text/csv,multipart/form-data
+// @Produce json
+// @Param orders body []string false "Order numbers".
+// @Param file formData file false "CSV file with order numbers".
+// @Param Idempotency-Key header string false "Key to safely retry the request".
+// @Success 200 {array} OrderUploadResponse
+// @Failure 400 {string} string "Invalid request.".
+// @Failure 401 {string} string "Unauthorized.".
+// @Failure 413 {string} string "Too many order numbers.".
+// @Failure 500 {string} string "Internal server error.".
+// @Security BearerAuth
+// @Router /api/user/orders/batch [post].
+func (h *OrderHandler) AddOrders(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	numbers, err := readOrderNumbers(c)
+	if errors.Is(err, errBatchTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Too many order numbers."})
+		return
+	}
+	if err != nil || len(numbers) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request."})
+		return
+	}
+
+	var valid []string
+	for _, number := range numbers {
+		if utils.IsValidLuhn(number) {
+			valid = append(valid, number)
+		}
+	}
+
+	results := map[string]storage.UploadResult{}
+	if len(valid) > 0 {
+		results, err = h.storage.AddOrders(userID, valid)
+		if err != nil {
+			h.logger.Error("failed to add orders", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error."})
+			return
+		}
+	}
+
+	var response = make([]OrderUploadResponse, 0, len(numbers))
+	for _, number := range numbers {
+		result, ok := results[number]
+		if !ok {
+			result = storage.UploadInvalid
+		}
+		response = append(response, OrderUploadResponse{Number: number, Result: result})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
 // GetOrders godoc.
 // @Summary Get list of orders.
-// @Description Get list of orders submitted by the user.
+// @Description Get list of orders submitted by the user, newest first unless sorted otherwise.
+// @Description Pass the X-Next-Cursor response header as cursor to get the next page.
 // @Tags order
 // @Produce json
+// @Param limit query int false "Page size, up to 1000".
+// @Param cursor query string false "Cursor of the page".
+// @Param status query string false "Order status" Enums(NEW, PROCESSING, INVALID, PROCESSED).
+// @Param from query string false "Uploaded at or after, YYYY-MM-DD or RFC 3339".
+// @Param to query string false "Uploaded before, YYYY-MM-DD (inclusive) or RFC 3339".
+// @Param sort query string false "Sort direction" Enums(asc, desc).
 // @Success 200 {array} OrderResponse
+// @Header 200 {string} X-Next-Cursor "Cursor of the next page".
 // @Failure 204 {string} string "No content.".
+// @Failure 400 {string} string "Invalid request.".
 // @Failure 401 {string} string "Unauthorized.".
 // @Failure 500 {string} string "Internal server error.".
 // @Security BearerAuth
@@ -107,13 +203,34 @@ func (h *OrderHandler) AddOrder(c *gin.Context) {
 func (h *OrderHandler) GetOrders(c *gin.Context) {
 	userID := c.GetInt("userID")
 
-	orders, err := h.storage.GetOrders(userID)
+	opts, err := parseListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request."})
+		return
+	}
+	switch status := storage.OrderStatus(c.Query("status")); status {
+	case "", storage.StatusNew, storage.StatusProcessing, storage.StatusInvalid, storage.StatusProcessed:
+		opts.Status = status
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request."})
+		return
+	}
+
+	orders, cursor, err := h.storage.GetOrders(userID, opts)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request."})
+		return
+	}
 	if err != nil {
 		h.logger.Error("failed to get orders", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error."})
 		return
 	}
 
+	if cursor != "" {
+		c.Header(NextCursorHeader, cursor)
+	}
+
 	if len(orders) == 0 {
 		c.JSON(http.StatusNoContent, nil)
 		return
@@ -123,7 +240,7 @@ func (h *OrderHandler) GetOrders(c *gin.Context) {
 	for _, order := range orders {
 		response = append(response, OrderResponse{
 			Number:     order.Number,
-			Status:     order.Status,
+			Status:     publicStatus(order.Status),
 			Accrual:    order.Accrual,
 			UploadedAt: order.UploadedAt,
 		})
@@ -131,3 +248,63 @@ func (h *OrderHandler) GetOrders(c *gin.Context) {
 
 	c.JSON(http.StatusOK, response)
 }
+
+// GetOrder godoc.
+// @Summary Get an order.
+// @Description Get an order of the user with its status history and how many times the accrual system was polled.
+// @Tags order
+// @Produce json
+// @Param number path string true "Order Number".
+// @Success 200 {object} OrderDetailResponse
+// @Failure 401 {string} string "Unauthorized.".
+// @Failure 404 {string} string "Order not found.".
+// @Failure 500 {string} string "Internal server error.".
+// @Security BearerAuth
+// @Router /api/user/orders/{number} [get].
+func (h *OrderHandler) GetOrder(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	order, err := h.storage.GetOrder(userID, c.Param("number"))
+	if errors.Is(err, storage.ErrOrderNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found."})
+		return
+	}
+	if err != nil {
+		h.logger.Error("failed to get order", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error."})
+		return
+	}
+
+	response := OrderDetailResponse{
+		Number:     order.Number,
+		Status:     publicStatus(order.Status),
+		Accrual:    order.Accrual,
+		UploadedAt: order.UploadedAt,
+		Polls:      order.Polls,
+		Events:     make([]OrderEventResponse, 0, len(order.Events)),
+	}
+	if !order.LastPolledAt.IsZero() {
+		response.LastPolledAt = &order.LastPolledAt
+	}
+	for _, event := range order.Events {
+		if event.Status == storage.StatusStuck {
+			continue
+		}
+		response.Events = append(response.Events, OrderEventResponse{
+			CreatedAt: event.CreatedAt,
+			Status:    event.Status,
+			Accrual:   event.Accrual,
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// publicStatus hides that an order is stuck, which only matters to admins:
+// users see the order as still being processed.
+func publicStatus(status storage.OrderStatus) storage.OrderStatus {
+	if status == storage.StatusStuck {
+		return storage.StatusProcessing
+	}
+	return status
+}
@@ -2,14 +2,23 @@ package handlers
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/money"
 	"github.com/krasvl/market/internal/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -40,19 +49,68 @@ func (m *MockOrderStorage) AddOrder(order *storage.Order) error {
 	return nil
 }
 
-func (m *MockOrderStorage) GetOrders(userID int) ([]storage.Order, error) {
+func (m *MockOrderStorage) AddOrders(userID int, numbers []string) (map[string]storage.UploadResult, error) {
+	results := make(map[string]storage.UploadResult, len(numbers))
+	for _, number := range numbers {
+		holderID, exists, _ := m.GetOrderHolder(number)
+		switch {
+		case !exists:
+			m.orders = append(m.orders, storage.Order{UserID: userID, Number: number, Status: storage.StatusNew})
+			results[number] = storage.UploadAccepted
+		case holderID == userID:
+			results[number] = storage.UploadAlreadyUploaded
+		default:
+			results[number] = storage.UploadConflict
+		}
+	}
+	return results, nil
+}
+
+func (m *MockOrderStorage) GetOrders(userID int, opts storage.ListOptions) ([]storage.Order, string, error) {
 	var userOrders []storage.Order
 	for _, order := range m.orders {
-		if order.UserID == userID {
+		status := order.Status
+		if status == storage.StatusStuck {
+			status = storage.StatusProcessing
+		}
+		if order.UserID == userID && (opts.Status == "" || status == opts.Status) {
 			userOrders = append(userOrders, order)
 		}
 	}
-	return userOrders, nil
+
+	offset := 0
+	if opts.Cursor != "" {
+		var err error
+		if offset, err = strconv.Atoi(opts.Cursor); err != nil {
+			return nil, "", storage.ErrInvalidCursor
+		}
+	}
+	userOrders = userOrders[min(offset, len(userOrders)):]
+	if opts.Limit > 0 && len(userOrders) > opts.Limit {
+		return userOrders[:opts.Limit], strconv.Itoa(offset + opts.Limit), nil
+	}
+	return userOrders, "", nil
+}
+
+func (m *MockOrderStorage) GetOrder(userID int, number string) (storage.Order, error) {
+	for _, order := range m.orders {
+		if order.UserID == userID && order.Number == number {
+			order.Events = []storage.OrderEvent{{Status: storage.StatusNew, CreatedAt: order.UploadedAt}}
+			if order.Status != storage.StatusNew {
+				order.Events = append(order.Events, storage.OrderEvent{Status: order.Status, Accrual: order.Accrual})
+			}
+			return order, nil
+		}
+	}
+	return storage.Order{}, storage.ErrOrderNotFound
 }
 
-func (m *MockOrderStorage) GetPendingOrders() ([]storage.Order, error) {
+func (m *MockOrderStorage) ClaimPendingOrders(_ string, limit int, _ time.Duration) ([]storage.Order, error) {
 	var pendingOrders []storage.Order
 	for _, order := range m.orders {
+		if len(pendingOrders) == limit {
+			break
+		}
 		if order.Status == storage.StatusNew || order.Status == storage.StatusProcessing {
 			pendingOrders = append(pendingOrders, order)
 		}
@@ -148,4 +206,199 @@ func TestGetOrders(t *testing.T) {
 
 		assert.Equal(t, http.StatusOK, w.Code)
 	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/user/orders", bytes.NewBufferString("4627100101654724"))
+		router.ServeHTTP(w, req)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest(http.MethodGet, "/api/user/orders?limit=1", http.NoBody)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		cursor := w.Header().Get(NextCursorHeader)
+		assert.NotEmpty(t, cursor)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest(http.MethodGet, "/api/user/orders?limit=1&cursor="+cursor, http.NoBody)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get(NextCursorHeader))
+	})
+
+	t.Run("Status Filter", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/user/orders?status=PROCESSED", http.NoBody)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("Invalid Options", func(t *testing.T) {
+		for _, query := range []string{
+			"limit=0", "limit=abc", "sort=up", "status=DONE", "status=STUCK", "cursor=abc", "from=tomorrow",
+		} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/api/user/orders?"+query, http.NoBody)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		}
+	})
+}
+
+func TestAddOrders(t *testing.T) {
+	logger := zap.NewNop()
+	mockStorage := NewMockOrderStorage()
+	mockStorage.orders = append(mockStorage.orders,
+		storage.Order{UserID: 1, Number: "4111111111111111", Status: storage.StatusNew},
+		storage.Order{UserID: 2, Number: "4627100101654724", Status: storage.StatusNew},
+	)
+	handler := NewOrderHandler(logger, mockStorage, "testsecret")
+
+	router := gin.New()
+	router.POST("/api/user/orders/batch", func(c *gin.Context) {
+		c.Set("userID", 1)
+		handler.AddOrders(c)
+	})
+
+	send := func(contentType string, body io.Reader) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/user/orders/batch", body)
+		req.Header.Set("Content-Type", contentType)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	expected := []OrderUploadResponse{
+		{Number: "2377225624", Result: storage.UploadAccepted},
+		{Number: "4111111111111111", Result: storage.UploadAlreadyUploaded},
+		{Number: "4627100101654724", Result: storage.UploadConflict},
+		{Number: "123456789012", Result: storage.UploadInvalid},
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		w := send("application/json",
+			strings.NewReader(`["2377225624", "4111111111111111", "4627100101654724", "123456789012", "2377225624"]`))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var response []OrderUploadResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, expected, response)
+	})
+
+	t.Run("CSV", func(t *testing.T) {
+		w := send("text/csv", strings.NewReader("number\n12345678903\n4111111111111111,receipt\n"))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var response []OrderUploadResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, []OrderUploadResponse{
+			{Number: "12345678903", Result: storage.UploadAccepted},
+			{Number: "4111111111111111", Result: storage.UploadAlreadyUploaded},
+		}, response)
+	})
+
+	t.Run("CSV File", func(t *testing.T) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		file, err := form.CreateFormFile("file", "orders.csv")
+		require.NoError(t, err)
+		_, err = file.Write([]byte("2377225624\n"))
+		require.NoError(t, err)
+		require.NoError(t, form.Close())
+
+		w := send(form.FormDataContentType(), &body)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var response []OrderUploadResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, []OrderUploadResponse{{Number: "2377225624", Result: storage.UploadAlreadyUploaded}}, response)
+	})
+
+	t.Run("Invalid Request", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, send("application/json", strings.NewReader(`[]`)).Code)
+		assert.Equal(t, http.StatusBadRequest, send("application/json", strings.NewReader(`{"order": 1}`)).Code)
+		assert.Equal(t, http.StatusBadRequest, send("text/plain", strings.NewReader("2377225624")).Code)
+	})
+
+	t.Run("Too Many Numbers", func(t *testing.T) {
+		var body strings.Builder
+		for i := range maxBatchSize + 1 {
+			fmt.Fprintf(&body, "%d\n", i)
+		}
+		assert.Equal(t, http.StatusRequestEntityTooLarge, send("text/csv", strings.NewReader(body.String())).Code)
+	})
+
+	t.Run("Too Many JSON Numbers", func(t *testing.T) {
+		numbers := make([]string, maxBatchSize+1)
+		for i := range numbers {
+			numbers[i] = fmt.Sprint(i)
+		}
+		body, err := json.Marshal(numbers)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, send("application/json", bytes.NewReader(body)).Code)
+	})
+
+	t.Run("Body Too Large", func(t *testing.T) {
+		body := strings.Repeat(" ", maxBatchBytes) + "[]"
+		assert.Equal(t, http.StatusRequestEntityTooLarge, send("application/json", strings.NewReader(body)).Code)
+	})
+}
+
+func TestGetOrder(t *testing.T) {
+	logger := zap.NewNop()
+	mockStorage := NewMockOrderStorage()
+	mockStorage.orders = append(mockStorage.orders,
+		storage.Order{UserID: 1, Number: "4111111111111111", Status: storage.StatusProcessed,
+			Accrual: money.FromPoints(500), Polls: 3, LastPolledAt: time.Now()},
+		storage.Order{UserID: 2, Number: "4627100101654724", Status: storage.StatusNew},
+		storage.Order{UserID: 1, Number: "79927398713", Status: storage.StatusStuck},
+	)
+	handler := NewOrderHandler(logger, mockStorage, "testsecret")
+
+	router := gin.New()
+	router.GET("/api/user/orders/:number", func(c *gin.Context) {
+		c.Set("userID", 1)
+		handler.GetOrder(c)
+	})
+
+	get := func(number string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/user/orders/"+number, http.NoBody)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Timeline", func(t *testing.T) {
+		w := get("4111111111111111")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response OrderDetailResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, 3, response.Polls)
+		assert.NotNil(t, response.LastPolledAt)
+		require.Len(t, response.Events, 2)
+		assert.Equal(t, storage.StatusNew, response.Events[0].Status)
+		assert.Equal(t, storage.StatusProcessed, response.Events[1].Status)
+		assert.Equal(t, money.FromPoints(500), response.Events[1].Accrual)
+	})
+
+	t.Run("Stuck Shown As Processing", func(t *testing.T) {
+		w := get("79927398713")
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response OrderDetailResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, storage.StatusProcessing, response.Status)
+		require.Len(t, response.Events, 1)
+		assert.Equal(t, storage.StatusNew, response.Events[0].Status)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("2377225624").Code)
+		assert.Equal(t, http.StatusNotFound, get("4627100101654724").Code)
+	})
 }
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/money"
+	"github.com/krasvl/market/internal/storage"
+	"go.uber.org/zap"
+)
+
+// RefundRequest represents the request body for refunding a withdrawal.
+// A zero or missing sum refunds the rest of the withdrawal. The refund id is
+// chosen by the caller, and sending it again returns the first refund.
+type RefundRequest struct {
+	Login    string       `json:"login" binding:"required"`
+	Order    string       `json:"order" binding:"required"`
+	RefundID string       `json:"refund_id" binding:"required"`
+	Sum      money.Amount `json:"sum,omitempty" swaggertype:"number"`
+}
+
+// RefundResponse represents a refund of a withdrawal.
+type RefundResponse struct {
+	ProcessedAt time.Time    `json:"processed_at"`
+	Sum         money.Amount `json:"sum" swaggertype:"number"`
+}
+
+// ServiceHoldRequest represents the request body for reserving a user's points.
+type ServiceHoldRequest struct {
+	Login string       `json:"login" binding:"required"`
+	Order string       `json:"order"`
+	Sum   money.Amount `json:"sum" swaggertype:"number"`
+}
+
+// ServiceHandler serves the API used by internal services such as the shop.
+type ServiceHandler struct {
+	logger  *zap.Logger
+	users   storage.UserStorage
+	balance storage.BalanceStorage
+	holds   storage.HoldStorage
+	holdTTL time.Duration
+}
+
+func NewServiceHandler(
+	logger *zap.Logger,
+	users storage.UserStorage,
+	balance storage.BalanceStorage,
+	holds storage.HoldStorage,
+	holdTTL time.Duration,
+) *ServiceHandler {
+	return &ServiceHandler{
+		logger:  logger,
+		users:   users,
+		balance: balance,
+		holds:   holds,
+		holdTTL: holdTTL,
+	}
+}
+
+// RefundWithdrawal godoc.
+// @Summary Refund a withdrawal.
+// @Description Return points spent on a cancelled order, in full or in part.
+// @Description Retrying with the same refund id does not return the points twice.
+// @Tags service
+// @Accept json
+// @Produce json
+// @Param refund body RefundRequest true "Refund".
+// @Success 200 {object} RefundResponse
+// @Failure 400 {string} string "Invalid request".
+// @Failure 401 {string} string "Unauthorized".
+// @Failure 404 {string} string "User or withdrawal not found".
+// @Failure 409 {string} string "Withdrawal already refunded, several withdrawals for the order or refund id reused".
+// @Failure 422 {string} string "Invalid sum".
+// @Failure 500 {string} string "Internal server error".
+// @Security ServiceAuth
+// @Router /api/service/refunds [post].
+func (h *ServiceHandler) RefundWithdrawal(c *gin.Context) {
+	var req RefundRequest
+	err := c.ShouldBindJSON(&req)
+	if errors.Is(err, money.ErrInvalidAmount) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid sum"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if req.Sum < 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid sum"})
+		return
+	}
+
+	user, ok := h.getUser(c, req.Login)
+	if !ok {
+		return
+	}
+
+	refund, err := h.balance.RefundWithdrawal(user.ID, req.RefundID, req.Order, req.Sum)
+	switch {
+	case errors.Is(err, storage.ErrWithdrawalNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Withdrawal not found"})
+		return
+	case errors.Is(err, storage.ErrWithdrawalRefunded):
+		c.JSON(http.StatusConflict, gin.H{"error": "Withdrawal already refunded"})
+		return
+	case errors.Is(err, storage.ErrWithdrawalAmbiguous):
+		c.JSON(http.StatusConflict, gin.H{"error": "Several withdrawals for the order"})
+		return
+	case errors.Is(err, storage.ErrRefundIDReused):
+		c.JSON(http.StatusConflict, gin.H{"error": "Refund id already used"})
+		return
+	case errors.Is(err, storage.ErrRefundTooLarge), errors.Is(err, storage.ErrInvalidSum):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid sum"})
+		return
+	case err != nil:
+		h.logger.Error("failed to refund withdrawal", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, RefundResponse{
+		ProcessedAt: refund.ProcessedAt,
+		Sum:         refund.Sum,
+	})
+}
+
+// PlaceHold godoc.
+// @Summary Reserve a user's points.
+// @Description Reserve points for a shop order while its payment is in flight.
+// @Tags service
+// @Accept json
+// @Produce json
+// @Param hold body ServiceHoldRequest true "Hold".
+// @Success 201 {object} HoldResponse
+// @Failure 400 {string} string "Invalid request".
+// @Failure 401 {string} string "Unauthorized".
+// @Failure 402 {string} string "Insufficient funds".
+// @Failure 404 {string} string "User not found".
+// @Failure 422 {string} string "Invalid order number or sum".
+// @Failure 500 {string} string "Internal server error".
+// @Security ServiceAuth
+// @Router /api/service/holds [post].
+func (h *ServiceHandler) PlaceHold(c *gin.Context) {
+	var req ServiceHoldRequest
+	err := c.ShouldBindJSON(&req)
+	if errors.Is(err, money.ErrInvalidAmount) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid sum"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	user, ok := h.getUser(c, req.Login)
+	if !ok {
+		return
+	}
+
+	placeHold(c, h.logger, h.holds, user.ID, req.Order, req.Sum, h.holdTTL)
+}
+
+// CaptureHold godoc.
+// @Summary Capture reserved points.
+// @Description Turn any user's hold into a withdrawal once the payment succeeded.
+// @Tags service
+// @Produce json
+// @Param id path int true "Hold ID".
+// @Success 200 {object} HoldResponse
+// @Failure 400 {string} string "Invalid request".
+// @Failure 401 {string} string "Unauthorized".
+// @Failure 404 {string} string "Hold not found".
+// @Failure 409 {string} string "Hold already captured, released or expired".
+// @Failure 500 {string} string "Internal server error".
+// @Security ServiceAuth
+// @Router /api/service/holds/{id}/capture [post].
+func (h *ServiceHandler) CaptureHold(c *gin.Context) {
+	resolveHold(c, h.logger, storage.AnyUser, h.holds.CaptureHold)
+}
+
+// ReleaseHold godoc.
+// @Summary Release reserved points.
+// @Description Return any user's held points once the payment failed.
+// @Tags service
+// @Produce json
+// @Param id path int true "Hold ID".
+// @Success 200 {object} HoldResponse
+// @Failure 400 {string} string "Invalid request".
+// @Failure 401 {string} string "Unauthorized".
+// @Failure 404 {string} string "Hold not found".
+// @Failure 409 {string} string "Hold already captured or released".
+// @Failure 500 {string} string "Internal server error".
+// @Security ServiceAuth
+// @Router /api/service/holds/{id}/release [post].
+func (h *ServiceHandler) ReleaseHold(c *gin.Context) {
+	resolveHold(c, h.logger, storage.AnyUser, h.holds.ReleaseHold)
+}
+
+func (h *ServiceHandler) getUser(c *gin.Context, login string) (storage.User, bool) {
+	user, err := h.users.GetUser(login)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return storage.User{}, false
+	}
+	if err != nil {
+		h.logger.Error("failed to get user", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return storage.User{}, false
+	}
+	return user, true
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/money"
+	"github.com/krasvl/market/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestRefundWithdrawal(t *testing.T) {
+	logger := zap.NewNop()
+	users := NewMockUserStorage()
+	balances := NewMockBalanceStorage()
+	handler := NewServiceHandler(logger, users, balances, NewMockHoldStorage(0), time.Minute)
+
+	router := gin.New()
+	router.POST("/api/service/refunds", handler.RefundWithdrawal)
+
+	userID, _ := users.AddUser(storage.User{Login: "test"})
+	balances.balances[userID] = storage.Balance{UserID: userID, Current: money.FromPoints(1000)}
+	_ = balances.Withdraw(userID, storage.Withdrawal{
+		UserID:      userID,
+		OrderNumber: "2377225624",
+		Sum:         money.FromPoints(100),
+		ProcessedAt: time.Now(),
+	})
+
+	refund := func(body string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/service/refunds", bytes.NewBufferString(body))
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Unknown User", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, refund(`{"login": "nobody", "order": "2377225624", "refund_id": "r0"}`))
+	})
+
+	t.Run("Missing Refund ID", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, refund(`{"login": "test", "order": "2377225624"}`))
+	})
+
+	t.Run("Unknown Withdrawal", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, refund(`{"login": "test", "order": "12345678903", "refund_id": "r0"}`))
+	})
+
+	t.Run("Too Large", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnprocessableEntity,
+			refund(`{"login": "test", "order": "2377225624", "refund_id": "r0", "sum": 150}`))
+	})
+
+	t.Run("Partial Then Rest", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, refund(`{"login": "test", "order": "2377225624", "refund_id": "r1", "sum": 40}`))
+		assert.Equal(t, http.StatusOK, refund(`{"login": "test", "order": "2377225624", "refund_id": "r2"}`))
+
+		balance := balances.balances[userID]
+		assert.Equal(t, money.FromPoints(1000), balance.Current)
+		assert.Equal(t, money.Amount(0), balance.Withdrawn)
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, refund(`{"login": "test", "order": "2377225624", "refund_id": "r1", "sum": 40}`))
+		assert.Equal(t, http.StatusConflict,
+			refund(`{"login": "test", "order": "2377225624", "refund_id": "r1", "sum": 30}`))
+		assert.Equal(t, money.FromPoints(1000), balances.balances[userID].Current)
+	})
+
+	t.Run("Double Refund", func(t *testing.T) {
+		assert.Equal(t, http.StatusConflict, refund(`{"login": "test", "order": "2377225624", "refund_id": "r3"}`))
+	})
+
+	t.Run("Several Withdrawals", func(t *testing.T) {
+		for range 2 {
+			_ = balances.Withdraw(userID, storage.Withdrawal{
+				UserID:      userID,
+				OrderNumber: "79927398713",
+				Sum:         money.FromPoints(10),
+				ProcessedAt: time.Now(),
+			})
+		}
+		assert.Equal(t, http.StatusConflict, refund(`{"login": "test", "order": "79927398713", "refund_id": "r4"}`))
+	})
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/money"
+	"github.com/krasvl/market/internal/storage"
+	"go.uber.org/zap"
+)
+
+const dateLayout = "2006-01-02"
+
+// StatementResponse represents the balance movements within a period.
+type StatementResponse struct {
+	From           *time.Time              `json:"from,omitempty"`
+	To             *time.Time              `json:"to,omitempty"`
+	Lines          []StatementLineResponse `json:"lines"`
+	OpeningBalance money.Amount            `json:"opening_balance" swaggertype:"number"`
+	ClosingBalance money.Amount            `json:"closing_balance" swaggertype:"number"`
+}
+
+// StatementLineResponse represents a balance movement and the balance after it.
+type StatementLineResponse struct {
+	CreatedAt time.Time               `json:"created_at"`
+	Operation storage.LedgerOperation `json:"operation"`
+	Order     string                  `json:"order,omitempty"`
+	Amount    money.Amount            `json:"amount" swaggertype:"number"`
+	Balance   money.Amount            `json:"balance" swaggertype:"number"`
+}
+
+type StatementHandler struct {
+	logger  *zap.Logger
+	storage storage.StatementStorage
+}
+
+func NewStatementHandler(logger *zap.Logger, storage storage.StatementStorage) *StatementHandler {
+	return &StatementHandler{
+		logger:  logger,
+		storage: storage,
+	}
+}
+
+// GetStatement godoc.
+// @Summary Get account statement.
+// @Description Get accruals, withdrawals and other balance movements in chronological order with a running balance.
+// @Description Dates are either YYYY-MM-DD or RFC 3339, a date in "to" includes the whole day.
+// @Tags balance
+// @Produce json
+// @Param from query string false "Start of the period".
+// @Param to query string false "End of the period".
+// @Success 200 {object} StatementResponse
+// @Failure 400 {string} string "Invalid period".
+// @Failure 401 {string} string "Unauthorized".
+// @Failure 500 {string} string "Internal server error".
+// @Security BearerAuth
+// @Router /api/user/statement [get].
+func (h *StatementHandler) GetStatement(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	from, err := parseTime(c.Query("from"), false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid period"})
+		return
+	}
+	to, err := parseTime(c.Query("to"), true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid period"})
+		return
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid period"})
+		return
+	}
+
+	statement, err := h.storage.GetStatement(userID, from, to)
+	if err != nil {
+		h.logger.Error("failed to get statement", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	response := StatementResponse{
+		Lines:          make([]StatementLineResponse, 0, len(statement.Lines)),
+		OpeningBalance: statement.OpeningBalance,
+		ClosingBalance: statement.ClosingBalance,
+	}
+	if !from.IsZero() {
+		response.From = &from
+	}
+	if !to.IsZero() {
+		response.To = &to
+	}
+	for _, line := range statement.Lines {
+		response.Lines = append(response.Lines, StatementLineResponse{
+			CreatedAt: line.CreatedAt,
+			Operation: line.Operation,
+			Order:     line.OrderNumber,
+			Amount:    line.Amount,
+			Balance:   line.Balance,
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// parseTime parses a date or an RFC 3339 time. An empty value gives the zero
+// time. With endOfDay a bare date means the end of that day.
+func parseTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(dateLayout, value); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/money"
+	"github.com/krasvl/market/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type MockStatementStorage struct {
+	from, to time.Time
+}
+
+func (m *MockStatementStorage) GetStatement(_ int, from, to time.Time) (storage.Statement, error) {
+	m.from, m.to = from, to
+	return storage.Statement{
+		Lines: []storage.StatementLine{
+			{Operation: storage.OperationAccrual, OrderNumber: "2377225624", Amount: money.FromPoints(100),
+				Balance: money.FromPoints(150)},
+			{Operation: storage.OperationWithdrawal, OrderNumber: "12345678903", Amount: money.FromPoints(-30),
+				Balance: money.FromPoints(120)},
+		},
+		OpeningBalance: money.FromPoints(50),
+		ClosingBalance: money.FromPoints(120),
+	}, nil
+}
+
+func TestGetStatement(t *testing.T) {
+	logger := zap.NewNop()
+	mockStorage := &MockStatementStorage{}
+	handler := NewStatementHandler(logger, mockStorage)
+
+	router := gin.New()
+	router.GET("/api/user/statement", handler.GetStatement)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/user/statement"+query, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Whole History", func(t *testing.T) {
+		w := get("")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, mockStorage.from.IsZero())
+		assert.True(t, mockStorage.to.IsZero())
+
+		var response StatementResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.Len(t, response.Lines, 2)
+		assert.Equal(t, money.FromPoints(50), response.OpeningBalance)
+		assert.Equal(t, money.FromPoints(120), response.Lines[1].Balance)
+		assert.Equal(t, money.FromPoints(120), response.ClosingBalance)
+	})
+
+	t.Run("Date Range", func(t *testing.T) {
+		w := get("?from=2024-01-01&to=2024-01-31")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), mockStorage.from)
+		assert.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), mockStorage.to)
+	})
+
+	t.Run("Invalid Period", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, get("?from=yesterday").Code)
+		assert.Equal(t, http.StatusBadRequest, get("?from=2024-02-01&to=2024-01-01").Code)
+	})
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/money"
+	"github.com/krasvl/market/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	TransferOutgoing = "OUTGOING"
+	TransferIncoming = "INCOMING"
+)
+
+// TransferRequest represents the request body for gifting points to another user.
+type TransferRequest struct {
+	Login string       `json:"login" binding:"required"`
+	Sum   money.Amount `json:"sum" swaggertype:"number"`
+}
+
+// TransferResponse represents a transfer as seen by one of its sides. Login
+// is the other side of the transfer.
+type TransferResponse struct {
+	ProcessedAt time.Time    `json:"processed_at"`
+	Direction   string       `json:"direction" enums:"OUTGOING,INCOMING"`
+	Login       string       `json:"login"`
+	ID          int          `json:"id"`
+	Sum         money.Amount `json:"sum" swaggertype:"number"`
+}
+
+type TransferHandler struct {
+	logger  *zap.Logger
+	storage storage.TransferStorage
+}
+
+func NewTransferHandler(logger *zap.Logger, storage storage.TransferStorage) *TransferHandler {
+	return &TransferHandler{
+		logger:  logger,
+		storage: storage,
+	}
+}
+
+// Transfer godoc.
+// @Summary Transfer points to another user.
+// @Description Gift points to another user, within the daily transfer limit.
+// @Tags transfer
+// @Accept json
+// @Produce json
+// @Param transfer body TransferRequest true "Transfer".
+// @Param Idempotency-Key header string false "Key to safely retry the request".
+// @Success 200 {object} TransferResponse
+// @Failure 400 {string} string "Invalid request".
+// @Failure 401 {string} string "Unauthorized".
+// @Failure 402 {string} string "Insufficient funds".
+// @Failure 403 {string} string "Daily transfer limit exceeded".
+// @Failure 404 {string} string "User not found".
+// @Failure 422 {string} string "Invalid sum or transfer to yourself".
+// @Failure 500 {string} string "Internal server error".
+// @Security BearerAuth
+// @Router /api/user/balance/transfer [post].
+func (h *TransferHandler) Transfer(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req TransferRequest
+	err := c.ShouldBindJSON(&req)
+	if errors.Is(err, money.ErrInvalidAmount) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid sum"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if req.Sum <= 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid sum"})
+		return
+	}
+
+	transfer, err := h.storage.Transfer(userID, req.Login, req.Sum)
+	switch {
+	case errors.Is(err, storage.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	case errors.Is(err, storage.ErrSelfTransfer):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Cannot transfer points to yourself"})
+		return
+	case errors.Is(err, storage.ErrInsufficientFunds):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Insufficient funds"})
+		return
+	case errors.Is(err, storage.ErrTransferLimitExceeded):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Daily transfer limit exceeded"})
+		return
+	case err != nil:
+		h.logger.Error("failed to transfer points", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newTransferResponse(userID, transfer))
+}
+
+// GetTransfers godoc.
+// @Summary Get list of transfers.
+// @Description Get transfers sent or received by the user, newest first.
+// @Tags transfer
+// @Produce json
+// @Success 200 {array} TransferResponse
+// @Failure 204 {string} string "No content".
+// @Failure 401 {string} string "Unauthorized".
+// @Failure 500 {string} string "Internal server error".
+// @Security BearerAuth
+// @Router /api/user/transfers [get].
+func (h *TransferHandler) GetTransfers(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	transfers, err := h.storage.GetTransfers(userID)
+	if err != nil {
+		h.logger.Error("failed to get transfers", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if len(transfers) == 0 {
+		c.JSON(http.StatusNoContent, nil)
+		return
+	}
+
+	var response = make([]TransferResponse, 0, len(transfers))
+	for _, transfer := range transfers {
+		response = append(response, newTransferResponse(userID, transfer))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func newTransferResponse(userID int, transfer storage.Transfer) TransferResponse {
+	response := TransferResponse{
+		ID:          transfer.ID,
+		Direction:   TransferOutgoing,
+		Login:       transfer.RecipientLogin,
+		Sum:         transfer.Sum,
+		ProcessedAt: transfer.ProcessedAt,
+	}
+	if transfer.RecipientID == userID {
+		response.Direction = TransferIncoming
+		response.Login = transfer.SenderLogin
+	}
+	return response
+}
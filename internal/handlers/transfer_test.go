@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/money"
+	"github.com/krasvl/market/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type MockTransferStorage struct {
+	users     map[string]int
+	balances  map[int]money.Amount
+	transfers []storage.Transfer
+}
+
+func NewMockTransferStorage() *MockTransferStorage {
+	return &MockTransferStorage{
+		users:    map[string]int{"alice": 1, "bob": 2},
+		balances: map[int]money.Amount{1: money.FromPoints(100), 2: 0},
+	}
+}
+
+func (m *MockTransferStorage) Transfer(senderID int, recipientLogin string, sum money.Amount) (storage.Transfer, error) {
+	recipientID, exists := m.users[recipientLogin]
+	if !exists {
+		return storage.Transfer{}, storage.ErrUserNotFound
+	}
+	if recipientID == senderID {
+		return storage.Transfer{}, storage.ErrSelfTransfer
+	}
+	if m.balances[senderID] < sum {
+		return storage.Transfer{}, storage.ErrInsufficientFunds
+	}
+	m.balances[senderID] -= sum
+	m.balances[recipientID] += sum
+
+	transfer := storage.Transfer{
+		ID:             len(m.transfers) + 1,
+		SenderID:       senderID,
+		SenderLogin:    "alice",
+		RecipientID:    recipientID,
+		RecipientLogin: recipientLogin,
+		Sum:            sum,
+		ProcessedAt:    time.Now(),
+	}
+	m.transfers = append(m.transfers, transfer)
+	return transfer, nil
+}
+
+func (m *MockTransferStorage) GetTransfers(userID int) ([]storage.Transfer, error) {
+	var transfers []storage.Transfer
+	for _, transfer := range m.transfers {
+		if transfer.SenderID == userID || transfer.RecipientID == userID {
+			transfers = append(transfers, transfer)
+		}
+	}
+	return transfers, nil
+}
+
+func TestTransfer(t *testing.T) {
+	logger := zap.NewNop()
+	mockStorage := NewMockTransferStorage()
+	handler := NewTransferHandler(logger, mockStorage)
+
+	router := gin.New()
+	router.POST("/api/user/balance/transfer", func(c *gin.Context) {
+		c.Set("userID", 1)
+		handler.Transfer(c)
+	})
+
+	send := func(body string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/user/balance/transfer", bytes.NewBufferString(body))
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send(`{"login": "bob", "sum": 30}`))
+		assert.Equal(t, money.FromPoints(70), mockStorage.balances[1])
+		assert.Equal(t, money.FromPoints(30), mockStorage.balances[2])
+	})
+
+	t.Run("Self Transfer", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnprocessableEntity, send(`{"login": "alice", "sum": 10}`))
+	})
+
+	t.Run("Unknown Recipient", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, send(`{"login": "carol", "sum": 10}`))
+	})
+
+	t.Run("Insufficient Funds", func(t *testing.T) {
+		assert.Equal(t, http.StatusPaymentRequired, send(`{"login": "bob", "sum": 1000}`))
+	})
+
+	t.Run("Invalid Sum", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnprocessableEntity, send(`{"login": "bob", "sum": 0}`))
+		assert.Equal(t, http.StatusUnprocessableEntity, send(`{"login": "bob", "sum": 1.234}`))
+		assert.Equal(t, http.StatusBadRequest, send(`{"sum": 10}`))
+	})
+}
+
+func TestGetTransfers(t *testing.T) {
+	logger := zap.NewNop()
+	mockStorage := NewMockTransferStorage()
+	handler := NewTransferHandler(logger, mockStorage)
+
+	_, err := mockStorage.Transfer(1, "bob", money.FromPoints(30))
+	require.NoError(t, err)
+
+	get := func(userID int) *httptest.ResponseRecorder {
+		router := gin.New()
+		router.GET("/api/user/transfers", func(c *gin.Context) {
+			c.Set("userID", userID)
+			handler.GetTransfers(c)
+		})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/user/transfers", nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Sender", func(t *testing.T) {
+		w := get(1)
+		require.Equal(t, http.StatusOK, w.Code)
+		var response []TransferResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.Len(t, response, 1)
+		assert.Equal(t, TransferOutgoing, response[0].Direction)
+		assert.Equal(t, "bob", response[0].Login)
+	})
+
+	t.Run("Recipient", func(t *testing.T) {
+		w := get(2)
+		require.Equal(t, http.StatusOK, w.Code)
+		var response []TransferResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		require.Len(t, response, 1)
+		assert.Equal(t, TransferIncoming, response[0].Direction)
+		assert.Equal(t, "alice", response[0].Login)
+	})
+
+	t.Run("No Transfers", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, get(3).Code)
+	})
+}
@@ -33,7 +33,7 @@ func (m *MockUserStorage) AddUser(user storage.User) (int, error) {
 func (m *MockUserStorage) GetUser(login string) (storage.User, error) {
 	user, exists := m.users[login]
 	if !exists {
-		return storage.User{}, errors.New("user not found")
+		return storage.User{}, storage.ErrUserNotFound
 	}
 	return user, nil
 }
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/storage"
+	"github.com/krasvl/market/internal/webhooks"
+	"go.uber.org/zap"
+)
+
+// WebhookRequest represents the request body for registering a webhook.
+type WebhookRequest struct {
+	URL string `json:"url" binding:"required"`
+}
+
+// WebhookResponse represents a registered webhook. Secret is only returned
+// when the webhook is created.
+type WebhookResponse struct {
+	CreatedAt time.Time `json:"created_at"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	ID        int       `json:"id"`
+}
+
+// DeliveryResponse represents an attempt to deliver an event to a webhook.
+type DeliveryResponse struct {
+	CreatedAt      time.Time       `json:"created_at"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+	Event          string          `json:"event"`
+	Status         string          `json:"status" enums:"PENDING,DELIVERED,FAILED"`
+	LastError      string          `json:"last_error,omitempty"`
+	Payload        json.RawMessage `json:"payload" swaggertype:"object"`
+	ID             int             `json:"id"`
+	Attempts       int             `json:"attempts"`
+	LastStatusCode int             `json:"last_status_code,omitempty"`
+}
+
+type WebhookHandler struct {
+	logger  *zap.Logger
+	storage storage.WebhookStorage
+}
+
+func NewWebhookHandler(logger *zap.Logger, storage storage.WebhookStorage) *WebhookHandler {
+	return &WebhookHandler{
+		logger:  logger,
+		storage: storage,
+	}
+}
+
+// AddWebhook godoc.
+// @Summary Register a webhook.
+// @Description Register a public HTTPS endpoint to receive order and withdrawal events.
+// @Description Redirects are not followed.
+// @Description Deliveries are signed: X-Gophermart-Signature is "sha256=" followed by the hex HMAC-SHA256
+// @Description of X-Gophermart-Timestamp, "." and the body, keyed with the returned secret.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Param webhook body WebhookRequest true "Webhook".
+// @Success 201 {object} WebhookResponse
+// @Failure 400 {string} string "Invalid request".
+// @Failure 401 {string} string "Unauthorized".
+// @Failure 422 {string} string "Invalid webhook URL".
+// @Failure 500 {string} string "Internal server error".
+// @Security BearerAuth
+// @Router /api/user/webhooks [post].
+func (h *WebhookHandler) AddWebhook(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if !isValidWebhookURL(req.URL) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid webhook URL"})
+		return
+	}
+
+	webhook, err := h.storage.AddWebhook(userID, req.URL)
+	if err != nil {
+		h.logger.Error("failed to add webhook", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	response := newWebhookResponse(webhook)
+	response.Secret = webhook.Secret
+	c.JSON(http.StatusCreated, response)
+}
+
+// GetWebhooks godoc.
+// @Summary Get list of webhooks.
+// @Description Get the webhooks registered by the user.
+// @Tags webhooks
+// @Produce json
+// @Success 200 {array} WebhookResponse
+// @Failure 204 {string} string "No content".
+// @Failure 401 {string} string "Unauthorized".
+// @Failure 500 {string} string "Internal server error".
+// @Security BearerAuth
+// @Router /api/user/webhooks [get].
+func (h *WebhookHandler) GetWebhooks(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	webhooks, err := h.storage.GetWebhooks(userID)
+	if err != nil {
+		h.logger.Error("failed to get webhooks", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if len(webhooks) == 0 {
+		c.JSON(http.StatusNoContent, nil)
+		return
+	}
+
+	var response = make([]WebhookResponse, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		response = append(response, newWebhookResponse(webhook))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// DeleteWebhook godoc.
+// @Summary Delete a webhook.
+// @Description Delete the webhook and drop its pending deliveries.
+// @Tags webhooks
+// @Param id path int true "Webhook ID".
+// @Success 204 {string} string "Deleted".
+// @Failure 400 {string} string "Invalid request".
+// @Failure 401 {string} string "Unauthorized".
+// @Failure 404 {string} string "Webhook not found".
+// @Failure 500 {string} string "Internal server error".
+// @Security BearerAuth
+// @Router /api/user/webhooks/{id} [delete].
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	webhookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	err = h.storage.DeleteWebhook(userID, webhookID)
+	switch {
+	case errors.Is(err, storage.ErrWebhookNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	case err != nil:
+		h.logger.Error("failed to delete webhook", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetDeliveries godoc.
+// @Summary Get webhook delivery log.
+// @Description Get the latest deliveries of the webhook, newest first.
+// @Tags webhooks
+// @Produce json
+// @Param id path int true "Webhook ID".
+// @Success 200 {array} DeliveryResponse
+// @Failure 204 {string} string "No content".
+// @Failure 400 {string} string "Invalid request".
+// @Failure 401 {string} string "Unauthorized".
+// @Failure 404 {string} string "Webhook not found".
+// @Failure 500 {string} string "Internal server error".
+// @Security BearerAuth
+// @Router /api/user/webhooks/{id}/deliveries [get].
+func (h *WebhookHandler) GetDeliveries(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	webhookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	deliveries, err := h.storage.GetDeliveries(userID, webhookID)
+	switch {
+	case errors.Is(err, storage.ErrWebhookNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	case err != nil:
+		h.logger.Error("failed to get webhook deliveries", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	if len(deliveries) == 0 {
+		c.JSON(http.StatusNoContent, nil)
+		return
+	}
+
+	var response = make([]DeliveryResponse, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		item := DeliveryResponse{
+			ID:             delivery.ID,
+			Event:          delivery.Event,
+			Status:         string(delivery.Status),
+			Attempts:       delivery.Attempts,
+			LastStatusCode: delivery.LastStatusCode,
+			LastError:      delivery.LastError,
+			Payload:        delivery.Payload,
+			CreatedAt:      delivery.CreatedAt,
+		}
+		if delivery.Status == storage.DeliveryPending {
+			item.NextAttemptAt = &delivery.NextAttemptAt
+		}
+		if !delivery.DeliveredAt.IsZero() {
+			item.DeliveredAt = &delivery.DeliveredAt
+		}
+		response = append(response, item)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// isValidWebhookURL only accepts absolute HTTPS URLs, so event payloads and
+// signatures never travel in the clear. Hosts that are obviously internal are
+// rejected right away, the dispatcher checks resolved names when sending.
+func isValidWebhookURL(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if u.Scheme != "https" || host == "" || u.User != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil && !webhooks.IsPublicIP(ip) {
+		return false
+	}
+	return true
+}
+
+func newWebhookResponse(webhook storage.Webhook) WebhookResponse {
+	return WebhookResponse{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		CreatedAt: webhook.CreatedAt,
+	}
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBodySize limits the body buffered to hash the request.
+	maxIdempotentBodySize = 1 << 20
+)
+
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency makes retried requests with the same Idempotency-Key header
+// replay the first response instead of running the handler again. It must be
+// installed after AuthMiddleware, since keys are scoped to the user. A request
+// that has not finished within lease is presumed dead and its key may be
+// reserved again.
+func Idempotency(keys storage.IdempotencyStorage, ttl, lease time.Duration, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid idempotency key"})
+			c.Abort()
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBodySize)
+		body, err := c.GetRawData()
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request too large"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		userID := c.GetInt("userID")
+		hash := requestHash(c.Request.Method, c.FullPath(), body)
+
+		record, reserved, err := keys.ReserveKey(userID, key, hash, ttl, lease)
+		if err != nil {
+			logger.Error("failed to reserve idempotency key", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			c.Abort()
+			return
+		}
+
+		if !reserved {
+			switch {
+			case record.RequestHash != hash:
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency key reused with a different request"})
+			case record.Status == 0:
+				c.JSON(http.StatusConflict, gin.H{"error": "Request with this idempotency key is in progress"})
+			default:
+				c.Header(IdempotencyReplayedHeader, "true")
+				c.Data(record.Status, record.ContentType, record.Body)
+			}
+			c.Abort()
+			return
+		}
+
+		// The reservation may be taken over while the handler runs past the
+		// lease, the new owner's record is then left alone.
+		reservedAt := record.CreatedAt
+		report := func(msg string, err error) {
+			if errors.Is(err, storage.ErrReservationLost) {
+				logger.Warn("idempotency key reserved again while the request ran", zap.String("key", key))
+				return
+			}
+			logger.Error(msg, zap.Error(err))
+		}
+		release := func() {
+			if err := keys.ReleaseKey(userID, key, reservedAt); err != nil {
+				report("failed to release idempotency key", err)
+			}
+		}
+		// A panicking handler never answered, so the client may retry.
+		defer func() {
+			if p := recover(); p != nil {
+				release()
+				panic(p)
+			}
+		}()
+
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		// Server errors are not cached, so the client may retry them.
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			release()
+			return
+		}
+		contentType := recorder.Header().Get("Content-Type")
+		if err := keys.SaveResponse(userID, key, reservedAt, status, contentType, recorder.body.Bytes()); err != nil {
+			report("failed to save idempotent response", err)
+		}
+	}
+}
+
+func requestHash(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/krasvl/market/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type MockIdempotencyStorage struct {
+	records map[string]storage.IdempotencyRecord
+}
+
+func NewMockIdempotencyStorage() *MockIdempotencyStorage {
+	return &MockIdempotencyStorage{records: make(map[string]storage.IdempotencyRecord)}
+}
+
+func (m *MockIdempotencyStorage) ReserveKey(
+	userID int,
+	key, requestHash string,
+	ttl, lease time.Duration,
+) (storage.IdempotencyRecord, bool, error) {
+	record, exists := m.records[key]
+	stale := record.Status == 0 && record.CreatedAt.Add(lease).Before(time.Now())
+	if exists && record.ExpiresAt.After(time.Now()) && !stale {
+		return record, false, nil
+	}
+	record = storage.IdempotencyRecord{
+		UserID:      userID,
+		Key:         key,
+		RequestHash: requestHash,
+		CreatedAt:   time.Now(),
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+	m.records[key] = record
+	return record, true, nil
+}
+
+func (m *MockIdempotencyStorage) SaveResponse(
+	_ int,
+	key string,
+	reservedAt time.Time,
+	status int,
+	contentType string,
+	body []byte,
+) error {
+	record, exists := m.records[key]
+	if !exists || !record.CreatedAt.Equal(reservedAt) || record.Status != 0 {
+		return storage.ErrReservationLost
+	}
+	record.Status = status
+	record.ContentType = contentType
+	record.Body = body
+	m.records[key] = record
+	return nil
+}
+
+func (m *MockIdempotencyStorage) ReleaseKey(_ int, key string, reservedAt time.Time) error {
+	record, exists := m.records[key]
+	if !exists || !record.CreatedAt.Equal(reservedAt) || record.Status != 0 {
+		return storage.ErrReservationLost
+	}
+	delete(m.records, key)
+	return nil
+}
+
+func TestIdempotency(t *testing.T) {
+	calls := 0
+	status := http.StatusOK
+
+	keys := NewMockIdempotencyStorage()
+	router := gin.New()
+	router.Use(gin.Recovery(), Idempotency(keys, time.Hour, time.Minute, zap.NewNop()))
+	router.POST("/test", func(c *gin.Context) {
+		calls++
+		if c.GetHeader("X-Panic") != "" {
+			panic("handler failed")
+		}
+		if c.GetHeader("X-Take-Over") != "" {
+			// Another request reserves the key once the lease has passed.
+			key := c.GetHeader(IdempotencyKeyHeader)
+			record := keys.records[key]
+			record.CreatedAt = record.CreatedAt.Add(time.Minute)
+			keys.records[key] = record
+		}
+		c.JSON(status, gin.H{"call": calls})
+	})
+
+	sendWith := func(key, body string, header http.Header) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/test", bytes.NewBufferString(body))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+	send := func(key, body string) *httptest.ResponseRecorder {
+		return sendWith(key, body, nil)
+	}
+
+	t.Run("Without Key", func(t *testing.T) {
+		calls = 0
+		send("", "a")
+		send("", "a")
+
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		calls = 0
+		first := send("key-1", "a")
+		second := send("key-1", "a")
+
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, first.Code, second.Code)
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		assert.Equal(t, "true", second.Header().Get(IdempotencyReplayedHeader))
+	})
+
+	t.Run("Different Payload", func(t *testing.T) {
+		calls = 0
+		send("key-2", "a")
+		w := send("key-2", "b")
+
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("Body Too Large", func(t *testing.T) {
+		calls = 0
+		w := send("key-8", strings.Repeat("a", maxIdempotentBodySize+1))
+
+		assert.Equal(t, 0, calls)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+
+	t.Run("Server Error Not Cached", func(t *testing.T) {
+		calls = 0
+		status = http.StatusInternalServerError
+		send("key-3", "a")
+		status = http.StatusOK
+		w := send("key-3", "a")
+
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Panic Not Cached", func(t *testing.T) {
+		calls = 0
+		w := sendWith("key-4", "a", http.Header{"X-Panic": {"true"}})
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		w = send("key-4", "a")
+
+		assert.Equal(t, 2, calls)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("In Progress", func(t *testing.T) {
+		calls = 0
+		hash := requestHash(http.MethodPost, "/test", []byte("a"))
+		_, reserved, err := keys.ReserveKey(0, "key-5", hash, time.Hour, time.Minute)
+		require.NoError(t, err)
+		require.True(t, reserved)
+
+		w := send("key-5", "a")
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		// A reservation older than the lease was left by a request that died.
+		record := keys.records["key-5"]
+		record.CreatedAt = record.CreatedAt.Add(-2 * time.Minute)
+		keys.records["key-5"] = record
+		w = send("key-5", "a")
+
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Taken Over", func(t *testing.T) {
+		takeOver := http.Header{"X-Take-Over": {"true"}}
+		w := sendWith("key-6", "a", takeOver)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Zero(t, keys.records["key-6"].Status)
+
+		status = http.StatusInternalServerError
+		sendWith("key-7", "a", takeOver)
+		status = http.StatusOK
+		assert.Contains(t, keys.records, "key-7")
+	})
+}
@@ -0,0 +1,128 @@
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Amount is a number of loyalty points kept as an integer count of
+// hundredths, so sums and differences are exact.
+type Amount int64
+
+const scale = 100
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// decimalPattern is the only notation Parse accepts: no fractions such as
+// "1/3", no exponents such as "1e3".
+var decimalPattern = regexp.MustCompile(`^[+-]?\d+(\.\d+)?$`)
+
+// FromPoints returns an amount of whole points.
+func FromPoints(points int64) Amount {
+	return Amount(points * scale)
+}
+
+// Parse reads a decimal number such as "729.98". Values finer than a
+// hundredth, such as "0.005", are rejected rather than rounded; trailing zeros
+// as in "42.000" are fine.
+func Parse(s string) (Amount, error) {
+	trimmed := strings.TrimSpace(s)
+	if !decimalPattern.MatchString(trimmed) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	r, ok := new(big.Rat).SetString(trimmed)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	r.Mul(r, big.NewRat(scale, 1))
+	if !r.IsInt() {
+		return 0, fmt.Errorf("%w: %q has more than two decimal places", ErrInvalidAmount, s)
+	}
+
+	q := r.Num()
+	if !q.IsInt64() {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidAmount, s)
+	}
+	return Amount(q.Int64()), nil
+}
+
+func (a Amount) String() string {
+	sign := ""
+	v := int64(a)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	units, cents := v/scale, v%scale
+	if cents == 0 {
+		return sign + strconv.FormatInt(units, 10)
+	}
+	return strings.TrimRight(fmt.Sprintf("%s%d.%02d", sign, units, cents), "0")
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	v, err := Parse(strings.Trim(s, `"`))
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// MarshalText and UnmarshalText let amounts be used in flags and config files.
+func (a Amount) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalText(text []byte) error {
+	v, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// Value stores the amount as a NUMERIC literal.
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+func (a *Amount) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return a.scanString(string(v))
+	case string:
+		return a.scanString(v)
+	case int64:
+		*a = FromPoints(v)
+		return nil
+	case nil:
+		*a = 0
+		return nil
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidAmount, src)
+	}
+}
+
+func (a *Amount) scanString(s string) error {
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
@@ -0,0 +1,86 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	cases := map[string]Amount{
+		"0":       0,
+		"500":     50000,
+		"729.98":  72998,
+		"0.1":     10,
+		"-12.5":   -1250,
+		"1.230":   123,
+		" 42.00 ": 4200,
+	}
+	for in, want := range cases {
+		got, err := Parse(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+
+	for _, in := range []string{"abc", "", "1/3", "1e2", "1E2", "0x10", ".5", "5.", "1_000", "--1",
+		"0.005", "-0.005", "6.1725", "1.234"} {
+		_, err := Parse(in)
+		assert.ErrorIs(t, err, ErrInvalidAmount, in)
+	}
+}
+
+func TestString(t *testing.T) {
+	assert.Equal(t, "0", Amount(0).String())
+	assert.Equal(t, "500", FromPoints(500).String())
+	assert.Equal(t, "729.98", Amount(72998).String())
+	assert.Equal(t, "100.5", Amount(10050).String())
+	assert.Equal(t, "0.05", Amount(5).String())
+	assert.Equal(t, "-0.5", Amount(-50).String())
+}
+
+func TestNoDrift(t *testing.T) {
+	a, _ := Parse("0.1")
+	b, _ := Parse("0.2")
+	c, _ := Parse("0.3")
+	assert.Equal(t, c, a+b)
+	assert.Equal(t, Amount(0), a+b-c)
+}
+
+func TestJSON(t *testing.T) {
+	var v struct {
+		Sum Amount `json:"sum"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"sum": 751.1}`), &v))
+	assert.Equal(t, Amount(75110), v.Sum)
+
+	data, err := json.Marshal(v)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"sum": 751.1}`, string(data))
+
+	assert.Error(t, json.Unmarshal([]byte(`{"sum": "x"}`), &v))
+}
+
+func TestText(t *testing.T) {
+	var v Amount
+	assert.NoError(t, v.UnmarshalText([]byte("10000.5")))
+	assert.Equal(t, Amount(1000050), v)
+
+	text, err := v.MarshalText()
+	assert.NoError(t, err)
+	assert.Equal(t, "10000.5", string(text))
+
+	assert.Error(t, v.UnmarshalText([]byte("ten")))
+}
+
+func TestScan(t *testing.T) {
+	var a Amount
+	assert.NoError(t, a.Scan([]byte("12.34")))
+	assert.Equal(t, Amount(1234), a)
+	assert.NoError(t, a.Scan(int64(7)))
+	assert.Equal(t, FromPoints(7), a)
+
+	v, err := Amount(1234).Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "12.34", v)
+}
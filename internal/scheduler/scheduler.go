@@ -2,69 +2,314 @@ package scheduler
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"net/http"
-	"strconv"
+	"errors"
+	"math/rand/v2"
 	"sync"
 	"time"
 
+	"github.com/krasvl/market/internal/accrual"
+	"github.com/krasvl/market/internal/config"
 	"github.com/krasvl/market/internal/storage"
+	"github.com/krasvl/market/internal/webhooks"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 )
 
+// ErrDrainTimeout is returned by Start when in-flight work did not finish in
+// time after shutdown and had to be canceled.
+var ErrDrainTimeout = errors.New("scheduler drain timed out")
+
 type Scheduler struct {
 	logger          *zap.Logger
 	orderStorage    *storage.OrderStoragePostgres
-	accrualAddr     string
+	balanceStorage  *storage.BalanceStoragePostgres
+	idempotency     *storage.IdempotencyStoragePostgres
+	dispatcher      *webhooks.Dispatcher
+	listener        *pq.Listener
+	accrual         accrual.Client
+	limiter         *accrual.Limiter
+	breaker         *accrual.Breaker
+	workerID        string
+	statusAddr      string
 	accrualInterval time.Duration
-	intervalMu      sync.RWMutex
+	expireInterval  time.Duration
+	releaseInterval time.Duration
+	purgeInterval   time.Duration
+	webhookInterval time.Duration
+	orderLease      time.Duration
+	retryBaseDelay  time.Duration
+	retryMaxDelay   time.Duration
+	drainTimeout    time.Duration
 	workerPoolSize  int
+	claimBatchSize  int
+	maxAttempts     int
 }
 
 func NewScheduler(
 	logger *zap.Logger,
 	orderStorage *storage.OrderStoragePostgres,
-	accrualAddr string,
+	balanceStorage *storage.BalanceStoragePostgres,
+	idempotencyStorage *storage.IdempotencyStoragePostgres,
+	dispatcher *webhooks.Dispatcher,
+	listener *pq.Listener,
+	accrualClient accrual.Client,
+	limiter *accrual.Limiter,
+	breaker *accrual.Breaker,
+	workerID string,
+	cfg config.Scheduler,
 ) *Scheduler {
 	return &Scheduler{
 		logger:          logger,
 		orderStorage:    orderStorage,
-		accrualAddr:     accrualAddr,
-		accrualInterval: 10 * time.Second,
-		workerPoolSize:  5,
+		balanceStorage:  balanceStorage,
+		idempotency:     idempotencyStorage,
+		dispatcher:      dispatcher,
+		listener:        listener,
+		accrual:         accrual.NewBreakerClient(accrual.NewLimitedClient(accrualClient, limiter), breaker),
+		limiter:         limiter,
+		breaker:         breaker,
+		workerID:        workerID,
+		statusAddr:      cfg.StatusAddress,
+		accrualInterval: cfg.AccrualInterval,
+		expireInterval:  cfg.ExpireInterval,
+		releaseInterval: cfg.ReleaseInterval,
+		purgeInterval:   cfg.PurgeInterval,
+		webhookInterval: cfg.WebhookInterval,
+		orderLease:      cfg.OrderLease,
+		retryBaseDelay:  cfg.RetryBaseDelay,
+		retryMaxDelay:   cfg.RetryMaxDelay,
+		drainTimeout:    cfg.DrainTimeout,
+		workerPoolSize:  cfg.WorkerPoolSize,
+		claimBatchSize:  cfg.ClaimBatchSize,
+		maxAttempts:     cfg.MaxAttempts,
 	}
 }
 
-func (s *Scheduler) Start() {
-	ticker := time.NewTicker(s.getAccrualInterval())
+// Start checks orders as soon as they are uploaded. The ticker still sweeps
+// pending orders, so orders whose notification was lost are checked as well.
+//
+// Once ctx is done no new work is claimed. Work in flight gets drainTimeout
+// to finish and store its results, then it is canceled and ErrDrainTimeout is
+// returned. Orders left unchecked are released to other schedulers.
+func (s *Scheduler) Start(ctx context.Context) error {
+	work, cancelWork := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer cancelWork(nil)
+	stopDrain := context.AfterFunc(ctx, func() {
+		s.logger.Info("scheduler stopping, draining in-flight work", zap.Duration("timeout", s.drainTimeout))
+		time.AfterFunc(s.drainTimeout, func() { cancelWork(ErrDrainTimeout) })
+	})
+	defer stopDrain()
+
+	if s.statusAddr != "" {
+		go s.serveStatus(ctx)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.runWebhooks(ctx, work)
+	}()
+
+	s.run(ctx, work)
+	wg.Wait()
+
+	if released, err := s.orderStorage.ReleaseOrders(s.workerID); err != nil {
+		s.logger.Error("failed to release orders", zap.Error(err))
+	} else if released > 0 {
+		s.logger.Info("orders released", zap.Int("count", released))
+	}
+
+	if err := context.Cause(work); errors.Is(err, ErrDrainTimeout) {
+		return err
+	}
+	s.logger.Info("scheduler stopped")
+	return nil
+}
+
+// run does the work until ctx is done. Requests to the accrual system use
+// work, which outlives ctx while draining, and so do webhook deliveries.
+func (s *Scheduler) run(ctx, work context.Context) {
+	ticker := time.NewTicker(s.accrualInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		s.checkOrders()
-		ticker.Reset(s.getAccrualInterval())
+	expireTicker := time.NewTicker(s.expireInterval)
+	defer expireTicker.Stop()
+
+	releaseTicker := time.NewTicker(s.releaseInterval)
+	defer releaseTicker.Stop()
+
+	purgeTicker := time.NewTicker(s.purgeInterval)
+	defer purgeTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.pingListener()
+			s.checkOrders(ctx, work)
+		case <-s.listener.NotificationChannel():
+			// A nil notification means the listener reconnected and may have
+			// missed some, which the check below covers as well.
+			s.drainNotifications()
+			s.checkOrders(ctx, work)
+			ticker.Reset(s.accrualInterval)
+		case <-expireTicker.C:
+			s.expirePoints(ctx)
+		case <-releaseTicker.C:
+			s.releaseExpiredHolds(ctx)
+		case <-purgeTicker.C:
+			s.purgeIdempotencyKeys(ctx)
+		}
+	}
+}
+
+// runWebhooks sends webhook deliveries until ctx is done. It runs apart from
+// the other work, so slow webhook endpoints never hold up order checks, points
+// expiry or the release of holds.
+func (s *Scheduler) runWebhooks(ctx, work context.Context) {
+	ticker := time.NewTicker(s.webhookInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.deliverWebhooks(ctx, work)
+		}
+	}
+}
+
+// Close closes the order listener.
+func (s *Scheduler) Close() error {
+	return s.listener.Close()
+}
+
+// drainNotifications drops notifications queued while orders were being
+// checked, a single check picks up all of them.
+func (s *Scheduler) drainNotifications() {
+	for {
+		select {
+		case <-s.listener.NotificationChannel():
+		default:
+			return
+		}
+	}
+}
+
+// pingListener makes the listener notice a dead connection and reconnect,
+// which it would not do on its own while no notifications arrive.
+func (s *Scheduler) pingListener() {
+	if err := s.listener.Ping(); err != nil {
+		s.logger.Warn("listener ping failed", zap.Error(err))
+	}
+}
+
+// expirePoints writes off expired points batch by batch until none are left.
+func (s *Scheduler) expirePoints(ctx context.Context) {
+	for ctx.Err() == nil {
+		expired, err := s.balanceStorage.ExpirePoints()
+		if err != nil {
+			s.logger.Error("failed to expire points", zap.Error(err))
+			return
+		}
+		if expired == 0 {
+			return
+		}
+		s.logger.Info("points expired", zap.String("sum", expired.String()))
+	}
+}
+
+// releaseExpiredHolds returns points held for payments that never completed.
+func (s *Scheduler) releaseExpiredHolds(ctx context.Context) {
+	for ctx.Err() == nil {
+		released, err := s.balanceStorage.ReleaseExpiredHolds()
+		if err != nil {
+			s.logger.Error("failed to release expired holds", zap.Error(err))
+			return
+		}
+		if released == 0 {
+			return
+		}
+		s.logger.Info("expired holds released", zap.Int("count", released))
+	}
+}
+
+// purgeIdempotencyKeys deletes expired idempotency keys batch by batch until
+// none are left.
+func (s *Scheduler) purgeIdempotencyKeys(ctx context.Context) {
+	for ctx.Err() == nil {
+		purged, err := s.idempotency.PurgeExpiredKeys()
+		if err != nil {
+			s.logger.Error("failed to purge idempotency keys", zap.Error(err))
+			return
+		}
+		if purged == 0 {
+			return
+		}
+		s.logger.Info("idempotency keys purged", zap.Int("count", purged))
+	}
+}
+
+// deliverWebhooks sends due webhook deliveries batch by batch until none are left.
+func (s *Scheduler) deliverWebhooks(ctx, work context.Context) {
+	for ctx.Err() == nil {
+		delivered, err := s.dispatcher.Deliver(work)
+		if err != nil {
+			s.logger.Error("failed to deliver webhooks", zap.Error(err))
+			return
+		}
+		if delivered == 0 {
+			return
+		}
+		s.logger.Info("webhooks sent", zap.Int("count", delivered))
 	}
 }
 
-func (s *Scheduler) checkOrders() {
-	orders, err := s.orderStorage.GetPendingOrders()
-	if err != nil {
-		s.logger.Error("failed to get pending orders", zap.Error(err))
+// checkOrders claims pending orders batch by batch until none are left or
+// the accrual system asks to slow down or is down, or ctx is done.
+func (s *Scheduler) checkOrders(ctx, work context.Context) {
+	if s.limiter.Paused() || s.breaker.State() == accrual.BreakerOpen {
 		return
 	}
 
+	for ctx.Err() == nil {
+		orders, err := s.orderStorage.ClaimPendingOrders(s.workerID, s.claimBatchSize, s.orderLease)
+		if err != nil {
+			s.logger.Error("failed to claim pending orders", zap.Error(err))
+			return
+		}
+
+		if busy := s.checkBatch(ctx, work, orders); busy || len(orders) < s.claimBatchSize {
+			return
+		}
+	}
+}
+
+// checkBatch checks the orders and reports whether the accrual system was
+// busy. Once ctx is done workers take no more orders, but the checks already
+// sent are finished and stored. Orders left unchecked keep their lease until
+// it expires or is released.
+func (s *Scheduler) checkBatch(ctx, work context.Context, orders []storage.Order) bool {
 	if len(orders) == 0 {
-		return
+		return false
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	batch, cancel := context.WithCancel(work)
 	defer cancel()
 
 	jobs := make(chan storage.Order, len(orders))
 	results := make(chan storage.Order, len(orders))
 
+	var wg sync.WaitGroup
 	for range s.workerPoolSize {
-		go s.worker(ctx, cancel, jobs, results)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.worker(ctx, batch, cancel, jobs, results)
+		}()
 	}
 
 	for _, order := range orders {
@@ -72,8 +317,20 @@ func (s *Scheduler) checkOrders() {
 	}
 	close(jobs)
 
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
 	for order := range results {
-		if err := s.orderStorage.ProcessOrder(&order); err != nil {
+		err := s.orderStorage.ProcessOrder(&order)
+		switch {
+		case errors.Is(err, storage.ErrOrderFinal):
+			s.logger.Info("order already processed",
+				zap.String("order", order.Number),
+			)
+			continue
+		case err != nil:
 			s.logger.Error("failed to update order status",
 				zap.String("order", order.Number),
 				zap.Error(err),
@@ -85,10 +342,12 @@ func (s *Scheduler) checkOrders() {
 			zap.String("status", string(order.Status)),
 		)
 	}
+
+	return batch.Err() != nil
 }
 
 func (s *Scheduler) worker(
-	ctx context.Context,
+	ctx, batch context.Context,
 	cancel context.CancelFunc,
 	jobs <-chan storage.Order,
 	results chan<- storage.Order,
@@ -97,118 +356,110 @@ func (s *Scheduler) worker(
 		select {
 		case <-ctx.Done():
 			return
+		case <-batch.Done():
+			return
 		default:
-			result, processedOrder := s.checkOrder(ctx, &order)
+			result, processedOrder := s.checkOrder(batch, &order)
 			switch result.status {
 			case Success:
 				results <- *processedOrder
 			case Busy:
-				s.logger.Warn("accrual system busy, retrying after timeout",
-					zap.Int("timeout_sec", result.timeout),
-				)
-				s.setAccrualInterval(time.Duration(result.timeout) * time.Second)
+				// The limiter or the breaker hold everyone back until the
+				// accrual system is ready again, orders left in the batch
+				// wait for their lease to expire.
 				cancel()
 				return
+			case Canceled:
+				return
 			case Fail:
 				s.logger.Error("failed to check order status",
 					zap.String("order", order.Number),
 				)
+				s.retryOrder(order)
 			}
 		}
 	}
 }
 
+// retryOrder postpones the next check of an order that failed, or sets the
+// order aside once it is out of attempts.
+func (s *Scheduler) retryOrder(order storage.Order) {
+	attempts := order.Attempts + 1
+	if attempts >= s.maxAttempts {
+		if err := s.orderStorage.MarkOrderStuck(order.ID); err != nil {
+			s.logger.Error("failed to mark order stuck", zap.String("order", order.Number), zap.Error(err))
+			return
+		}
+		s.logger.Warn("order is stuck", zap.String("order", order.Number), zap.Int("attempts", attempts))
+		return
+	}
+
+	delay := retryDelay(attempts, s.retryBaseDelay, s.retryMaxDelay)
+	if err := s.orderStorage.RetryOrder(order.ID, delay); err != nil {
+		s.logger.Error("failed to postpone order check", zap.String("order", order.Number), zap.Error(err))
+	}
+}
+
+// retryDelay doubles with every failed attempt up to maxDelay. Only half of
+// it is fixed, the rest is random, so orders that failed together are not
+// retried together.
+func retryDelay(attempts int, baseDelay, maxDelay time.Duration) time.Duration {
+	delay := maxDelay
+	if shift := attempts - 1; shift < 30 {
+		delay = min(baseDelay<<shift, maxDelay)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// Requeue puts stuck orders back in the queue, all of them if numbers is
+// empty, and returns how many were requeued.
+func (s *Scheduler) Requeue(numbers []string) (int, error) {
+	return s.orderStorage.RequeueStuckOrders(numbers)
+}
+
 type checkResult struct {
-	status  checkStatus
-	timeout int
+	status checkStatus
 }
 
 type checkStatus string
 
 const (
-	Success checkStatus = "SUCCESS"
-	Fail    checkStatus = "FAIL"
-	Busy    checkStatus = "BUSY"
+	Success  checkStatus = "SUCCESS"
+	Fail     checkStatus = "FAIL"
+	Busy     checkStatus = "BUSY"
+	Canceled checkStatus = "CANCELED"
 )
 
 func (s *Scheduler) checkOrder(
 	ctx context.Context,
 	order *storage.Order,
 ) (checkResult, *storage.Order) {
-	url := fmt.Sprintf("%s/api/orders/%s", s.accrualAddr, order.Number)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
-	if err != nil {
-		s.logger.Error("failed to create request",
-			zap.String("order", order.Number),
-			zap.Error(err),
-		)
-		return checkResult{status: Fail}, nil
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		s.logger.Error("failed to get order status",
-			zap.String("order", order.Number),
-			zap.Error(err),
-		)
-		return checkResult{status: Fail}, nil
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			s.logger.Error("failed to close response body")
+	result, err := s.accrual.GetOrder(ctx, order.Number)
+	// An open breaker or a canceled context means no request was sent.
+	if !errors.Is(err, accrual.ErrCircuitOpen) && ctx.Err() == nil {
+		if err := s.orderStorage.RecordPoll(order.ID); err != nil {
+			s.logger.Warn("failed to record poll", zap.String("order", order.Number), zap.Error(err))
 		}
-	}()
-
-	switch resp.StatusCode {
-	case http.StatusOK:
-		var result struct {
-			Order   string              `json:"order"`
-			Status  storage.OrderStatus `json:"status"`
-			Accrual float64             `json:"accrual,omitempty"`
-		}
-
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			s.logger.Error("failed to decode response",
-				zap.String("order", order.Number),
-				zap.Error(err),
-			)
-			return checkResult{status: Fail}, nil
-		}
-
-		order.Status = result.Status
-		order.Accrual = result.Accrual
-		return checkResult{status: Success}, order
+	}
 
-	case http.StatusNoContent:
+	var rateLimit *accrual.RateLimitError
+	switch {
+	case errors.Is(err, accrual.ErrOrderNotRegistered):
 		order.Status = storage.StatusInvalid
 		return checkResult{status: Success}, order
-
-	case http.StatusTooManyRequests:
-		timeout := 60
-		if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
-			if sec, err := strconv.Atoi(retryAfter); err == nil {
-				timeout = sec
-			}
-		}
-		return checkResult{status: Busy, timeout: timeout}, nil
-
-	default:
-		s.logger.Error("unexpected status from accrual system",
+	case errors.As(err, &rateLimit), errors.Is(err, accrual.ErrCircuitOpen):
+		return checkResult{status: Busy}, nil
+	case ctx.Err() != nil:
+		return checkResult{status: Canceled}, nil
+	case err != nil:
+		s.logger.Error("failed to get order status",
 			zap.String("order", order.Number),
-			zap.Int("status_code", resp.StatusCode),
+			zap.Error(err),
 		)
 		return checkResult{status: Fail}, nil
 	}
-}
-
-func (s *Scheduler) getAccrualInterval() time.Duration {
-	s.intervalMu.RLock()
-	defer s.intervalMu.RUnlock()
-	return s.accrualInterval
-}
 
-func (s *Scheduler) setAccrualInterval(newInterval time.Duration) {
-	s.intervalMu.Lock()
-	defer s.intervalMu.Unlock()
-	s.accrualInterval = newInterval
+	order.Status = result.Status
+	order.Accrual = result.Accrual
+	return checkResult{status: Success}, order
 }
@@ -1,51 +1,74 @@
 package scheduler
 
 import (
-	"flag"
+	"database/sql"
 	"fmt"
 	"os"
-	"strings"
 
+	"github.com/krasvl/market/internal/accrual"
+	"github.com/krasvl/market/internal/config"
 	"github.com/krasvl/market/internal/storage"
+	"github.com/krasvl/market/internal/webhooks"
 	"go.uber.org/zap"
 )
 
-func GetConfiguredScheduler(databaseDefault, accrualAddrDefault string) (*Scheduler, error) {
-	database := flag.String("d", databaseDefault, "database-dsn")
-	accrualAddr := flag.String("r", accrualAddrDefault, "acccural-address")
-
-	flag.Parse()
-
-	if value, ok := os.LookupEnv("DATABASE_URI"); ok && value != "" {
-		database = &value
+// GetConfiguredScheduler creates a scheduler working on db, which is opened
+// from database. The caller closes db after the scheduler.
+func GetConfiguredScheduler(
+	cfg config.Scheduler, database string, db *sql.DB, logger *zap.Logger,
+) (*Scheduler, error) {
+	orderStorage, err := storage.NewOrderStorage(db, logger, cfg.PointsLifetime, cfg.PollInterval)
+	if err != nil {
+		return nil, fmt.Errorf("cant create order storage: %w", err)
 	}
-	if value, ok := os.LookupEnv("ACCRUAL_SYSTEM_ADDRESS"); ok && value != "" {
-		accrualAddr = &value
+
+	// The scheduler never transfers points, so the transfer limit does not matter here.
+	balanceStorage, err := storage.NewBalanceStorage(db, logger, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cant create balance storage: %w", err)
 	}
 
-	if !strings.HasPrefix(*accrualAddr, "http://") && !strings.HasPrefix(*accrualAddr, "https://") {
-		*accrualAddr = "http://" + *accrualAddr
+	idempotencyStorage, err := storage.NewIdempotencyStorage(db, logger)
+	if err != nil {
+		return nil, fmt.Errorf("cant create idempotency storage: %w", err)
 	}
 
-	logger, err := zap.NewProduction()
+	webhookStorage, err := storage.NewWebhookStorage(db, logger)
 	if err != nil {
-		return nil, fmt.Errorf("cant create logger: %w", err)
+		return nil, fmt.Errorf("cant create webhook storage: %w", err)
 	}
+	dispatcher := webhooks.NewDispatcher(
+		logger, webhookStorage, webhooks.NewClient(cfg.WebhookTimeout), cfg.WebhookWorkers,
+	)
 
-	db, err := storage.NewDB(*database)
+	hostname, err := os.Hostname()
 	if err != nil {
-		return nil, fmt.Errorf("cant open database: %w", err)
+		return nil, fmt.Errorf("cant get hostname: %w", err)
 	}
+	workerID := fmt.Sprintf("%s-%d", hostname, os.Getpid())
 
-	orderStorage, err := storage.NewOrderStorage(db, logger)
+	listener, err := storage.NewListener(database, storage.NewOrdersChannel, logger)
 	if err != nil {
-		return nil, fmt.Errorf("cant create order storage: %w", err)
+		return nil, fmt.Errorf("cant create order listener: %w", err)
 	}
 
+	accrualClient := accrual.NewClient(cfg.AccrualAddress, cfg.AccrualTimeout, cfg.AccrualIdleTimeout)
+	limiter := accrual.NewLimiter(logger, cfg.AccrualRateLimit, 1)
+	breaker := accrual.NewBreaker(logger, cfg.BreakerThreshold, cfg.BreakerTimeout, cfg.BreakerProbes)
+
 	logger.Info("scheduler created:",
-		zap.String("accural", *accrualAddr),
-		zap.String("database", *database),
+		zap.String("accural", cfg.AccrualAddress),
+		zap.Duration("accrual_timeout", cfg.AccrualTimeout),
+		zap.Int("accrual_rate_limit", cfg.AccrualRateLimit),
+		zap.Int("accrual_breaker_threshold", cfg.BreakerThreshold),
+		zap.String("status_address", cfg.StatusAddress),
+		zap.Duration("drain_timeout", cfg.DrainTimeout),
+		zap.Int("points_lifetime_months", cfg.PointsLifetime),
+		zap.String("worker_id", workerID),
 	)
 
-	return NewScheduler(logger, orderStorage, *accrualAddr), nil
+	return NewScheduler(
+		logger, orderStorage, balanceStorage, idempotencyStorage, dispatcher, listener,
+		accrualClient, limiter, breaker, workerID, cfg,
+	), nil
 }
@@ -0,0 +1,65 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/krasvl/market/internal/accrual"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		want     time.Duration
+	}{
+		{name: "First Retry", attempts: 1, want: 10 * time.Second},
+		{name: "Doubles", attempts: 4, want: 80 * time.Second},
+		{name: "Capped", attempts: 12, want: time.Hour},
+		{name: "No Overflow", attempts: 100, want: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 100 {
+				delay := retryDelay(tt.attempts, 10*time.Second, time.Hour)
+				assert.GreaterOrEqual(t, delay, tt.want/2)
+				assert.LessOrEqual(t, delay, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	limiter := accrual.NewLimiter(zap.NewNop(), 60, 1)
+	limiter.Pause(time.Minute, 10)
+	s := &Scheduler{
+		logger:   zap.NewNop(),
+		limiter:  limiter,
+		breaker:  accrual.NewBreaker(zap.NewNop(), 5, 30*time.Second, 1),
+		workerID: "scheduler-1",
+	}
+
+	w := httptest.NewRecorder()
+	s.statusHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", http.NoBody))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var status Status
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, "scheduler-1", status.WorkerID)
+	assert.Equal(t, accrual.BreakerClosed, status.Breaker.State)
+	assert.True(t, status.Limiter.Paused)
+	assert.NotNil(t, status.Limiter.PausedUntil)
+	assert.InDelta(t, 10, status.Limiter.RatePerMinute, 0.001)
+
+	w = httptest.NewRecorder()
+	s.statusHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/vars", http.NoBody))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "accrual_breaker")
+}
@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"time"
+
+	"github.com/krasvl/market/internal/accrual"
+	"go.uber.org/zap"
+)
+
+// Status tells on-call how the scheduler sees the accrual system.
+type Status struct {
+	Breaker  accrual.BreakerStatus `json:"breaker"`
+	WorkerID string                `json:"worker_id"`
+	Limiter  accrual.LimiterStatus `json:"limiter"`
+}
+
+// statusHandler serves the status at /status and the metrics at /debug/vars.
+func (s *Scheduler) statusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(Status{
+			WorkerID: s.workerID,
+			Breaker:  s.breaker.Status(),
+			Limiter:  s.limiter.Status(),
+		})
+		if err != nil {
+			s.logger.Error("failed to write status", zap.Error(err))
+		}
+	})
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	return mux
+}
+
+// serveStatus serves the status endpoint until ctx is done.
+func (s *Scheduler) serveStatus(ctx context.Context) {
+	server := &http.Server{
+		Addr:              s.statusAddr,
+		Handler:           s.statusHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	stop := context.AfterFunc(ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			s.logger.Error("failed to stop status endpoint", zap.Error(err))
+		}
+	})
+	defer stop()
+
+	s.logger.Info("status endpoint started", zap.String("address", s.statusAddr))
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.logger.Error("status endpoint stopped", zap.Error(err))
+	}
+}
@@ -1,43 +1,82 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
 	"github.com/gin-gonic/gin"
 	_ "github.com/krasvl/market/docs"
+	"github.com/krasvl/market/internal/config"
+	"github.com/krasvl/market/internal/events"
 	"github.com/krasvl/market/internal/handlers"
 	"github.com/krasvl/market/internal/middleware"
+	"github.com/krasvl/market/internal/scheduler"
 	"github.com/krasvl/market/internal/storage"
+	"github.com/lib/pq"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 	"go.uber.org/zap"
 )
 
 type Server struct {
-	userHandler    *handlers.UserHandler
-	orderHandler   *handlers.OrderHandler
-	balanceHandler *handlers.BalanceHandler
-	logger         *zap.Logger
-	addr           string
-	secret         string
+	userHandler      *handlers.UserHandler
+	orderHandler     *handlers.OrderHandler
+	balanceHandler   *handlers.BalanceHandler
+	holdHandler      *handlers.HoldHandler
+	transferHandler  *handlers.TransferHandler
+	statementHandler *handlers.StatementHandler
+	eventsHandler    *handlers.EventsHandler
+	webhookHandler   *handlers.WebhookHandler
+	serviceHandler   *handlers.ServiceHandler
+	healthHandler    *handlers.HealthHandler
+	scheduler        *scheduler.Scheduler
+	idempotency      storage.IdempotencyStorage
+	broker           *events.Broker
+	listener         *pq.Listener
+	logger           *zap.Logger
+	cfg              config.Server
 }
 
 func NewServer(
-	addr string,
+	cfg config.Server,
 	userStorage *storage.UserStoragePostgres,
 	orderStorage *storage.OrderStoragePostgres,
 	balanceStorage *storage.BalanceStoragePostgres,
+	idempotencyStorage *storage.IdempotencyStoragePostgres,
+	webhookStorage *storage.WebhookStoragePostgres,
+	healthStorage *storage.HealthStoragePostgres,
+	broker *events.Broker,
+	listener *pq.Listener,
 	logger *zap.Logger,
-	secret string,
 ) *Server {
-	userHandler := handlers.NewUserHandler(logger, userStorage, secret)
-	orderHandler := handlers.NewOrderHandler(logger, orderStorage, secret)
-	balanceHandler := handlers.NewBalanceHandler(logger, balanceStorage, secret)
+	userHandler := handlers.NewUserHandler(logger, userStorage, cfg.Secret)
+	orderHandler := handlers.NewOrderHandler(logger, orderStorage, cfg.Secret)
+	balanceHandler := handlers.NewBalanceHandler(logger, balanceStorage, cfg.Secret)
+	holdHandler := handlers.NewHoldHandler(logger, balanceStorage, cfg.HoldTTL)
+	transferHandler := handlers.NewTransferHandler(logger, balanceStorage)
+	statementHandler := handlers.NewStatementHandler(logger, balanceStorage)
+	eventsHandler := handlers.NewEventsHandler(logger, broker, cfg.EventsHeartbeat)
+	webhookHandler := handlers.NewWebhookHandler(logger, webhookStorage)
+	serviceHandler := handlers.NewServiceHandler(logger, userStorage, balanceStorage, balanceStorage, cfg.HoldTTL)
+	healthHandler := handlers.NewHealthHandler(logger, healthStorage)
 	return &Server{
-		addr:           addr,
-		userHandler:    userHandler,
-		orderHandler:   orderHandler,
-		balanceHandler: balanceHandler,
-		logger:         logger,
-		secret:         secret,
+		userHandler:      userHandler,
+		orderHandler:     orderHandler,
+		balanceHandler:   balanceHandler,
+		holdHandler:      holdHandler,
+		transferHandler:  transferHandler,
+		statementHandler: statementHandler,
+		eventsHandler:    eventsHandler,
+		webhookHandler:   webhookHandler,
+		serviceHandler:   serviceHandler,
+		healthHandler:    healthHandler,
+		idempotency:      idempotencyStorage,
+		broker:           broker,
+		listener:         listener,
+		logger:           logger,
+		cfg:              cfg,
 	}
 }
 
@@ -50,28 +89,134 @@ func NewServer(
 // @securityDefinitions.apikey BearerAuth.
 // @in header.
 // @name Authorization.
-func (s *Server) Start() {
+// @securityDefinitions.apikey ServiceAuth.
+// @in header.
+// @name Authorization.
+//
+// Start serves requests until ctx is done. Then the readiness probe fails,
+// event streams are ended and requests in flight get the shutdown timeout to
+// finish. An embedded scheduler runs alongside and stops with the server.
+func (s *Server) Start(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	schedulerErr := make(chan error, 1)
+	if s.scheduler != nil {
+		go func() {
+			schedulerErr <- s.scheduler.Start(ctx)
+		}()
+	} else {
+		close(schedulerErr)
+	}
+
+	err := s.serve(ctx)
+	// The scheduler drains while the server shuts down, or is stopped if the
+	// server could not start.
+	cancel()
+	return errors.Join(err, <-schedulerErr)
+}
+
+func (s *Server) serve(ctx context.Context) error {
+	go s.broker.Run(ctx, s.listener.NotificationChannel())
+
+	server := &http.Server{
+		Addr:              s.cfg.Address,
+		Handler:           s.router(),
+		ReadHeaderTimeout: s.cfg.ReadTimeout,
+		ReadTimeout:       s.cfg.ReadTimeout,
+		WriteTimeout:      s.cfg.WriteTimeout,
+		IdleTimeout:       s.cfg.IdleTimeout,
+	}
+	server.RegisterOnShutdown(s.broker.Close)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	s.logger.Info("server started", zap.String("address", s.cfg.Address))
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("cant start server: %w", err)
+	case <-ctx.Done():
+	}
+
+	s.logger.Info("server stopping", zap.Duration("timeout", s.cfg.ShutdownTimeout))
+	s.healthHandler.Drain()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("cant stop server: %w", err)
+	}
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("server stopped: %w", err)
+	}
+	s.logger.Info("server stopped")
+	return nil
+}
+
+// Close closes the event listener and the embedded scheduler. The database is
+// closed by the caller, which opened it.
+func (s *Server) Close() error {
+	var err error
+	if s.scheduler != nil {
+		err = s.scheduler.Close()
+	}
+	return errors.Join(err, s.listener.Close())
+}
+
+func (s *Server) router() *gin.Engine {
 	r := gin.Default()
 
 	// Serve Swagger documentation.
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+	// Probes stay out of the request log, they would drown everything else.
+	r.GET("/healthz", s.healthHandler.Live)
+	r.GET("/readyz", s.healthHandler.Ready)
+
 	r.Use(middleware.WithLogging(s.logger))
 
 	r.POST("/api/user/register", s.userHandler.RegisterUser)
 	r.POST("/api/user/login", s.userHandler.LoginUser)
 
 	auth := r.Group("/")
-	auth.Use(middleware.AuthMiddleware(s.secret))
+	auth.Use(middleware.AuthMiddleware(s.cfg.Secret))
+	idempotent := middleware.Idempotency(s.idempotency, s.cfg.IdempotencyTTL, s.cfg.IdempotencyLease, s.logger)
 	{
-		auth.POST("/api/user/orders", s.orderHandler.AddOrder)
+		auth.POST("/api/user/orders", idempotent, s.orderHandler.AddOrder)
+		auth.POST("/api/user/orders/batch", idempotent, s.orderHandler.AddOrders)
 		auth.GET("/api/user/orders", s.orderHandler.GetOrders)
+		auth.GET("/api/user/orders/:number", s.orderHandler.GetOrder)
 		auth.GET("/api/user/balance", s.balanceHandler.GetBalance)
-		auth.POST("/api/user/balance/withdraw", s.balanceHandler.Withdraw)
+		auth.POST("/api/user/balance/withdraw", idempotent, s.balanceHandler.Withdraw)
 		auth.GET("/api/user/withdrawals", s.balanceHandler.GetWithdrawals)
+		auth.GET("/api/user/statement", s.statementHandler.GetStatement)
+		auth.GET("/api/user/events", s.eventsHandler.Stream)
+		auth.POST("/api/user/balance/holds", idempotent, s.holdHandler.PlaceHold)
+		auth.POST("/api/user/balance/holds/:id/capture", s.holdHandler.CaptureHold)
+		auth.POST("/api/user/balance/holds/:id/release", s.holdHandler.ReleaseHold)
+		auth.POST("/api/user/balance/transfer", idempotent, s.transferHandler.Transfer)
+		auth.GET("/api/user/transfers", s.transferHandler.GetTransfers)
+		auth.POST("/api/user/webhooks", s.webhookHandler.AddWebhook)
+		auth.GET("/api/user/webhooks", s.webhookHandler.GetWebhooks)
+		auth.DELETE("/api/user/webhooks/:id", s.webhookHandler.DeleteWebhook)
+		auth.GET("/api/user/webhooks/:id/deliveries", s.webhookHandler.GetDeliveries)
 	}
 
-	if err := r.Run(s.addr); err != nil {
-		s.logger.Fatal("cant start server", zap.Error(err))
+	if s.cfg.ServiceToken == "" {
+		s.logger.Warn("service token is not set, service API is disabled")
+	} else {
+		service := r.Group("/api/service")
+		service.Use(middleware.ServiceAuthMiddleware(s.cfg.ServiceToken))
+		{
+			service.POST("/refunds", s.serviceHandler.RefundWithdrawal)
+			service.POST("/holds", s.serviceHandler.PlaceHold)
+			service.POST("/holds/:id/capture", s.serviceHandler.CaptureHold)
+			service.POST("/holds/:id/release", s.serviceHandler.ReleaseHold)
+		}
 	}
+
+	return r
 }
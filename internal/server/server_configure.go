@@ -1,60 +1,77 @@
 package server
 
 import (
-	"flag"
+	"database/sql"
 	"fmt"
-	"os"
 
+	"github.com/krasvl/market/internal/config"
+	"github.com/krasvl/market/internal/events"
+	"github.com/krasvl/market/internal/scheduler"
 	"github.com/krasvl/market/internal/storage"
 	"go.uber.org/zap"
 )
 
-func GetConfiguredServer(databaseDefault, addrDefault, secretDefault string) (*Server, error) {
-	database := flag.String("d", databaseDefault, "database-dsn")
-	addr := flag.String("a", addrDefault, "address")
-	sec := flag.String("s", secretDefault, "secret")
-
-	flag.Parse()
-
-	if value, ok := os.LookupEnv("DATABASE_URI"); ok && value != "" {
-		database = &value
-	}
-	if value, ok := os.LookupEnv("RUN_ADDRESS"); ok && value != "" {
-		addr = &value
+// GetConfiguredServer creates a server working on db, which is opened from
+// cfg.Database. The caller closes db after the server.
+func GetConfiguredServer(cfg *config.Config, db *sql.DB, logger *zap.Logger) (*Server, error) {
+	userStorage, err := storage.NewUserStorage(db, logger)
+	if err != nil {
+		return nil, fmt.Errorf("cant create user storage: %w", err)
 	}
-	if value, ok := os.LookupEnv("SECRET"); ok && value != "" {
-		sec = &value
+
+	// The server never credits orders, so points lifetime and the poll
+	// interval do not matter here.
+	orderStorage, err := storage.NewOrderStorage(db, logger, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cant create order storage: %w", err)
 	}
 
-	logger, err := zap.NewProduction()
+	balanceStorage, err := storage.NewBalanceStorage(db, logger, cfg.Server.TransferDailyLimit)
 	if err != nil {
-		return nil, fmt.Errorf("cant create logger: %w", err)
+		return nil, fmt.Errorf("cant create balance storage: %w", err)
 	}
 
-	db, err := storage.NewDB(*database)
+	idempotencyStorage, err := storage.NewIdempotencyStorage(db, logger)
 	if err != nil {
-		return nil, fmt.Errorf("cant open database: %w", err)
+		return nil, fmt.Errorf("cant create idempotency storage: %w", err)
 	}
 
-	userStorage, err := storage.NewUserStorage(db, logger)
+	webhookStorage, err := storage.NewWebhookStorage(db, logger)
 	if err != nil {
-		return nil, fmt.Errorf("cant create user storage: %w", err)
+		return nil, fmt.Errorf("cant create webhook storage: %w", err)
 	}
 
-	orderStorage, err := storage.NewOrderStorage(db, logger)
+	healthStorage, err := storage.NewHealthStorage(db, logger)
 	if err != nil {
-		return nil, fmt.Errorf("cant create order storage: %w", err)
+		return nil, fmt.Errorf("cant create health storage: %w", err)
 	}
 
-	balanceStorage, err := storage.NewBalanceStorage(db, logger)
+	listener, err := storage.NewListener(cfg.Database, events.Channel, logger)
 	if err != nil {
-		return nil, fmt.Errorf("cant create balance storage: %w", err)
+		return nil, fmt.Errorf("cant create event listener: %w", err)
 	}
+	broker := events.NewBroker(logger)
 
 	logger.Info("server created:",
-		zap.String("address", *addr),
-		zap.String("database", *database),
+		zap.String("address", cfg.Server.Address),
+		zap.Stringer("transfer_daily_limit", cfg.Server.TransferDailyLimit),
+		zap.Duration("write_timeout", cfg.Server.WriteTimeout),
+		zap.Duration("shutdown_timeout", cfg.Server.ShutdownTimeout),
+		zap.Bool("run_scheduler", cfg.Server.RunScheduler),
+	)
+
+	server := NewServer(
+		cfg.Server, userStorage, orderStorage, balanceStorage, idempotencyStorage, webhookStorage, healthStorage,
+		broker, listener, logger,
 	)
 
-	return NewServer(*addr, userStorage, orderStorage, balanceStorage, logger, *sec), nil
+	// The embedded scheduler shares the database pool and the logger.
+	if cfg.Server.RunScheduler {
+		server.scheduler, err = scheduler.GetConfiguredScheduler(cfg.Scheduler, cfg.Database, db, logger)
+		if err != nil {
+			_ = listener.Close()
+			return nil, fmt.Errorf("cant create scheduler: %w", err)
+		}
+	}
+	return server, nil
 }
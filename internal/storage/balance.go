@@ -2,88 +2,154 @@ package storage
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
+	"github.com/krasvl/market/internal/money"
 	_ "github.com/lib/pq"
 	"go.uber.org/zap"
 )
 
 type Balance struct {
+	Expiring  []ExpiringPoints
 	UserID    int
-	Current   float64
-	Withdrawn float64
+	Current   money.Amount
+	Withdrawn money.Amount
+	Held      money.Amount
 }
 
 type Withdrawal struct {
 	ProcessedAt time.Time
 	OrderNumber string
+	Refunds     []Refund
 	ID          int
 	UserID      int
-	Sum         float64
+	Sum         money.Amount
+	Refunded    money.Amount
 }
 
+var (
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrInvalidSum        = errors.New("sum must be positive")
+)
+
 type BalanceStorage interface {
 	GetBalance(userID int) (Balance, error)
 	Withdraw(userID int, withdrawal Withdrawal) error
-	GetWithdrawals(userID int) ([]Withdrawal, error)
+	GetWithdrawals(userID int, opts ListOptions) ([]Withdrawal, string, error)
+	RefundWithdrawal(userID int, refundID, orderNumber string, sum money.Amount) (Refund, error)
 }
 
 type BalanceStoragePostgres struct {
-	logger *zap.Logger
-	db     *sql.DB
+	logger             *zap.Logger
+	db                 *sql.DB
+	transferDailyLimit money.Amount
 }
 
-func NewBalanceStorage(db *sql.DB, logger *zap.Logger) (*BalanceStoragePostgres, error) {
+// NewBalanceStorage creates the balance storage. transferDailyLimit caps how
+// many points a user may transfer within 24 hours, zero disables the limit.
+func NewBalanceStorage(db *sql.DB, logger *zap.Logger, transferDailyLimit money.Amount) (*BalanceStoragePostgres, error) {
 	return &BalanceStoragePostgres{
-		logger: logger,
-		db:     db,
+		logger:             logger,
+		db:                 db,
+		transferDailyLimit: transferDailyLimit,
 	}, nil
 }
 
 func (s *BalanceStoragePostgres) GetBalance(userID int) (Balance, error) {
 	var balance Balance
 	err := s.db.QueryRow(
-		"SELECT user_id, current, withdrawn FROM balances WHERE user_id = $1",
+		"SELECT user_id, current, withdrawn, held FROM balances WHERE user_id = $1",
 		userID,
-	).Scan(&balance.UserID, &balance.Current, &balance.Withdrawn)
+	).Scan(&balance.UserID, &balance.Current, &balance.Withdrawn, &balance.Held)
 	if err != nil {
 		s.logger.Error("failed to get balance", zap.Error(err))
 		return Balance{}, err
 	}
+
+	balance.Expiring, err = s.getExpiringPoints(userID)
+	if err != nil {
+		return Balance{}, err
+	}
 	return balance, nil
 }
 
+// Withdraw debits the balance only if it covers the whole sum. The check is
+// part of the UPDATE, so concurrent withdrawals serialize on the balance row
+// and can never drive it negative.
 func (s *BalanceStoragePostgres) Withdraw(userID int, withdrawal Withdrawal) error {
+	if withdrawal.Sum <= 0 {
+		return ErrInvalidSum
+	}
+
 	tx, err := s.db.Begin()
 	if err != nil {
 		s.logger.Error("failed to begin transaction", zap.Error(err))
 		return err
 	}
 
-	_, err = tx.Exec(
-		"UPDATE balances SET current = current - $1, withdrawn = withdrawn + $1 WHERE user_id = $2",
+	res, err := tx.Exec(
+		"UPDATE balances SET current = current - $1, withdrawn = withdrawn + $1 WHERE user_id = $2 AND current >= $1",
 		withdrawal.Sum, userID,
 	)
 	if err != nil {
-		if err := tx.Rollback(); err != nil {
-			s.logger.Error("failed to rollback transaction", zap.Error(err))
-		}
+		rollback(tx, s.logger)
 		s.logger.Error("failed to update balance", zap.Error(err))
 		return err
 	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to get affected rows", zap.Error(err))
+		return err
+	}
+	if updated == 0 {
+		rollback(tx, s.logger)
+		return ErrInsufficientFunds
+	}
+
+	err = postTransaction(tx, OperationWithdrawal, withdrawal.OrderNumber,
+		userAccount(userID), systemAccount(accountWithdrawal), withdrawal.Sum)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to post ledger transaction", zap.Error(err))
+		return err
+	}
+
+	parts, err := consumeLots(tx, userID, withdrawal.Sum)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to consume point lots", zap.Error(err))
+		return err
+	}
 
-	_, err = tx.Exec(
-		"INSERT INTO withdrawals (user_id, order_number, sum, processed_at) VALUES ($1, $2, $3, $4)",
+	var withdrawalID int
+	err = tx.QueryRow(
+		"INSERT INTO withdrawals (user_id, order_number, sum, processed_at) VALUES ($1, $2, $3, $4) RETURNING id",
 		withdrawal.UserID, withdrawal.OrderNumber, withdrawal.Sum, withdrawal.ProcessedAt,
-	)
+	).Scan(&withdrawalID)
 	if err != nil {
-		if err := tx.Rollback(); err != nil {
-			s.logger.Error("failed to rollback transaction", zap.Error(err))
-		}
+		rollback(tx, s.logger)
 		s.logger.Error("failed to insert withdrawal", zap.Error(err))
 		return err
 	}
 
+	if err := addWithdrawalLots(tx, withdrawalID, parts); err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to add withdrawal lots", zap.Error(err))
+		return err
+	}
+
+	err = enqueueWebhookEvent(tx, userID, EventWithdrawalCreated, withdrawalEventData{
+		Order: withdrawal.OrderNumber,
+		Sum:   withdrawal.Sum,
+	})
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to enqueue webhook event", zap.Error(err))
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		s.logger.Error("failed to commit transaction", zap.Error(err))
 		return err
@@ -92,14 +158,21 @@ func (s *BalanceStoragePostgres) Withdraw(userID int, withdrawal Withdrawal) err
 	return nil
 }
 
-func (s *BalanceStoragePostgres) GetWithdrawals(userID int) ([]Withdrawal, error) {
-	rows, err := s.db.Query(
-		"SELECT id, user_id, order_number, sum, processed_at FROM withdrawals WHERE user_id = $1 ORDER BY processed_at DESC",
-		userID,
+// GetWithdrawals returns a page of the user's withdrawals with their refunds
+// and the cursor of the next page, empty if there is none.
+func (s *BalanceStoragePostgres) GetWithdrawals(userID int, opts ListOptions) ([]Withdrawal, string, error) {
+	query, args, err := pageQuery(
+		"SELECT id, user_id, order_number, sum, refunded, processed_at FROM withdrawals WHERE user_id = $1",
+		[]any{userID}, "processed_at", opts,
 	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		s.logger.Error("failed to get withdrawals", zap.Error(err))
-		return nil, err
+		return nil, "", err
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
@@ -111,16 +184,33 @@ func (s *BalanceStoragePostgres) GetWithdrawals(userID int) ([]Withdrawal, error
 	for rows.Next() {
 		var withdrawal Withdrawal
 		if err := rows.Scan(
-			&withdrawal.ID, &withdrawal.UserID, &withdrawal.OrderNumber, &withdrawal.Sum, &withdrawal.ProcessedAt,
+			&withdrawal.ID, &withdrawal.UserID, &withdrawal.OrderNumber, &withdrawal.Sum, &withdrawal.Refunded,
+			&withdrawal.ProcessedAt,
 		); err != nil {
 			s.logger.Error("failed to scan withdrawal", zap.Error(err))
-			return nil, err
+			return nil, "", err
 		}
 		withdrawals = append(withdrawals, withdrawal)
 	}
 	if err := rows.Err(); err != nil {
 		s.logger.Error("failed to iterate over rows", zap.Error(err))
-		return nil, err
+		return nil, "", err
+	}
+
+	withdrawals, cursor := nextPage(withdrawals, opts.Limit, func(w Withdrawal) (time.Time, int) {
+		return w.ProcessedAt, w.ID
+	})
+
+	ids := make([]int, 0, len(withdrawals))
+	for _, withdrawal := range withdrawals {
+		ids = append(ids, withdrawal.ID)
+	}
+	refunds, err := s.getRefunds(ids)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range withdrawals {
+		withdrawals[i].Refunds = refunds[withdrawals[i].ID]
 	}
-	return withdrawals, nil
+	return withdrawals, cursor, nil
 }
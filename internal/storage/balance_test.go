@@ -0,0 +1,410 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWithdrawConcurrent(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	balances, err := NewBalanceStorage(db, zap.NewNop(), 0)
+	require.NoError(t, err)
+	testFund(t, db, userID, money.FromPoints(100))
+
+	const attempts = 20
+	var (
+		wg           sync.WaitGroup
+		mu           sync.Mutex
+		succeeded    int
+		insufficient int
+	)
+	for i := range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := balances.Withdraw(userID, Withdrawal{
+				UserID:      userID,
+				OrderNumber: testOrderNumber(),
+				Sum:         money.FromPoints(10),
+				ProcessedAt: time.Now(),
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, ErrInsufficientFunds):
+				insufficient++
+			default:
+				t.Errorf("withdrawal %d failed: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 10, succeeded)
+	assert.Equal(t, attempts-10, insufficient)
+
+	balance, err := balances.GetBalance(userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.Amount(0), balance.Current)
+	assert.Equal(t, money.FromPoints(100), balance.Withdrawn)
+
+	entries, err := balances.GetLedgerEntries(userID)
+	require.NoError(t, err)
+	var total money.Amount
+	for _, entry := range entries {
+		total += entry.Amount
+	}
+	assert.Equal(t, balance.Current, total)
+}
+
+func TestWithdrawInvalidSum(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	balances, err := NewBalanceStorage(db, zap.NewNop(), 0)
+	require.NoError(t, err)
+
+	err = balances.Withdraw(userID, Withdrawal{UserID: userID, OrderNumber: testOrderNumber(), Sum: -1})
+	assert.ErrorIs(t, err, ErrInvalidSum)
+}
+
+func TestRefundWithdrawal(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	balances, err := NewBalanceStorage(db, zap.NewNop(), 0)
+	require.NoError(t, err)
+	testFund(t, db, userID, money.FromPoints(100))
+
+	number := testOrderNumber()
+	require.NoError(t, balances.Withdraw(userID, Withdrawal{
+		UserID:      userID,
+		OrderNumber: number,
+		Sum:         money.FromPoints(60),
+		ProcessedAt: time.Now(),
+	}))
+
+	_, err = balances.RefundWithdrawal(userID, "too-large", number, money.FromPoints(70))
+	assert.ErrorIs(t, err, ErrRefundTooLarge)
+
+	refund, err := balances.RefundWithdrawal(userID, number+"-1", number, money.FromPoints(20))
+	require.NoError(t, err)
+	assert.Equal(t, money.FromPoints(20), refund.Sum)
+
+	// A retry returns the first refund without crediting the points again.
+	retried, err := balances.RefundWithdrawal(userID, number+"-1", number, money.FromPoints(20))
+	require.NoError(t, err)
+	assert.Equal(t, refund.ID, retried.ID)
+	_, err = balances.RefundWithdrawal(userID, number+"-1", number, money.FromPoints(10))
+	assert.ErrorIs(t, err, ErrRefundIDReused)
+
+	refund, err = balances.RefundWithdrawal(userID, number+"-2", number, 0)
+	require.NoError(t, err)
+	assert.Equal(t, money.FromPoints(40), refund.Sum)
+
+	_, err = balances.RefundWithdrawal(userID, number+"-3", number, 0)
+	assert.ErrorIs(t, err, ErrWithdrawalRefunded)
+
+	balance, err := balances.GetBalance(userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.FromPoints(100), balance.Current)
+	assert.Equal(t, money.Amount(0), balance.Withdrawn)
+
+	withdrawals, _, err := balances.GetWithdrawals(userID, ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 1)
+	assert.Equal(t, money.FromPoints(60), withdrawals[0].Refunded)
+	assert.Len(t, withdrawals[0].Refunds, 2)
+}
+
+func TestRefundAmbiguousOrder(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	balances, err := NewBalanceStorage(db, zap.NewNop(), 0)
+	require.NoError(t, err)
+	testFund(t, db, userID, money.FromPoints(100))
+
+	number := testOrderNumber()
+	for range 2 {
+		require.NoError(t, balances.Withdraw(userID, Withdrawal{
+			UserID:      userID,
+			OrderNumber: number,
+			Sum:         money.FromPoints(10),
+			ProcessedAt: time.Now(),
+		}))
+	}
+
+	_, err = balances.RefundWithdrawal(userID, number, number, 0)
+	assert.ErrorIs(t, err, ErrWithdrawalAmbiguous)
+
+	balance, err := balances.GetBalance(userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.FromPoints(80), balance.Current)
+}
+
+func TestRefundKeepsExpiry(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	balances, err := NewBalanceStorage(db, zap.NewNop(), 0)
+	require.NoError(t, err)
+	testFund(t, db, userID, money.FromPoints(100))
+	// The lot is due but not written off yet, so it can still be spent.
+	_, err = db.Exec("UPDATE point_lots SET expires_at = now() - interval '1 second' WHERE user_id = $1", userID)
+	require.NoError(t, err)
+
+	number := testOrderNumber()
+	require.NoError(t, balances.Withdraw(userID, Withdrawal{
+		UserID:      userID,
+		OrderNumber: number,
+		Sum:         money.FromPoints(60),
+		ProcessedAt: time.Now(),
+	}))
+	_, err = balances.RefundWithdrawal(userID, number, number, 0)
+	require.NoError(t, err)
+
+	// The refunded points expire together with the rest of their lot.
+	for {
+		expired, err := balances.ExpirePoints()
+		require.NoError(t, err)
+		if expired == 0 {
+			break
+		}
+	}
+
+	balance, err := balances.GetBalance(userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.Amount(0), balance.Current)
+}
+
+func TestPointsExpiration(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	balances, err := NewBalanceStorage(db, zap.NewNop(), 0)
+	require.NoError(t, err)
+	testFund(t, db, userID, money.FromPoints(100))
+	testFund(t, db, userID, money.FromPoints(50))
+
+	var first, second int
+	require.NoError(t, db.QueryRow(
+		"SELECT MIN(id), MAX(id) FROM point_lots WHERE user_id = $1", userID,
+	).Scan(&first, &second))
+	_, err = db.Exec("UPDATE point_lots SET expires_at = now() + interval '2 days' WHERE id = $1", first)
+	require.NoError(t, err)
+	_, err = db.Exec("UPDATE point_lots SET expires_at = now() + interval '1 day' WHERE id = $1", second)
+	require.NoError(t, err)
+
+	// The lot expiring first is spent first.
+	require.NoError(t, balances.Withdraw(userID, Withdrawal{
+		UserID:      userID,
+		OrderNumber: testOrderNumber(),
+		Sum:         money.FromPoints(30),
+		ProcessedAt: time.Now(),
+	}))
+	var remaining money.Amount
+	require.NoError(t, db.QueryRow("SELECT remaining FROM point_lots WHERE id = $1", second).Scan(&remaining))
+	assert.Equal(t, money.FromPoints(20), remaining)
+
+	balance, err := balances.GetBalance(userID)
+	require.NoError(t, err)
+	assert.Len(t, balance.Expiring, 2)
+
+	_, err = db.Exec("UPDATE point_lots SET expires_at = now() - interval '1 second' WHERE id = $1", second)
+	require.NoError(t, err)
+	for {
+		expired, err := balances.ExpirePoints()
+		require.NoError(t, err)
+		if expired == 0 {
+			break
+		}
+	}
+
+	balance, err = balances.GetBalance(userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.FromPoints(100), balance.Current)
+	require.Len(t, balance.Expiring, 1)
+	assert.Equal(t, money.FromPoints(100), balance.Expiring[0].Sum)
+}
+
+func TestHolds(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	balances, err := NewBalanceStorage(db, zap.NewNop(), 0)
+	require.NoError(t, err)
+	testFund(t, db, userID, money.FromPoints(100))
+
+	_, err = balances.Hold(userID, testOrderNumber(), money.FromPoints(150), time.Minute)
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+
+	captured, err := balances.Hold(userID, testOrderNumber(), money.FromPoints(60), time.Minute)
+	require.NoError(t, err)
+	released, err := balances.Hold(userID, testOrderNumber(), money.FromPoints(30), -time.Second)
+	require.NoError(t, err)
+
+	balance, err := balances.GetBalance(userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.FromPoints(10), balance.Current)
+	assert.Equal(t, money.FromPoints(90), balance.Held)
+
+	_, err = balances.CaptureHold(userID, captured.ID)
+	require.NoError(t, err)
+	_, err = balances.ReleaseHold(userID, captured.ID)
+	assert.ErrorIs(t, err, ErrHoldResolved)
+
+	_, err = balances.CaptureHold(userID, released.ID)
+	assert.ErrorIs(t, err, ErrHoldExpired)
+
+	count, err := balances.ReleaseExpiredHolds()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, count, 1)
+	_, err = balances.CaptureHold(AnyUser, released.ID)
+	assert.ErrorIs(t, err, ErrHoldResolved)
+
+	balance, err = balances.GetBalance(userID)
+	require.NoError(t, err)
+	assert.Equal(t, money.FromPoints(40), balance.Current)
+	assert.Equal(t, money.FromPoints(60), balance.Withdrawn)
+	assert.Equal(t, money.Amount(0), balance.Held)
+}
+
+func TestTransfer(t *testing.T) {
+	db := testDB(t)
+	senderID := testUser(t, db)
+	recipientID := testUser(t, db)
+
+	var senderLogin, recipientLogin string
+	require.NoError(t, db.QueryRow("SELECT login FROM users WHERE id = $1", senderID).Scan(&senderLogin))
+	require.NoError(t, db.QueryRow("SELECT login FROM users WHERE id = $1", recipientID).Scan(&recipientLogin))
+
+	balances, err := NewBalanceStorage(db, zap.NewNop(), money.FromPoints(50))
+	require.NoError(t, err)
+	testFund(t, db, senderID, money.FromPoints(100))
+	testFund(t, db, recipientID, money.FromPoints(100))
+
+	_, err = balances.Transfer(senderID, senderLogin, money.FromPoints(10))
+	assert.ErrorIs(t, err, ErrSelfTransfer)
+	_, err = balances.Transfer(senderID, "unknown-"+senderLogin, money.FromPoints(10))
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	// Opposite transfers lock the same rows and must not deadlock.
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				_, errs[i] = balances.Transfer(senderID, recipientLogin, money.FromPoints(5))
+			} else {
+				_, errs[i] = balances.Transfer(recipientID, senderLogin, money.FromPoints(5))
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	_, err = balances.Transfer(senderID, recipientLogin, money.FromPoints(25))
+	require.NoError(t, err)
+	_, err = balances.Transfer(senderID, recipientLogin, money.FromPoints(1))
+	assert.ErrorIs(t, err, ErrTransferLimitExceeded)
+
+	sender, err := balances.GetBalance(senderID)
+	require.NoError(t, err)
+	assert.Equal(t, money.FromPoints(75), sender.Current)
+	recipient, err := balances.GetBalance(recipientID)
+	require.NoError(t, err)
+	assert.Equal(t, money.FromPoints(125), recipient.Current)
+
+	transfers, err := balances.GetTransfers(recipientID)
+	require.NoError(t, err)
+	require.Len(t, transfers, 11)
+	assert.Equal(t, senderLogin, transfers[0].SenderLogin)
+	assert.Equal(t, money.FromPoints(25), transfers[0].Sum)
+}
+
+func TestStatement(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	balances, err := NewBalanceStorage(db, zap.NewNop(), 0)
+	require.NoError(t, err)
+	testFund(t, db, userID, money.FromPoints(100))
+	require.NoError(t, balances.Withdraw(userID, Withdrawal{
+		UserID:      userID,
+		OrderNumber: testOrderNumber(),
+		Sum:         money.FromPoints(30),
+		ProcessedAt: time.Now(),
+	}))
+
+	statement, err := balances.GetStatement(userID, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, statement.Lines, 2)
+	assert.Equal(t, money.Amount(0), statement.OpeningBalance)
+	assert.Equal(t, OperationAccrual, statement.Lines[0].Operation)
+	assert.Equal(t, money.FromPoints(100), statement.Lines[0].Balance)
+	assert.Equal(t, money.FromPoints(-30), statement.Lines[1].Amount)
+	assert.Equal(t, money.FromPoints(70), statement.Lines[1].Balance)
+	assert.Equal(t, money.FromPoints(70), statement.ClosingBalance)
+
+	statement, err = balances.GetStatement(userID, time.Now().Add(time.Hour), time.Time{})
+	require.NoError(t, err)
+	assert.Empty(t, statement.Lines)
+	assert.Equal(t, money.FromPoints(70), statement.OpeningBalance)
+	assert.Equal(t, money.FromPoints(70), statement.ClosingBalance)
+}
+
+func TestGetWithdrawalsPages(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	balances, err := NewBalanceStorage(db, zap.NewNop(), 0)
+	require.NoError(t, err)
+	testFund(t, db, userID, money.FromPoints(100))
+	for range 5 {
+		require.NoError(t, balances.Withdraw(userID, Withdrawal{
+			UserID:      userID,
+			OrderNumber: testOrderNumber(),
+			Sum:         money.FromPoints(1),
+			ProcessedAt: time.Now(),
+		}))
+	}
+
+	all, cursor, err := balances.GetWithdrawals(userID, ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, all, 5)
+	assert.Empty(t, cursor)
+
+	var paged []Withdrawal
+	opts := ListOptions{Limit: 2}
+	for {
+		page, cursor, err := balances.GetWithdrawals(userID, opts)
+		require.NoError(t, err)
+		paged = append(paged, page...)
+		if cursor == "" {
+			break
+		}
+		opts.Cursor = cursor
+	}
+	assert.Equal(t, all, paged)
+
+	ascending, _, err := balances.GetWithdrawals(userID, ListOptions{Ascending: true, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, ascending, 1)
+	assert.Equal(t, all[4].ID, ascending[0].ID)
+}
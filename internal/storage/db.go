@@ -5,10 +5,14 @@ import (
 	"embed"
 	"errors"
 	"fmt"
+	"io/fs"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
 )
 
 func NewDB(database string) (*sql.DB, error) {
@@ -42,3 +46,49 @@ func runMigrations(database string) error {
 	}
 	return nil
 }
+
+// latestMigration returns the version of the newest embedded migration.
+func latestMigration() (uint, error) {
+	d, err := iofs.New(migrationsDir, "migrations")
+	if err != nil {
+		return 0, fmt.Errorf("failed to return an iofs driver: %w", err)
+	}
+	defer func() { _ = d.Close() }()
+
+	version, err := d.First()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read first migration: %w", err)
+	}
+	for {
+		next, err := d.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read migration after %d: %w", version, err)
+		}
+		version = next
+	}
+}
+
+// NewListener opens a dedicated connection listening on a notification
+// channel. It reconnects on its own; a nil notification on its channel means
+// notifications may have been missed while it was reconnecting.
+func NewListener(database, channel string, logger *zap.Logger) (*pq.Listener, error) {
+	listener := pq.NewListener(database, time.Second, time.Minute, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Warn("listener connection problem", zap.String("channel", channel), zap.Error(err))
+		}
+	})
+	if err := listener.Listen(channel); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %w", channel, err)
+	}
+	return listener, nil
+}
+
+func rollback(tx *sql.Tx, logger *zap.Logger) {
+	if err := tx.Rollback(); err != nil {
+		logger.Error("failed to rollback transaction", zap.Error(err))
+	}
+}
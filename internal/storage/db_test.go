@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// testDB connects to the database from TEST_DATABASE_URI. Storage tests need
+// a real Postgres and are skipped without one.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	db, err := NewDB(dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Logf("failed to close db: %v", err)
+		}
+	})
+	return db
+}
+
+func testUser(t *testing.T, db *sql.DB) int {
+	t.Helper()
+
+	users, err := NewUserStorage(db, zap.NewNop())
+	require.NoError(t, err)
+
+	userID, err := users.AddUser(User{
+		Login:    fmt.Sprintf("test-%d", time.Now().UnixNano()),
+		Password: "password",
+	})
+	require.NoError(t, err)
+	return userID
+}
+
+var orderSeq atomic.Int64
+
+func testOrderNumber() string {
+	return fmt.Sprintf("%d%d", time.Now().UnixNano(), orderSeq.Add(1))
+}
+
+// testFund credits the user through a processed order.
+func testFund(t *testing.T, db *sql.DB, userID int, amount money.Amount) {
+	t.Helper()
+
+	orders, err := NewOrderStorage(db, zap.NewNop(), 12, 10*time.Second)
+	require.NoError(t, err)
+
+	order := Order{UserID: userID, Number: testOrderNumber(), Status: StatusNew}
+	require.NoError(t, orders.AddOrder(&order))
+	require.NoError(t, db.QueryRow("SELECT id FROM orders WHERE number = $1", order.Number).Scan(&order.ID))
+	order.Status = StatusProcessed
+	order.Accrual = amount
+	require.NoError(t, orders.ProcessOrder(&order))
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// ErrMigrationsPending is returned when the database schema is behind the
+// migrations this build embeds or a migration failed halfway.
+var ErrMigrationsPending = errors.New("database migrations are not applied")
+
+type HealthStorage interface {
+	Ready(ctx context.Context) error
+}
+
+type HealthStoragePostgres struct {
+	logger *zap.Logger
+	db     *sql.DB
+	// migration is the newest migration this build embeds.
+	migration uint
+}
+
+func NewHealthStorage(db *sql.DB, logger *zap.Logger) (*HealthStoragePostgres, error) {
+	migration, err := latestMigration()
+	if err != nil {
+		return nil, err
+	}
+	return &HealthStoragePostgres{
+		logger:    logger,
+		db:        db,
+		migration: migration,
+	}, nil
+}
+
+// Ready checks that the database answers and its schema is at least as new
+// as the embedded migrations. A newer schema is fine, it is left by a newer
+// build rolling out.
+func (s *HealthStoragePostgres) Ready(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		s.logger.Error("failed to ping database", zap.Error(err))
+		return err
+	}
+
+	var version uint
+	var dirty bool
+	err := s.db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: no migrations applied", ErrMigrationsPending)
+	}
+	if err != nil {
+		s.logger.Error("failed to get migration version", zap.Error(err))
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("%w: migration %d is dirty", ErrMigrationsPending, version)
+	}
+	if version < s.migration {
+		return fmt.Errorf("%w: version %d, want %d", ErrMigrationsPending, version, s.migration)
+	}
+	return nil
+}
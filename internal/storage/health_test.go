@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestLatestMigration(t *testing.T) {
+	version, err := latestMigration()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, version, uint(15))
+}
+
+func TestHealthReady(t *testing.T) {
+	db := testDB(t)
+
+	health, err := NewHealthStorage(db, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, health.Ready(context.Background()))
+
+	health.migration++
+	assert.ErrorIs(t, health.Ready(context.Background()), ErrMigrationsPending)
+}
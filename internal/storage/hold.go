@@ -0,0 +1,363 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"go.uber.org/zap"
+)
+
+type HoldStatus string
+
+const (
+	HoldStatusHeld     HoldStatus = "HELD"
+	HoldStatusCaptured HoldStatus = "CAPTURED"
+	HoldStatusReleased HoldStatus = "RELEASED"
+)
+
+// AnyUser lets service callers resolve a hold without knowing its owner.
+const AnyUser = 0
+
+// releaseBatchSize limits how many holds a single ReleaseExpiredHolds run handles.
+const releaseBatchSize = 100
+
+// Hold is a reservation of points for an order whose payment is in flight.
+type Hold struct {
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	OrderNumber string
+	Status      HoldStatus
+	ID          int
+	UserID      int
+	Sum         money.Amount
+}
+
+var (
+	ErrHoldNotFound = errors.New("hold not found")
+	ErrHoldResolved = errors.New("hold already captured or released")
+	ErrHoldExpired  = errors.New("hold expired")
+)
+
+type HoldStorage interface {
+	Hold(userID int, orderNumber string, sum money.Amount, ttl time.Duration) (Hold, error)
+	CaptureHold(userID, holdID int) (Hold, error)
+	ReleaseHold(userID, holdID int) (Hold, error)
+}
+
+// Hold moves sum from the current balance to the held one until the hold is
+// captured or released.
+func (s *BalanceStoragePostgres) Hold(
+	userID int,
+	orderNumber string,
+	sum money.Amount,
+	ttl time.Duration,
+) (Hold, error) {
+	if sum <= 0 {
+		return Hold{}, ErrInvalidSum
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.Error("failed to begin transaction", zap.Error(err))
+		return Hold{}, err
+	}
+
+	res, err := tx.Exec(
+		"UPDATE balances SET current = current - $1, held = held + $1 WHERE user_id = $2 AND current >= $1",
+		sum, userID,
+	)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to update balance", zap.Error(err))
+		return Hold{}, err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to get affected rows", zap.Error(err))
+		return Hold{}, err
+	}
+	if updated == 0 {
+		rollback(tx, s.logger)
+		return Hold{}, ErrInsufficientFunds
+	}
+
+	parts, err := consumeLots(tx, userID, sum)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to consume point lots", zap.Error(err))
+		return Hold{}, err
+	}
+
+	err = postTransaction(tx, OperationHold, orderNumber, userAccount(userID), systemAccount(accountHeld), sum)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to post ledger transaction", zap.Error(err))
+		return Hold{}, err
+	}
+
+	hold := Hold{UserID: userID, OrderNumber: orderNumber, Sum: sum, Status: HoldStatusHeld}
+	err = tx.QueryRow(
+		`INSERT INTO holds (user_id, order_number, sum, expires_at)
+		VALUES ($1, $2, $3, now() + $4 * interval '1 millisecond')
+		RETURNING id, created_at, expires_at`,
+		userID, orderNumber, sum, ttl.Milliseconds(),
+	).Scan(&hold.ID, &hold.CreatedAt, &hold.ExpiresAt)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to insert hold", zap.Error(err))
+		return Hold{}, err
+	}
+
+	for _, part := range parts {
+		_, err := tx.Exec(
+			"INSERT INTO hold_lots (hold_id, amount, expires_at) VALUES ($1, $2, $3)",
+			hold.ID, part.amount, part.expiresAt,
+		)
+		if err != nil {
+			rollback(tx, s.logger)
+			s.logger.Error("failed to insert hold lot", zap.Error(err))
+			return Hold{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("failed to commit transaction", zap.Error(err))
+		return Hold{}, err
+	}
+	return hold, nil
+}
+
+// CaptureHold turns the held points into a regular withdrawal.
+func (s *BalanceStoragePostgres) CaptureHold(userID, holdID int) (Hold, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.Error("failed to begin transaction", zap.Error(err))
+		return Hold{}, err
+	}
+
+	hold, err := s.resolveHold(tx, userID, holdID, HoldStatusCaptured)
+	if err != nil {
+		rollback(tx, s.logger)
+		return Hold{}, err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE balances SET held = held - $1, withdrawn = withdrawn + $1 WHERE user_id = $2",
+		hold.Sum, hold.UserID,
+	)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to update balance", zap.Error(err))
+		return Hold{}, err
+	}
+
+	err = postTransaction(tx, OperationCapture, hold.OrderNumber,
+		systemAccount(accountHeld), systemAccount(accountWithdrawal), hold.Sum)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to post ledger transaction", zap.Error(err))
+		return Hold{}, err
+	}
+
+	var withdrawalID int
+	err = tx.QueryRow(
+		"INSERT INTO withdrawals (user_id, order_number, sum) VALUES ($1, $2, $3) RETURNING id",
+		hold.UserID, hold.OrderNumber, hold.Sum,
+	).Scan(&withdrawalID)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to insert withdrawal", zap.Error(err))
+		return Hold{}, err
+	}
+
+	// The withdrawal takes over the expiry dates the hold kept.
+	parts, err := getHoldLots(tx, hold.ID)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to get hold lots", zap.Error(err))
+		return Hold{}, err
+	}
+	if err := addWithdrawalLots(tx, withdrawalID, parts); err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to add withdrawal lots", zap.Error(err))
+		return Hold{}, err
+	}
+
+	err = enqueueWebhookEvent(tx, hold.UserID, EventWithdrawalCreated, withdrawalEventData{
+		Order: hold.OrderNumber,
+		Sum:   hold.Sum,
+	})
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to enqueue webhook event", zap.Error(err))
+		return Hold{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("failed to commit transaction", zap.Error(err))
+		return Hold{}, err
+	}
+	return hold, nil
+}
+
+// ReleaseHold returns the held points to the current balance.
+func (s *BalanceStoragePostgres) ReleaseHold(userID, holdID int) (Hold, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.Error("failed to begin transaction", zap.Error(err))
+		return Hold{}, err
+	}
+
+	hold, err := s.resolveHold(tx, userID, holdID, HoldStatusReleased)
+	if err != nil {
+		rollback(tx, s.logger)
+		return Hold{}, err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE balances SET held = held - $1, current = current + $1 WHERE user_id = $2",
+		hold.Sum, hold.UserID,
+	)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to update balance", zap.Error(err))
+		return Hold{}, err
+	}
+
+	err = postTransaction(tx, OperationRelease, hold.OrderNumber,
+		systemAccount(accountHeld), userAccount(hold.UserID), hold.Sum)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to post ledger transaction", zap.Error(err))
+		return Hold{}, err
+	}
+
+	// Released points keep the expiry of the lots they were held from.
+	parts, err := getHoldLots(tx, hold.ID)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to get hold lots", zap.Error(err))
+		return Hold{}, err
+	}
+	if err := addLots(tx, hold.UserID, hold.OrderNumber, parts); err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to add point lots", zap.Error(err))
+		return Hold{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("failed to commit transaction", zap.Error(err))
+		return Hold{}, err
+	}
+	return hold, nil
+}
+
+// ReleaseExpiredHolds releases holds that were neither captured nor released
+// in time and returns how many it released.
+func (s *BalanceStoragePostgres) ReleaseExpiredHolds() (int, error) {
+	rows, err := s.db.Query(
+		"SELECT id FROM holds WHERE status = 'HELD' AND expires_at <= now() ORDER BY expires_at LIMIT $1",
+		releaseBatchSize,
+	)
+	if err != nil {
+		s.logger.Error("failed to get expired holds", zap.Error(err))
+		return 0, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.logger.Error("failed to close rows", zap.Error(err))
+		}
+	}()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			s.logger.Error("failed to scan hold id", zap.Error(err))
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("failed to iterate over rows", zap.Error(err))
+		return 0, err
+	}
+
+	released := 0
+	for _, id := range ids {
+		_, err := s.ReleaseHold(AnyUser, id)
+		if errors.Is(err, ErrHoldResolved) {
+			// Captured or released concurrently, nothing left to do.
+			continue
+		}
+		if err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+// resolveHold locks a held hold and marks it with the final status. Expired
+// holds can only be released.
+func (s *BalanceStoragePostgres) resolveHold(tx *sql.Tx, userID, holdID int, status HoldStatus) (Hold, error) {
+	var hold Hold
+	var expired bool
+	err := tx.QueryRow(
+		`SELECT id, user_id, order_number, sum, status, created_at, expires_at, expires_at <= now() FROM holds
+		WHERE id = $1 AND ($2 = 0 OR user_id = $2) FOR UPDATE`,
+		holdID, userID,
+	).Scan(
+		&hold.ID, &hold.UserID, &hold.OrderNumber, &hold.Sum, &hold.Status, &hold.CreatedAt, &hold.ExpiresAt,
+		&expired,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Hold{}, ErrHoldNotFound
+	}
+	if err != nil {
+		s.logger.Error("failed to get hold", zap.Error(err))
+		return Hold{}, err
+	}
+	if hold.Status != HoldStatusHeld {
+		return Hold{}, ErrHoldResolved
+	}
+	if expired && status == HoldStatusCaptured {
+		return Hold{}, ErrHoldExpired
+	}
+
+	_, err = tx.Exec("UPDATE holds SET status = $1, resolved_at = now() WHERE id = $2", status, hold.ID)
+	if err != nil {
+		s.logger.Error("failed to update hold", zap.Error(err))
+		return Hold{}, err
+	}
+	hold.Status = status
+	return hold, nil
+}
+
+func getHoldLots(tx *sql.Tx, holdID int) ([]lotPart, error) {
+	rows, err := tx.Query("SELECT amount, expires_at FROM hold_lots WHERE hold_id = $1 ORDER BY id", holdID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hold lots: %w", err)
+	}
+
+	var parts []lotPart
+	for rows.Next() {
+		var part lotPart
+		if err := rows.Scan(&part.amount, &part.expiresAt); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("failed to scan hold lot: %w", err)
+		}
+		parts = append(parts, part)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close rows: %w", err)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over hold lots: %w", err)
+	}
+	return parts, nil
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// purgeBatchSize limits how many keys a single PurgeExpiredKeys run deletes.
+const purgeBatchSize = 1000
+
+// ErrReservationLost is returned when the key was reserved again by another
+// request while the one holding it was still running.
+var ErrReservationLost = errors.New("idempotency key reserved by another request")
+
+// IdempotencyRecord is the stored outcome of a request sent with an
+// Idempotency-Key header. Status is zero while the original request is
+// still being handled.
+type IdempotencyRecord struct {
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	Key         string
+	RequestHash string
+	ContentType string
+	Body        []byte
+	UserID      int
+	Status      int
+}
+
+type IdempotencyStorage interface {
+	// ReserveKey claims the key for a new request. If the key is already held
+	// by an unexpired record, that record is returned and reserved is false.
+	// A reservation whose request has not finished within lease is taken
+	// over, so a crashed request does not block the key until it expires.
+	ReserveKey(
+		userID int, key, requestHash string, ttl, lease time.Duration,
+	) (record IdempotencyRecord, reserved bool, err error)
+	// SaveResponse and ReleaseKey only act on the reservation made at
+	// reservedAt, the CreatedAt of the reserved record, and return
+	// ErrReservationLost once it was taken over.
+	SaveResponse(userID int, key string, reservedAt time.Time, status int, contentType string, body []byte) error
+	ReleaseKey(userID int, key string, reservedAt time.Time) error
+}
+
+type IdempotencyStoragePostgres struct {
+	logger *zap.Logger
+	db     *sql.DB
+}
+
+func NewIdempotencyStorage(db *sql.DB, logger *zap.Logger) (*IdempotencyStoragePostgres, error) {
+	return &IdempotencyStoragePostgres{
+		logger: logger,
+		db:     db,
+	}, nil
+}
+
+func (s *IdempotencyStoragePostgres) ReserveKey(
+	userID int,
+	key, requestHash string,
+	ttl, lease time.Duration,
+) (IdempotencyRecord, bool, error) {
+	// An expired record, or a reservation left behind by a request that
+	// never finished, is taken over in place.
+	record := IdempotencyRecord{UserID: userID, Key: key, RequestHash: requestHash}
+	err := s.db.QueryRow(
+		`INSERT INTO idempotency_keys (user_id, key, request_hash, expires_at)
+		VALUES ($1, $2, $3, now() + $4 * interval '1 millisecond')
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status = 0, content_type = '', body = NULL,
+			created_at = now(), expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < now()
+			OR (idempotency_keys.status = 0 AND idempotency_keys.created_at < now() - $5 * interval '1 millisecond')
+		RETURNING created_at, expires_at`,
+		userID, key, requestHash, ttl.Milliseconds(), lease.Milliseconds(),
+	).Scan(&record.CreatedAt, &record.ExpiresAt)
+	if err == nil {
+		return record, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		s.logger.Error("failed to reserve idempotency key", zap.Error(err))
+		return IdempotencyRecord{}, false, err
+	}
+
+	record = IdempotencyRecord{UserID: userID, Key: key}
+	err = s.db.QueryRow(
+		`SELECT request_hash, status, content_type, body, created_at, expires_at FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2`,
+		userID, key,
+	).Scan(&record.RequestHash, &record.Status, &record.ContentType, &record.Body, &record.CreatedAt, &record.ExpiresAt)
+	if err != nil {
+		s.logger.Error("failed to get idempotency key", zap.Error(err))
+		return IdempotencyRecord{}, false, err
+	}
+	return record, false, nil
+}
+
+func (s *IdempotencyStoragePostgres) SaveResponse(
+	userID int,
+	key string,
+	reservedAt time.Time,
+	status int,
+	contentType string,
+	body []byte,
+) error {
+	res, err := s.db.Exec(
+		`UPDATE idempotency_keys SET status = $1, content_type = $2, body = $3
+		WHERE user_id = $4 AND key = $5 AND created_at = $6 AND status = 0`,
+		status, contentType, body, userID, key, reservedAt,
+	)
+	if err != nil {
+		s.logger.Error("failed to save idempotent response", zap.Error(err))
+		return err
+	}
+	return s.checkReserved(res)
+}
+
+func (s *IdempotencyStoragePostgres) ReleaseKey(userID int, key string, reservedAt time.Time) error {
+	res, err := s.db.Exec(
+		"DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND created_at = $3 AND status = 0",
+		userID, key, reservedAt,
+	)
+	if err != nil {
+		s.logger.Error("failed to release idempotency key", zap.Error(err))
+		return err
+	}
+	return s.checkReserved(res)
+}
+
+func (s *IdempotencyStoragePostgres) checkReserved(res sql.Result) error {
+	updated, err := res.RowsAffected()
+	if err != nil {
+		s.logger.Error("failed to get affected rows", zap.Error(err))
+		return err
+	}
+	if updated == 0 {
+		return ErrReservationLost
+	}
+	return nil
+}
+
+// PurgeExpiredKeys deletes expired keys and returns how many it deleted.
+func (s *IdempotencyStoragePostgres) PurgeExpiredKeys() (int, error) {
+	res, err := s.db.Exec(
+		`DELETE FROM idempotency_keys WHERE (user_id, key) IN (
+			SELECT user_id, key FROM idempotency_keys WHERE expires_at < now() LIMIT $1
+		)`,
+		purgeBatchSize,
+	)
+	if err != nil {
+		s.logger.Error("failed to purge idempotency keys", zap.Error(err))
+		return 0, err
+	}
+	purged, err := res.RowsAffected()
+	if err != nil {
+		s.logger.Error("failed to get affected rows", zap.Error(err))
+		return 0, err
+	}
+	return int(purged), nil
+}
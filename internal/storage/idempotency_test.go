@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestReserveKey(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	keys, err := NewIdempotencyStorage(db, zap.NewNop())
+	require.NoError(t, err)
+
+	first, reserved, err := keys.ReserveKey(userID, "key", "hash", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.True(t, reserved)
+
+	record, reserved, err := keys.ReserveKey(userID, "key", "hash", time.Hour, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, reserved)
+	assert.Equal(t, 0, record.Status)
+
+	var second IdempotencyRecord
+	t.Run("Stale Reservation Taken Over", func(t *testing.T) {
+		_, err := db.Exec(
+			"UPDATE idempotency_keys SET created_at = created_at - interval '2 minutes' WHERE user_id = $1",
+			userID,
+		)
+		require.NoError(t, err)
+
+		second, reserved, err = keys.ReserveKey(userID, "key", "other", time.Hour, time.Minute)
+		require.NoError(t, err)
+		assert.True(t, reserved)
+	})
+
+	t.Run("Former Owner Fenced Off", func(t *testing.T) {
+		err := keys.SaveResponse(userID, "key", first.CreatedAt, 200, "application/json", []byte("{}"))
+		assert.ErrorIs(t, err, ErrReservationLost)
+		assert.ErrorIs(t, keys.ReleaseKey(userID, "key", first.CreatedAt), ErrReservationLost)
+
+		record, reserved, err := keys.ReserveKey(userID, "key", "other", time.Hour, time.Minute)
+		require.NoError(t, err)
+		assert.False(t, reserved)
+		assert.Equal(t, 0, record.Status)
+	})
+
+	t.Run("Finished Request Kept", func(t *testing.T) {
+		require.NoError(t, keys.SaveResponse(userID, "key", second.CreatedAt, 200, "application/json", []byte("{}")))
+		_, err := db.Exec(
+			"UPDATE idempotency_keys SET created_at = created_at - interval '2 minutes' WHERE user_id = $1",
+			userID,
+		)
+		require.NoError(t, err)
+
+		record, reserved, err := keys.ReserveKey(userID, "key", "other", time.Hour, time.Minute)
+		require.NoError(t, err)
+		assert.False(t, reserved)
+		assert.Equal(t, 200, record.Status)
+	})
+}
+
+func TestPurgeExpiredKeys(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	keys, err := NewIdempotencyStorage(db, zap.NewNop())
+	require.NoError(t, err)
+
+	_, _, err = keys.ReserveKey(userID, "expired", "hash", time.Millisecond, time.Minute)
+	require.NoError(t, err)
+	_, _, err = keys.ReserveKey(userID, "live", "hash", time.Hour, time.Minute)
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	purged, err := keys.PurgeExpiredKeys()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, purged, 1)
+
+	var left []string
+	rows, err := db.Query("SELECT key FROM idempotency_keys WHERE user_id = $1", userID)
+	require.NoError(t, err)
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var key string
+		require.NoError(t, rows.Scan(&key))
+		left = append(left, key)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []string{"live"}, left)
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"go.uber.org/zap"
+)
+
+type LedgerOperation string
+
+const (
+	OperationOpening    LedgerOperation = "OPENING"
+	OperationAccrual    LedgerOperation = "ACCRUAL"
+	OperationWithdrawal LedgerOperation = "WITHDRAWAL"
+	OperationRefund     LedgerOperation = "REFUND"
+	OperationExpiration LedgerOperation = "EXPIRATION"
+	OperationHold       LedgerOperation = "HOLD"
+	OperationCapture    LedgerOperation = "CAPTURE"
+	OperationRelease    LedgerOperation = "RELEASE"
+	OperationTransfer   LedgerOperation = "TRANSFER"
+)
+
+// System accounts of the points ledger. Accrued points are moved from
+// accountAccrual to a user account, spent points from the user account
+// to accountWithdrawal (through accountHeld for two-phase withdrawals) and
+// expired ones to accountExpired, so the sum over all accounts is always zero.
+const (
+	accountAccrual    = "accrual"
+	accountWithdrawal = "withdrawal"
+	accountExpired    = "expired"
+	accountHeld       = "held"
+)
+
+// LedgerEntry is a single movement on a user account. Positive amounts
+// credit the account, negative amounts debit it.
+type LedgerEntry struct {
+	CreatedAt     time.Time
+	Operation     LedgerOperation
+	OrderNumber   string
+	ID            int
+	TransactionID int
+	Amount        money.Amount
+}
+
+type ledgerAccount struct {
+	name   string
+	userID int
+}
+
+func userAccount(userID int) ledgerAccount {
+	return ledgerAccount{userID: userID}
+}
+
+func systemAccount(name string) ledgerAccount {
+	return ledgerAccount{name: name}
+}
+
+func (a ledgerAccount) id(tx *sql.Tx) (int, error) {
+	var id int
+	var err error
+	if a.name != "" {
+		err = tx.QueryRow("SELECT id FROM ledger_accounts WHERE name = $1", a.name).Scan(&id)
+	} else {
+		err = tx.QueryRow("SELECT id FROM ledger_accounts WHERE user_id = $1", a.userID).Scan(&id)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find ledger account: %w", err)
+	}
+	return id, nil
+}
+
+func createUserAccount(tx *sql.Tx, userID int) error {
+	if _, err := tx.Exec("INSERT INTO ledger_accounts (user_id) VALUES ($1)", userID); err != nil {
+		return fmt.Errorf("failed to create ledger account: %w", err)
+	}
+	return nil
+}
+
+// postTransaction journals a balanced transaction moving amount from one
+// account to another. It must run in the same transaction that updates the
+// cached balances.
+func postTransaction(
+	tx *sql.Tx,
+	op LedgerOperation,
+	orderNumber string,
+	from, to ledgerAccount,
+	amount money.Amount,
+) error {
+	fromID, err := from.id(tx)
+	if err != nil {
+		return err
+	}
+	toID, err := to.id(tx)
+	if err != nil {
+		return err
+	}
+
+	var txID int
+	err = tx.QueryRow(
+		"INSERT INTO ledger_transactions (operation, order_number) VALUES ($1, NULLIF($2, '')) RETURNING id",
+		op, orderNumber,
+	).Scan(&txID)
+	if err != nil {
+		return fmt.Errorf("failed to insert ledger transaction: %w", err)
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO ledger_entries (transaction_id, account_id, amount) VALUES ($1, $2, $3), ($1, $4, $5)",
+		txID, fromID, -amount, toID, amount,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert ledger entries: %w", err)
+	}
+	return nil
+}
+
+// GetLedgerEntries returns every movement on the user's account, oldest first.
+func (s *BalanceStoragePostgres) GetLedgerEntries(userID int) ([]LedgerEntry, error) {
+	rows, err := s.db.Query(
+		`SELECT e.id, e.transaction_id, t.operation, COALESCE(t.order_number, ''), e.amount, t.created_at
+		FROM ledger_entries e
+		JOIN ledger_transactions t ON t.id = e.transaction_id
+		JOIN ledger_accounts a ON a.id = e.account_id
+		WHERE a.user_id = $1
+		ORDER BY t.created_at, e.id`,
+		userID,
+	)
+	if err != nil {
+		s.logger.Error("failed to get ledger entries", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.logger.Error("failed to close rows", zap.Error(err))
+		}
+	}()
+
+	var entries []LedgerEntry
+	for rows.Next() {
+		var entry LedgerEntry
+		if err := rows.Scan(
+			&entry.ID, &entry.TransactionID, &entry.Operation, &entry.OrderNumber, &entry.Amount, &entry.CreatedAt,
+		); err != nil {
+			s.logger.Error("failed to scan ledger entry", zap.Error(err))
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("failed to iterate over rows", zap.Error(err))
+		return nil, err
+	}
+	return entries, nil
+}
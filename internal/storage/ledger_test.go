@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/krasvl/market/internal/money"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPostTransaction(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	balances, err := NewBalanceStorage(db, zap.NewNop(), 0)
+	require.NoError(t, err)
+
+	t.Run("Balanced", func(t *testing.T) {
+		number := testOrderNumber()
+		tx, err := db.Begin()
+		require.NoError(t, err)
+		_, err = tx.Exec("UPDATE balances SET current = current + $1 WHERE user_id = $2", money.FromPoints(10), userID)
+		require.NoError(t, err)
+		err = postTransaction(tx, OperationAccrual, number,
+			systemAccount(accountAccrual), userAccount(userID), money.FromPoints(10))
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit())
+
+		entries, err := balances.GetLedgerEntries(userID)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, OperationAccrual, entries[0].Operation)
+		assert.Equal(t, number, entries[0].OrderNumber)
+		assert.Equal(t, money.FromPoints(10), entries[0].Amount)
+	})
+
+	t.Run("Unbalanced", func(t *testing.T) {
+		tx, err := db.Begin()
+		require.NoError(t, err)
+		accountID, err := userAccount(userID).id(tx)
+		require.NoError(t, err)
+
+		var txID int
+		require.NoError(t, tx.QueryRow(
+			"INSERT INTO ledger_transactions (operation) VALUES ('OPENING') RETURNING id",
+		).Scan(&txID))
+		// The balance is only checked on commit.
+		_, err = tx.Exec(
+			"INSERT INTO ledger_entries (transaction_id, account_id, amount) VALUES ($1, $2, $3)",
+			txID, accountID, money.FromPoints(5),
+		)
+		require.NoError(t, err)
+		assert.ErrorContains(t, tx.Commit(), "is not balanced")
+
+		entries, err := balances.GetLedgerEntries(userID)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+}
+
+func TestLedgerAppendOnly(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	balances, err := NewBalanceStorage(db, zap.NewNop(), 0)
+	require.NoError(t, err)
+	testFund(t, db, userID, money.FromPoints(10))
+
+	entries, err := balances.GetLedgerEntries(userID)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	entry := entries[0]
+
+	_, err = db.Exec("UPDATE ledger_entries SET amount = 0 WHERE id = $1", entry.ID)
+	assert.ErrorContains(t, err, "ledger is append-only")
+	_, err = db.Exec("DELETE FROM ledger_entries WHERE id = $1", entry.ID)
+	assert.ErrorContains(t, err, "ledger is append-only")
+	_, err = db.Exec("UPDATE ledger_transactions SET order_number = NULL WHERE id = $1", entry.TransactionID)
+	assert.ErrorContains(t, err, "ledger is append-only")
+	_, err = db.Exec("DELETE FROM ledger_transactions WHERE id = $1", entry.TransactionID)
+	assert.ErrorContains(t, err, "ledger is append-only")
+
+	entries, err = balances.GetLedgerEntries(userID)
+	require.NoError(t, err)
+	assert.Equal(t, []LedgerEntry{entry}, entries)
+}
+
+// TestLedgerMatchesBalances checks what the opening entries of the ledger
+// migration establish for every user: the journal explains the balance, and
+// the whole journal nets to zero.
+func TestLedgerMatchesBalances(t *testing.T) {
+	db := testDB(t)
+	testFund(t, db, testUser(t, db), money.FromPoints(10))
+
+	var mismatched int
+	require.NoError(t, db.QueryRow(
+		`SELECT COUNT(*) FROM (
+			SELECT b.user_id FROM balances b
+			JOIN ledger_accounts a ON a.user_id = b.user_id
+			LEFT JOIN ledger_entries e ON e.account_id = a.id
+			GROUP BY b.user_id, b.current
+			HAVING b.current <> COALESCE(SUM(e.amount), 0)
+		) m`,
+	).Scan(&mismatched))
+	assert.Zero(t, mismatched)
+
+	var total money.Amount
+	require.NoError(t, db.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM ledger_entries").Scan(&total))
+	assert.Equal(t, money.Amount(0), total)
+
+	var accounts int
+	require.NoError(t, db.QueryRow(
+		"SELECT COUNT(*) FROM ledger_accounts WHERE name IN ('opening', 'accrual', 'withdrawal')",
+	).Scan(&accounts))
+	assert.Equal(t, 3, accounts)
+}
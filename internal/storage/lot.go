@@ -0,0 +1,302 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"go.uber.org/zap"
+)
+
+// expiringSoonWindow is how far ahead GetBalance reports expiring points.
+const expiringSoonWindow = 30 * 24 * time.Hour
+
+// expireBatchSize limits how many users a single ExpirePoints run handles.
+const expireBatchSize = 100
+
+// ExpiringPoints is the part of a balance that expires at ExpiresAt.
+type ExpiringPoints struct {
+	ExpiresAt time.Time
+	Sum       money.Amount
+}
+
+// addLot records points credited to the user. Lots without expiresAt never
+// expire. The sum of remaining points over a user's lots always equals the
+// current balance.
+func addLot(tx *sql.Tx, userID int, orderNumber string, amount money.Amount, expiresAt sql.NullTime) error {
+	_, err := tx.Exec(
+		"INSERT INTO point_lots (user_id, order_number, amount, remaining, expires_at) VALUES ($1, $2, $3, $3, $4)",
+		userID, orderNumber, amount, expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert point lot: %w", err)
+	}
+	return nil
+}
+
+// lotPart is the share of a single lot taken by consumeLots.
+type lotPart struct {
+	expiresAt sql.NullTime
+	amount    money.Amount
+}
+
+// addLots credits points taken from another lot with their original expiry,
+// so moving points around never extends their life.
+func addLots(tx *sql.Tx, userID int, orderNumber string, parts []lotPart) error {
+	for _, part := range parts {
+		if err := addLot(tx, userID, orderNumber, part.amount, part.expiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addWithdrawalLots records which lots the withdrawal took its points from.
+func addWithdrawalLots(tx *sql.Tx, withdrawalID int, parts []lotPart) error {
+	for _, part := range parts {
+		_, err := tx.Exec(
+			"INSERT INTO withdrawal_lots (withdrawal_id, amount, remaining, expires_at) VALUES ($1, $2, $2, $3)",
+			withdrawalID, part.amount, part.expiresAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to insert withdrawal lot: %w", err)
+		}
+	}
+	return nil
+}
+
+// takeWithdrawalLots takes amount out of what is left of the withdrawal's
+// lots, the ones taken last by the withdrawal first, and reports which expiry
+// dates the points had.
+func takeWithdrawalLots(tx *sql.Tx, withdrawalID int, amount money.Amount) ([]lotPart, error) {
+	rows, err := tx.Query(
+		`SELECT id, remaining, expires_at FROM withdrawal_lots WHERE withdrawal_id = $1 AND remaining > 0
+		ORDER BY id DESC FOR UPDATE`,
+		withdrawalID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get withdrawal lots: %w", err)
+	}
+
+	type lot struct {
+		expiresAt sql.NullTime
+		id        int
+		remaining money.Amount
+	}
+	var lots []lot
+	for rows.Next() {
+		var l lot
+		if err := rows.Scan(&l.id, &l.remaining, &l.expiresAt); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("failed to scan withdrawal lot: %w", err)
+		}
+		lots = append(lots, l)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close rows: %w", err)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over withdrawal lots: %w", err)
+	}
+
+	var parts []lotPart
+	for _, l := range lots {
+		if amount == 0 {
+			break
+		}
+		take := min(l.remaining, amount)
+		_, err := tx.Exec("UPDATE withdrawal_lots SET remaining = remaining - $1 WHERE id = $2", take, l.id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update withdrawal lot: %w", err)
+		}
+		parts = append(parts, lotPart{expiresAt: l.expiresAt, amount: take})
+		amount -= take
+	}
+	if amount > 0 {
+		return nil, fmt.Errorf("withdrawal lots are %s short of the refund", amount)
+	}
+	return parts, nil
+}
+
+// consumeLots takes amount out of the user's lots, the ones expiring first
+// before the others, and reports which lots it took the points from.
+func consumeLots(tx *sql.Tx, userID int, amount money.Amount) ([]lotPart, error) {
+	rows, err := tx.Query(
+		`SELECT id, remaining, expires_at FROM point_lots WHERE user_id = $1 AND remaining > 0
+		ORDER BY expires_at NULLS LAST, id FOR UPDATE`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get point lots: %w", err)
+	}
+
+	type lot struct {
+		expiresAt sql.NullTime
+		id        int
+		remaining money.Amount
+	}
+	var lots []lot
+	for rows.Next() {
+		var l lot
+		if err := rows.Scan(&l.id, &l.remaining, &l.expiresAt); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("failed to scan point lot: %w", err)
+		}
+		lots = append(lots, l)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close rows: %w", err)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over point lots: %w", err)
+	}
+
+	var parts []lotPart
+	for _, l := range lots {
+		if amount == 0 {
+			break
+		}
+		take := min(l.remaining, amount)
+		if _, err := tx.Exec("UPDATE point_lots SET remaining = remaining - $1 WHERE id = $2", take, l.id); err != nil {
+			return nil, fmt.Errorf("failed to update point lot: %w", err)
+		}
+		parts = append(parts, lotPart{expiresAt: l.expiresAt, amount: take})
+		amount -= take
+	}
+	if amount > 0 {
+		return nil, ErrInsufficientFunds
+	}
+	return parts, nil
+}
+
+func (s *BalanceStoragePostgres) getExpiringPoints(userID int) ([]ExpiringPoints, error) {
+	rows, err := s.db.Query(
+		`SELECT date_trunc('day', expires_at) AS day, SUM(remaining) FROM point_lots
+		WHERE user_id = $1 AND remaining > 0 AND expires_at < now() + $2 * interval '1 second'
+		GROUP BY day ORDER BY day`,
+		userID, int64(expiringSoonWindow.Seconds()),
+	)
+	if err != nil {
+		s.logger.Error("failed to get expiring points", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.logger.Error("failed to close rows", zap.Error(err))
+		}
+	}()
+
+	var expiring []ExpiringPoints
+	for rows.Next() {
+		var points ExpiringPoints
+		if err := rows.Scan(&points.ExpiresAt, &points.Sum); err != nil {
+			s.logger.Error("failed to scan expiring points", zap.Error(err))
+			return nil, err
+		}
+		expiring = append(expiring, points)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("failed to iterate over rows", zap.Error(err))
+		return nil, err
+	}
+	return expiring, nil
+}
+
+// ExpirePoints writes off whatever is left of expired lots and returns the
+// amount written off. Each user is handled in its own transaction that locks
+// the balance row before the lots, in the same order as Withdraw.
+func (s *BalanceStoragePostgres) ExpirePoints() (money.Amount, error) {
+	rows, err := s.db.Query(
+		"SELECT DISTINCT user_id FROM point_lots WHERE remaining > 0 AND expires_at <= now() LIMIT $1",
+		expireBatchSize,
+	)
+	if err != nil {
+		s.logger.Error("failed to get users with expired points", zap.Error(err))
+		return 0, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.logger.Error("failed to close rows", zap.Error(err))
+		}
+	}()
+
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			s.logger.Error("failed to scan user id", zap.Error(err))
+			return 0, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("failed to iterate over rows", zap.Error(err))
+		return 0, err
+	}
+
+	var total money.Amount
+	for _, userID := range userIDs {
+		expired, err := s.expireUserPoints(userID)
+		if err != nil {
+			return total, err
+		}
+		total += expired
+	}
+	return total, nil
+}
+
+func (s *BalanceStoragePostgres) expireUserPoints(userID int) (money.Amount, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.Error("failed to begin transaction", zap.Error(err))
+		return 0, err
+	}
+
+	if _, err := tx.Exec("SELECT 1 FROM balances WHERE user_id = $1 FOR UPDATE", userID); err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to lock balance", zap.Error(err))
+		return 0, err
+	}
+
+	var expired money.Amount
+	err = tx.QueryRow(
+		`WITH expired AS (
+			SELECT id, remaining FROM point_lots
+			WHERE user_id = $1 AND remaining > 0 AND expires_at <= now() FOR UPDATE
+		), updated AS (
+			UPDATE point_lots SET remaining = 0 FROM expired WHERE point_lots.id = expired.id
+		)
+		SELECT COALESCE(SUM(remaining), 0) FROM expired`,
+		userID,
+	).Scan(&expired)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to expire point lots", zap.Error(err))
+		return 0, err
+	}
+	if expired == 0 {
+		rollback(tx, s.logger)
+		return 0, nil
+	}
+
+	_, err = tx.Exec("UPDATE balances SET current = current - $1 WHERE user_id = $2", expired, userID)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to update balance", zap.Error(err))
+		return 0, err
+	}
+
+	err = postTransaction(tx, OperationExpiration, "", userAccount(userID), systemAccount(accountExpired), expired)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to post ledger transaction", zap.Error(err))
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("failed to commit transaction", zap.Error(err))
+		return 0, err
+	}
+	return expired, nil
+}
@@ -0,0 +1,10 @@
+BEGIN TRANSACTION;
+
+DROP TABLE IF EXISTS ledger_entries;
+DROP TABLE IF EXISTS ledger_transactions;
+DROP TABLE IF EXISTS ledger_accounts;
+DROP FUNCTION IF EXISTS ledger_check_balanced();
+DROP FUNCTION IF EXISTS ledger_forbid_change();
+DROP TYPE IF EXISTS ledger_operation;
+
+COMMIT;
@@ -0,0 +1,118 @@
+BEGIN TRANSACTION;
+
+CREATE TYPE ledger_operation AS ENUM ('OPENING', 'ACCRUAL', 'WITHDRAWAL');
+
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+	id SERIAL PRIMARY KEY,
+	user_id INT UNIQUE,
+	name VARCHAR(50) UNIQUE,
+	created_at TIMESTAMP NOT NULL DEFAULT now(),
+	FOREIGN KEY (user_id) REFERENCES users(id),
+	CHECK ((user_id IS NULL) <> (name IS NULL))
+);
+
+CREATE TABLE IF NOT EXISTS ledger_transactions (
+	id SERIAL PRIMARY KEY,
+	operation ledger_operation NOT NULL,
+	order_number VARCHAR(50),
+	created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+	id SERIAL PRIMARY KEY,
+	transaction_id INT NOT NULL,
+	account_id INT NOT NULL,
+	amount FLOAT NOT NULL,
+	FOREIGN KEY (transaction_id) REFERENCES ledger_transactions(id),
+	FOREIGN KEY (account_id) REFERENCES ledger_accounts(id)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_account_id_idx ON ledger_entries (account_id);
+CREATE INDEX IF NOT EXISTS ledger_transactions_order_number_idx ON ledger_transactions (order_number);
+
+-- Journal rows are never changed: corrections are posted as new transactions.
+CREATE FUNCTION ledger_forbid_change() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'ledger is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_transactions_immutable
+	BEFORE UPDATE OR DELETE ON ledger_transactions
+	FOR EACH ROW EXECUTE FUNCTION ledger_forbid_change();
+
+CREATE TRIGGER ledger_entries_immutable
+	BEFORE UPDATE OR DELETE ON ledger_entries
+	FOR EACH ROW EXECUTE FUNCTION ledger_forbid_change();
+
+-- Every transaction must net to zero once the database transaction commits.
+CREATE FUNCTION ledger_check_balanced() RETURNS trigger AS $$
+BEGIN
+	IF (SELECT SUM(amount) FROM ledger_entries WHERE transaction_id = NEW.transaction_id) <> 0 THEN
+		RAISE EXCEPTION 'ledger transaction % is not balanced', NEW.transaction_id;
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER ledger_entries_balanced
+	AFTER INSERT ON ledger_entries
+	DEFERRABLE INITIALLY DEFERRED
+	FOR EACH ROW EXECUTE FUNCTION ledger_check_balanced();
+
+INSERT INTO ledger_accounts (name) VALUES ('opening'), ('accrual'), ('withdrawal');
+INSERT INTO ledger_accounts (user_id) SELECT id FROM users;
+
+-- Replay the history we still have into the journal. Whatever the old
+-- balances cannot explain is booked as an opening entry.
+DO $$
+DECLARE
+	r RECORD;
+	tx_id INT;
+	opening_account INT;
+	accrual_account INT;
+	withdrawal_account INT;
+BEGIN
+	SELECT id INTO opening_account FROM ledger_accounts WHERE name = 'opening';
+	SELECT id INTO accrual_account FROM ledger_accounts WHERE name = 'accrual';
+	SELECT id INTO withdrawal_account FROM ledger_accounts WHERE name = 'withdrawal';
+
+	FOR r IN
+		SELECT o.number, o.accrual, o.uploaded_at, a.id AS account_id
+		FROM orders o JOIN ledger_accounts a ON a.user_id = o.user_id
+		WHERE o.status = 'PROCESSED' AND o.accrual <> 0
+		ORDER BY o.id
+	LOOP
+		INSERT INTO ledger_transactions (operation, order_number, created_at)
+			VALUES ('ACCRUAL', r.number, r.uploaded_at) RETURNING id INTO tx_id;
+		INSERT INTO ledger_entries (transaction_id, account_id, amount)
+			VALUES (tx_id, accrual_account, -r.accrual), (tx_id, r.account_id, r.accrual);
+	END LOOP;
+
+	FOR r IN
+		SELECT w.order_number, w.sum, w.processed_at, a.id AS account_id
+		FROM withdrawals w JOIN ledger_accounts a ON a.user_id = w.user_id
+		ORDER BY w.id
+	LOOP
+		INSERT INTO ledger_transactions (operation, order_number, created_at)
+			VALUES ('WITHDRAWAL', r.order_number, r.processed_at) RETURNING id INTO tx_id;
+		INSERT INTO ledger_entries (transaction_id, account_id, amount)
+			VALUES (tx_id, r.account_id, -r.sum), (tx_id, withdrawal_account, r.sum);
+	END LOOP;
+
+	FOR r IN
+		SELECT a.id AS account_id, b.current - COALESCE(SUM(e.amount), 0) AS diff
+		FROM balances b
+		JOIN ledger_accounts a ON a.user_id = b.user_id
+		LEFT JOIN ledger_entries e ON e.account_id = a.id
+		GROUP BY a.id, b.current
+		HAVING b.current - COALESCE(SUM(e.amount), 0) <> 0
+	LOOP
+		INSERT INTO ledger_transactions (operation) VALUES ('OPENING') RETURNING id INTO tx_id;
+		INSERT INTO ledger_entries (transaction_id, account_id, amount)
+			VALUES (tx_id, opening_account, -r.diff), (tx_id, r.account_id, r.diff);
+	END LOOP;
+END;
+$$;
+
+COMMIT;
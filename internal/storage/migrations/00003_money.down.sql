@@ -0,0 +1,10 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE ledger_entries ALTER COLUMN amount TYPE FLOAT;
+ALTER TABLE orders ALTER COLUMN accrual TYPE FLOAT;
+ALTER TABLE withdrawals ALTER COLUMN sum TYPE FLOAT;
+ALTER TABLE balances
+	ALTER COLUMN current TYPE FLOAT,
+	ALTER COLUMN withdrawn TYPE FLOAT;
+
+COMMIT;
@@ -0,0 +1,16 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE balances
+	ALTER COLUMN current TYPE NUMERIC(16, 2) USING ROUND(current::NUMERIC, 2),
+	ALTER COLUMN withdrawn TYPE NUMERIC(16, 2) USING ROUND(withdrawn::NUMERIC, 2);
+
+ALTER TABLE withdrawals
+	ALTER COLUMN sum TYPE NUMERIC(16, 2) USING ROUND(sum::NUMERIC, 2);
+
+ALTER TABLE orders
+	ALTER COLUMN accrual TYPE NUMERIC(16, 2) USING ROUND(accrual::NUMERIC, 2);
+
+ALTER TABLE ledger_entries
+	ALTER COLUMN amount TYPE NUMERIC(16, 2) USING ROUND(amount::NUMERIC, 2);
+
+COMMIT;
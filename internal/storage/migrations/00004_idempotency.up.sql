@@ -0,0 +1,16 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	user_id INT NOT NULL,
+	key VARCHAR(255) NOT NULL,
+	request_hash VARCHAR(64) NOT NULL,
+	status INT NOT NULL DEFAULT 0,
+	content_type VARCHAR(255) NOT NULL DEFAULT '',
+	body BYTEA,
+	created_at TIMESTAMP NOT NULL DEFAULT now(),
+	expires_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, key),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+COMMIT;
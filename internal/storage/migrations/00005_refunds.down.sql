@@ -0,0 +1,9 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS withdrawals_user_id_order_number_idx;
+DROP TABLE IF EXISTS withdrawal_refunds;
+ALTER TABLE withdrawals
+	DROP CONSTRAINT IF EXISTS withdrawals_refunded_check,
+	DROP COLUMN IF EXISTS refunded;
+
+COMMIT;
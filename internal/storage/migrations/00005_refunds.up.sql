@@ -0,0 +1,19 @@
+BEGIN TRANSACTION;
+
+ALTER TYPE ledger_operation ADD VALUE IF NOT EXISTS 'REFUND';
+
+ALTER TABLE withdrawals
+	ADD COLUMN IF NOT EXISTS refunded NUMERIC(16, 2) NOT NULL DEFAULT 0,
+	ADD CONSTRAINT withdrawals_refunded_check CHECK (refunded >= 0 AND refunded <= sum);
+
+CREATE TABLE IF NOT EXISTS withdrawal_refunds (
+	id SERIAL PRIMARY KEY,
+	withdrawal_id INT NOT NULL,
+	sum NUMERIC(16, 2) NOT NULL CHECK (sum > 0),
+	processed_at TIMESTAMP NOT NULL DEFAULT now(),
+	FOREIGN KEY (withdrawal_id) REFERENCES withdrawals(id)
+);
+
+CREATE INDEX IF NOT EXISTS withdrawals_user_id_order_number_idx ON withdrawals (user_id, order_number);
+
+COMMIT;
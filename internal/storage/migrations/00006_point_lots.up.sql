@@ -0,0 +1,26 @@
+BEGIN TRANSACTION;
+
+ALTER TYPE ledger_operation ADD VALUE IF NOT EXISTS 'EXPIRATION';
+
+INSERT INTO ledger_accounts (name) VALUES ('expired');
+
+CREATE TABLE IF NOT EXISTS point_lots (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL,
+	order_number VARCHAR(50),
+	amount NUMERIC(16, 2) NOT NULL,
+	remaining NUMERIC(16, 2) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now(),
+	expires_at TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id),
+	CHECK (remaining >= 0 AND remaining <= amount)
+);
+
+CREATE INDEX IF NOT EXISTS point_lots_user_id_idx ON point_lots (user_id, expires_at) WHERE remaining > 0;
+CREATE INDEX IF NOT EXISTS point_lots_expires_at_idx ON point_lots (expires_at) WHERE remaining > 0;
+
+-- Points accrued before lots existed never expire.
+INSERT INTO point_lots (user_id, amount, remaining)
+	SELECT user_id, current, current FROM balances WHERE current > 0;
+
+COMMIT;
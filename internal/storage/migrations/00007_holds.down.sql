@@ -0,0 +1,8 @@
+BEGIN TRANSACTION;
+
+DROP TABLE IF EXISTS hold_lots;
+DROP TABLE IF EXISTS holds;
+DROP TYPE IF EXISTS hold_status;
+ALTER TABLE balances DROP COLUMN IF EXISTS held;
+
+COMMIT;
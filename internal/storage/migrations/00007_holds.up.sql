@@ -0,0 +1,39 @@
+BEGIN TRANSACTION;
+
+ALTER TYPE ledger_operation ADD VALUE IF NOT EXISTS 'HOLD';
+ALTER TYPE ledger_operation ADD VALUE IF NOT EXISTS 'CAPTURE';
+ALTER TYPE ledger_operation ADD VALUE IF NOT EXISTS 'RELEASE';
+
+INSERT INTO ledger_accounts (name) VALUES ('held');
+
+ALTER TABLE balances ADD COLUMN IF NOT EXISTS held NUMERIC(16, 2) NOT NULL DEFAULT 0;
+
+CREATE TYPE hold_status AS ENUM ('HELD', 'CAPTURED', 'RELEASED');
+
+CREATE TABLE IF NOT EXISTS holds (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL,
+	order_number VARCHAR(50) NOT NULL,
+	sum NUMERIC(16, 2) NOT NULL CHECK (sum > 0),
+	status hold_status NOT NULL DEFAULT 'HELD',
+	created_at TIMESTAMP NOT NULL DEFAULT now(),
+	expires_at TIMESTAMP NOT NULL,
+	resolved_at TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS holds_expires_at_idx ON holds (expires_at) WHERE status = 'HELD';
+
+-- Expiry dates of the lots a hold took points from, so that releasing the
+-- hold cannot extend the life of expiring points.
+CREATE TABLE IF NOT EXISTS hold_lots (
+	id SERIAL PRIMARY KEY,
+	hold_id INT NOT NULL,
+	amount NUMERIC(16, 2) NOT NULL,
+	expires_at TIMESTAMP,
+	FOREIGN KEY (hold_id) REFERENCES holds(id)
+);
+
+CREATE INDEX IF NOT EXISTS hold_lots_hold_id_idx ON hold_lots (hold_id);
+
+COMMIT;
@@ -0,0 +1,19 @@
+BEGIN TRANSACTION;
+
+ALTER TYPE ledger_operation ADD VALUE IF NOT EXISTS 'TRANSFER';
+
+CREATE TABLE IF NOT EXISTS transfers (
+	id SERIAL PRIMARY KEY,
+	sender_id INT NOT NULL,
+	recipient_id INT NOT NULL,
+	sum NUMERIC(16, 2) NOT NULL CHECK (sum > 0),
+	processed_at TIMESTAMP NOT NULL DEFAULT now(),
+	FOREIGN KEY (sender_id) REFERENCES users(id),
+	FOREIGN KEY (recipient_id) REFERENCES users(id),
+	CHECK (sender_id <> recipient_id)
+);
+
+CREATE INDEX IF NOT EXISTS transfers_sender_id_idx ON transfers (sender_id, processed_at);
+CREATE INDEX IF NOT EXISTS transfers_recipient_id_idx ON transfers (recipient_id, processed_at);
+
+COMMIT;
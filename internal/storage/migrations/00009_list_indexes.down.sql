@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS withdrawals_user_id_processed_at_idx;
+DROP INDEX IF EXISTS orders_user_id_uploaded_at_idx;
+
+COMMIT;
@@ -0,0 +1,11 @@
+BEGIN TRANSACTION;
+
+DROP TRIGGER IF EXISTS orders_events ON orders;
+DROP FUNCTION IF EXISTS orders_record_event();
+DROP TABLE IF EXISTS order_events;
+
+ALTER TABLE orders
+	DROP COLUMN IF EXISTS last_polled_at,
+	DROP COLUMN IF EXISTS polls;
+
+COMMIT;
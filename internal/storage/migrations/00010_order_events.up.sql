@@ -0,0 +1,46 @@
+BEGIN TRANSACTION;
+
+ALTER TABLE orders
+	ADD COLUMN IF NOT EXISTS polls INT NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS last_polled_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS order_events (
+	id SERIAL PRIMARY KEY,
+	order_id INT NOT NULL,
+	status order_status NOT NULL,
+	accrual NUMERIC(16, 2) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now(),
+	FOREIGN KEY (order_id) REFERENCES orders(id)
+);
+
+CREATE INDEX IF NOT EXISTS order_events_order_id_idx ON order_events (order_id, id);
+
+-- Every insert and every change of status or accrual is recorded, whichever
+-- code path made it.
+CREATE FUNCTION orders_record_event() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'INSERT' OR OLD.status IS DISTINCT FROM NEW.status OR OLD.accrual IS DISTINCT FROM NEW.accrual THEN
+		INSERT INTO order_events (order_id, status, accrual) VALUES (NEW.id, NEW.status, NEW.accrual);
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER orders_events
+	AFTER INSERT OR UPDATE OF status, accrual ON orders
+	FOR EACH ROW EXECUTE FUNCTION orders_record_event();
+
+-- Existing orders only tell when they were uploaded and where they are now.
+-- Processed ones take the time of their accrual from the ledger.
+INSERT INTO order_events (order_id, status, accrual, created_at)
+	SELECT id, 'NEW', 0, uploaded_at FROM orders;
+
+INSERT INTO order_events (order_id, status, accrual, created_at)
+	SELECT o.id, o.status, o.accrual, COALESCE(
+		(SELECT MIN(t.created_at) FROM ledger_transactions t
+		WHERE t.operation = 'ACCRUAL' AND t.order_number = o.number),
+		now()
+	)
+	FROM orders o WHERE o.status <> 'NEW';
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN TRANSACTION;
+
+DROP TRIGGER IF EXISTS balances_notify ON balances;
+DROP FUNCTION IF EXISTS balances_notify();
+DROP TRIGGER IF EXISTS orders_notify ON orders;
+DROP FUNCTION IF EXISTS orders_notify();
+
+COMMIT;
@@ -0,0 +1,51 @@
+BEGIN TRANSACTION;
+
+-- Changes users should see right away are published on the user_events
+-- channel. Notifications are delivered when the transaction commits, so
+-- listeners never see rolled back changes.
+CREATE FUNCTION orders_notify() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'INSERT' OR OLD.status IS DISTINCT FROM NEW.status OR OLD.accrual IS DISTINCT FROM NEW.accrual THEN
+		PERFORM pg_notify('user_events', json_build_object(
+			'user_id', NEW.user_id,
+			'type', 'order',
+			'data', json_build_object(
+				'number', NEW.number,
+				'status', NEW.status,
+				'accrual', NEW.accrual,
+				'uploaded_at', NEW.uploaded_at
+			)
+		)::text);
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER orders_notify
+	AFTER INSERT OR UPDATE OF status, accrual ON orders
+	FOR EACH ROW EXECUTE FUNCTION orders_notify();
+
+CREATE FUNCTION balances_notify() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('user_events', json_build_object(
+		'user_id', NEW.user_id,
+		'type', 'balance',
+		'data', json_build_object(
+			'current', NEW.current,
+			'withdrawn', NEW.withdrawn,
+			'held', NEW.held
+		)
+	)::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER balances_notify
+	AFTER UPDATE ON balances
+	FOR EACH ROW
+	WHEN (OLD.current IS DISTINCT FROM NEW.current
+		OR OLD.withdrawn IS DISTINCT FROM NEW.withdrawn
+		OR OLD.held IS DISTINCT FROM NEW.held)
+	EXECUTE FUNCTION balances_notify();
+
+COMMIT;
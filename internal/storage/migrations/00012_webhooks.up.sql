@@ -0,0 +1,37 @@
+BEGIN TRANSACTION;
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL,
+	url TEXT NOT NULL,
+	secret VARCHAR(64) NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT now(),
+	FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id);
+
+CREATE TYPE webhook_delivery_status AS ENUM ('PENDING', 'DELIVERED', 'FAILED');
+
+-- Deliveries are queued in the transaction that makes the change, so an
+-- event is never lost nor sent for a change that was rolled back.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id SERIAL PRIMARY KEY,
+	webhook_id INT NOT NULL,
+	event VARCHAR(50) NOT NULL,
+	payload JSONB NOT NULL,
+	status webhook_delivery_status NOT NULL DEFAULT 'PENDING',
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL DEFAULT now(),
+	last_status_code INT,
+	last_error TEXT,
+	created_at TIMESTAMP NOT NULL DEFAULT now(),
+	delivered_at TIMESTAMP,
+	FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at)
+	WHERE status = 'PENDING';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, id);
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+DROP TRIGGER IF EXISTS orders_notify_new ON orders;
+DROP FUNCTION IF EXISTS orders_notify_new();
+
+COMMIT;
@@ -0,0 +1,16 @@
+BEGIN TRANSACTION;
+
+-- Wakes the scheduler up as soon as orders are uploaded. The trigger fires
+-- once per statement, so a batch upload sends a single notification.
+CREATE FUNCTION orders_notify_new() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('new_orders', '');
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER orders_notify_new
+	AFTER INSERT ON orders
+	FOR EACH STATEMENT EXECUTE FUNCTION orders_notify_new();
+
+COMMIT;
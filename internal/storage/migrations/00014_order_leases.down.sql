@@ -0,0 +1,9 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS ledger_transactions_accrual_idx;
+DROP INDEX IF EXISTS orders_pending_idx;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS locked_until;
+ALTER TABLE orders DROP COLUMN IF EXISTS locked_by;
+
+COMMIT;
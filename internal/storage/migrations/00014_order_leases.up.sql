@@ -0,0 +1,34 @@
+BEGIN TRANSACTION;
+
+-- A scheduler leases the pending orders it checks, so several schedulers
+-- never poll the same order at once. Expired leases can be claimed again.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS locked_by VARCHAR(255);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS orders_pending_idx ON orders (uploaded_at)
+	WHERE status IN ('NEW', 'PROCESSING');
+
+-- Orders accrued twice before cannot be corrected here: the ledger is
+-- append-only and the extra points may have been spent since. Stop with the
+-- order numbers instead of failing on the index below.
+DO $$
+DECLARE
+	duplicated TEXT;
+BEGIN
+	SELECT string_agg(order_number, ', ' ORDER BY order_number) INTO duplicated FROM (
+		SELECT order_number FROM ledger_transactions WHERE operation = 'ACCRUAL'
+		GROUP BY order_number HAVING COUNT(*) > 1
+		ORDER BY order_number LIMIT 100
+	) d;
+	IF duplicated IS NOT NULL THEN
+		RAISE EXCEPTION 'orders accrued more than once: %', duplicated
+			USING HINT = 'Correct the balances and ledger of these orders by hand, '
+				'then force the migration version back to 13 and migrate again.';
+	END IF;
+END $$;
+
+-- An order is accrued at most once, whatever the schedulers do.
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_transactions_accrual_idx ON ledger_transactions (order_number)
+	WHERE operation = 'ACCRUAL';
+
+COMMIT;
@@ -0,0 +1,14 @@
+BEGIN TRANSACTION;
+
+-- Enum values cannot be dropped, stuck orders go back to the queue instead.
+UPDATE orders SET status = 'PROCESSING' WHERE status = 'STUCK';
+
+DROP INDEX IF EXISTS orders_pending_idx;
+CREATE INDEX IF NOT EXISTS orders_pending_idx ON orders (uploaded_at)
+	WHERE status IN ('NEW', 'PROCESSING');
+
+ALTER TABLE orders
+	DROP COLUMN IF EXISTS attempts,
+	DROP COLUMN IF EXISTS next_check_at;
+
+COMMIT;
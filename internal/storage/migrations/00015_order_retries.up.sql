@@ -0,0 +1,15 @@
+BEGIN TRANSACTION;
+
+-- Orders the accrual system keeps failing on are retried with backoff and
+-- set aside as STUCK once they run out of attempts.
+ALTER TYPE order_status ADD VALUE IF NOT EXISTS 'STUCK';
+
+ALTER TABLE orders
+	ADD COLUMN IF NOT EXISTS next_check_at TIMESTAMP NOT NULL DEFAULT now(),
+	ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0;
+
+DROP INDEX IF EXISTS orders_pending_idx;
+CREATE INDEX IF NOT EXISTS orders_pending_idx ON orders (next_check_at)
+	WHERE status IN ('NEW', 'PROCESSING');
+
+COMMIT;
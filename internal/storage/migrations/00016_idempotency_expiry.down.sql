@@ -0,0 +1,5 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS idempotency_keys_expires_at_idx;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN TRANSACTION;
+
+DROP INDEX IF EXISTS withdrawal_refunds_refund_id_idx;
+ALTER TABLE withdrawal_refunds DROP COLUMN IF EXISTS refund_id;
+
+COMMIT;
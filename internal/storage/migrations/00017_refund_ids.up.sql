@@ -0,0 +1,9 @@
+BEGIN TRANSACTION;
+
+-- Refunds are identified by the caller, so that retrying one does not credit
+-- the points again. Refunds made before have no id.
+ALTER TABLE withdrawal_refunds ADD COLUMN IF NOT EXISTS refund_id VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS withdrawal_refunds_refund_id_idx ON withdrawal_refunds (refund_id);
+
+COMMIT;
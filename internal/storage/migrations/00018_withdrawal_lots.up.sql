@@ -0,0 +1,21 @@
+BEGIN TRANSACTION;
+
+-- Expiry dates of the lots a withdrawal took points from, so that refunding
+-- the withdrawal cannot extend the life of expiring points.
+CREATE TABLE IF NOT EXISTS withdrawal_lots (
+	id SERIAL PRIMARY KEY,
+	withdrawal_id INT NOT NULL,
+	amount NUMERIC(16, 2) NOT NULL,
+	remaining NUMERIC(16, 2) NOT NULL,
+	expires_at TIMESTAMP,
+	FOREIGN KEY (withdrawal_id) REFERENCES withdrawals(id),
+	CHECK (remaining >= 0 AND remaining <= amount)
+);
+
+CREATE INDEX IF NOT EXISTS withdrawal_lots_withdrawal_id_idx ON withdrawal_lots (withdrawal_id);
+
+-- Points withdrawn before are refunded as they were so far, never expiring.
+INSERT INTO withdrawal_lots (withdrawal_id, amount, remaining)
+	SELECT id, sum - refunded, sum - refunded FROM withdrawals WHERE sum > refunded;
+
+COMMIT;
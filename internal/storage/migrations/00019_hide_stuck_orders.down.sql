@@ -0,0 +1,21 @@
+BEGIN TRANSACTION;
+
+CREATE OR REPLACE FUNCTION orders_notify() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP = 'INSERT' OR OLD.status IS DISTINCT FROM NEW.status OR OLD.accrual IS DISTINCT FROM NEW.accrual THEN
+		PERFORM pg_notify('user_events', json_build_object(
+			'user_id', NEW.user_id,
+			'type', 'order',
+			'data', json_build_object(
+				'number', NEW.number,
+				'status', NEW.status,
+				'accrual', NEW.accrual,
+				'uploaded_at', NEW.uploaded_at
+			)
+		)::text);
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMIT;
@@ -0,0 +1,31 @@
+BEGIN TRANSACTION;
+
+-- Stuck orders only matter to admins, users see them as still being
+-- processed. Marking an order stuck or requeueing it is not published.
+CREATE OR REPLACE FUNCTION orders_notify() RETURNS trigger AS $$
+DECLARE
+	old_status TEXT;
+	new_status TEXT;
+BEGIN
+	new_status := CASE WHEN NEW.status = 'STUCK' THEN 'PROCESSING' ELSE NEW.status::text END;
+	IF TG_OP = 'UPDATE' THEN
+		old_status := CASE WHEN OLD.status = 'STUCK' THEN 'PROCESSING' ELSE OLD.status::text END;
+	END IF;
+
+	IF TG_OP = 'INSERT' OR old_status IS DISTINCT FROM new_status OR OLD.accrual IS DISTINCT FROM NEW.accrual THEN
+		PERFORM pg_notify('user_events', json_build_object(
+			'user_id', NEW.user_id,
+			'type', 'order',
+			'data', json_build_object(
+				'number', NEW.number,
+				'status', new_status,
+				'accrual', NEW.accrual,
+				'uploaded_at', NEW.uploaded_at
+			)
+		)::text);
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMIT;
@@ -2,9 +2,12 @@ package storage
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/krasvl/market/internal/money"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 )
 
@@ -15,34 +18,86 @@ const (
 	StatusProcessing OrderStatus = "PROCESSING"
 	StatusInvalid    OrderStatus = "INVALID"
 	StatusProcessed  OrderStatus = "PROCESSED"
+	// StatusStuck is set on orders the accrual system failed on too many
+	// times. They are not checked until requeued.
+	StatusStuck OrderStatus = "STUCK"
 )
 
+// NewOrdersChannel is notified whenever orders are uploaded, so the scheduler
+// can check them right away.
+const NewOrdersChannel = "new_orders"
+
 type Order struct {
 	UploadedAt time.Time
-	Number     string
-	Status     OrderStatus
-	ID         int
-	UserID     int
-	Accrual    float64
+	// LastPolledAt is when the accrual system was last asked about the
+	// order, zero if it never was.
+	LastPolledAt time.Time
+	Number       string
+	Status       OrderStatus
+	// Events is the timeline of the order, filled by GetOrder only.
+	Events []OrderEvent
+	ID     int
+	UserID int
+	Polls  int
+	// Attempts is the number of checks in a row that failed.
+	Attempts int
+	Accrual  money.Amount
+}
+
+// OrderEvent is a status or accrual change of an order.
+type OrderEvent struct {
+	CreatedAt time.Time
+	Status    OrderStatus
+	Accrual   money.Amount
 }
 
+var (
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrOrderFinal is returned when an order that already reached a final
+	// status is processed again, for example by a scheduler whose lease had
+	// expired and was taken over.
+	ErrOrderFinal = errors.New("order is already final")
+)
+
+// UploadResult tells what happened to a number submitted in a batch.
+type UploadResult string
+
+const (
+	UploadAccepted        UploadResult = "ACCEPTED"
+	UploadAlreadyUploaded UploadResult = "ALREADY_UPLOADED"
+	UploadConflict        UploadResult = "OWNED_BY_ANOTHER_USER"
+	UploadInvalid         UploadResult = "INVALID"
+)
+
 type OrderStorage interface {
 	AddOrder(order *Order) error
+	AddOrders(userID int, numbers []string) (map[string]UploadResult, error)
 	GetOrderHolder(order string) (int, bool, error)
-	GetOrders(userID int) ([]Order, error)
-	GetPendingOrders() ([]Order, error)
+	GetOrders(userID int, opts ListOptions) ([]Order, string, error)
+	GetOrder(userID int, number string) (Order, error)
+	ClaimPendingOrders(workerID string, limit int, lease time.Duration) ([]Order, error)
 	ProcessOrder(order *Order) error
 }
 
 type OrderStoragePostgres struct {
 	logger *zap.Logger
 	db     *sql.DB
+	// pollInterval is how long a pending order waits before it is checked
+	// again.
+	pollInterval time.Duration
+	// pointsLifetime is the number of months accrued points stay spendable,
+	// zero means they never expire.
+	pointsLifetime int
 }
 
-func NewOrderStorage(db *sql.DB, logger *zap.Logger) (*OrderStoragePostgres, error) {
+func NewOrderStorage(
+	db *sql.DB, logger *zap.Logger, pointsLifetime int, pollInterval time.Duration,
+) (*OrderStoragePostgres, error) {
 	return &OrderStoragePostgres{
-		logger: logger,
-		db:     db,
+		logger:         logger,
+		db:             db,
+		pollInterval:   pollInterval,
+		pointsLifetime: pointsLifetime,
 	}, nil
 }
 
@@ -74,14 +129,130 @@ func (s *OrderStoragePostgres) AddOrder(order *Order) error {
 	return nil
 }
 
-func (s *OrderStoragePostgres) GetOrders(userID int) ([]Order, error) {
-	rows, err := s.db.Query(
-		"SELECT id, user_id, number, status, accrual, uploaded_at FROM orders WHERE user_id = $1 ORDER BY uploaded_at DESC",
-		userID,
+// AddOrders adds the numbers nobody has uploaded yet as new orders of the user
+// and reports the outcome for every number. Conflicting inserts are skipped by
+// the database, so concurrent uploads of the same number cannot both succeed.
+func (s *OrderStoragePostgres) AddOrders(userID int, numbers []string) (map[string]UploadResult, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.Error("failed to begin transaction", zap.Error(err))
+		return nil, err
+	}
+
+	results, err := insertOrders(tx, userID, numbers)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to add orders", zap.Error(err))
+		return nil, err
+	}
+
+	var existing []string
+	for _, number := range numbers {
+		if _, ok := results[number]; !ok {
+			existing = append(existing, number)
+		}
+	}
+	if len(existing) > 0 {
+		holders, err := getOrderHolders(tx, existing)
+		if err != nil {
+			rollback(tx, s.logger)
+			s.logger.Error("failed to get order holders", zap.Error(err))
+			return nil, err
+		}
+		for number, holderID := range holders {
+			if holderID == userID {
+				results[number] = UploadAlreadyUploaded
+			} else {
+				results[number] = UploadConflict
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("failed to commit transaction", zap.Error(err))
+		return nil, err
+	}
+	return results, nil
+}
+
+func insertOrders(tx *sql.Tx, userID int, numbers []string) (map[string]UploadResult, error) {
+	rows, err := tx.Query(
+		`INSERT INTO orders (user_id, number, status, accrual)
+		SELECT $1, number, 'NEW', 0 FROM unnest($2::varchar[]) AS number
+		ON CONFLICT (number) DO NOTHING
+		RETURNING number`,
+		userID, pq.Array(numbers),
 	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert orders: %w", err)
+	}
+
+	results := make(map[string]UploadResult, len(numbers))
+	for rows.Next() {
+		var number string
+		if err := rows.Scan(&number); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("failed to scan order number: %w", err)
+		}
+		results[number] = UploadAccepted
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close rows: %w", err)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over orders: %w", err)
+	}
+	return results, nil
+}
+
+func getOrderHolders(tx *sql.Tx, numbers []string) (map[string]int, error) {
+	rows, err := tx.Query("SELECT number, user_id FROM orders WHERE number = ANY($1)", pq.Array(numbers))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query order holders: %w", err)
+	}
+
+	holders := make(map[string]int, len(numbers))
+	for rows.Next() {
+		var number string
+		var holderID int
+		if err := rows.Scan(&number, &holderID); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("failed to scan order holder: %w", err)
+		}
+		holders[number] = holderID
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close rows: %w", err)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate over order holders: %w", err)
+	}
+	return holders, nil
+}
+
+// GetOrders returns a page of the user's orders and the cursor of the next
+// page, empty if there is none.
+func (s *OrderStoragePostgres) GetOrders(userID int, opts ListOptions) ([]Order, string, error) {
+	query := "SELECT id, user_id, number, status, accrual, uploaded_at FROM orders WHERE user_id = $1"
+	args := []any{userID}
+	switch opts.Status {
+	case "":
+	case StatusProcessing:
+		// Users see stuck orders as still being processed.
+		query += " AND status IN ('PROCESSING', 'STUCK')"
+	default:
+		args = append(args, opts.Status)
+		query += " AND status = $2"
+	}
+	query, args, err := pageQuery(query, args, "uploaded_at", opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		s.logger.Error("failed to get orders", zap.Error(err))
-		return nil, err
+		return nil, "", err
 	}
 	defer func() {
 		if err := rows.Close(); err != nil {
@@ -95,22 +266,95 @@ func (s *OrderStoragePostgres) GetOrders(userID int) ([]Order, error) {
 		if err := rows.Scan(&order.ID, &order.UserID, &order.Number, &order.Status, &order.Accrual,
 			&order.UploadedAt); err != nil {
 			s.logger.Error("failed to scan order", zap.Error(err))
-			return nil, err
+			return nil, "", err
 		}
 		orders = append(orders, order)
 	}
 	if err := rows.Err(); err != nil {
 		s.logger.Error("rows error", zap.Error(err))
-		return nil, err
+		return nil, "", err
 	}
-	return orders, nil
+
+	orders, cursor := nextPage(orders, opts.Limit, func(o Order) (time.Time, int) { return o.UploadedAt, o.ID })
+	return orders, cursor, nil
+}
+
+// GetOrder returns the user's order with its timeline, oldest event first.
+func (s *OrderStoragePostgres) GetOrder(userID int, number string) (Order, error) {
+	var order Order
+	var lastPolledAt sql.NullTime
+	err := s.db.QueryRow(
+		`SELECT id, user_id, number, status, accrual, uploaded_at, polls, last_polled_at
+		FROM orders WHERE user_id = $1 AND number = $2`,
+		userID, number,
+	).Scan(&order.ID, &order.UserID, &order.Number, &order.Status, &order.Accrual, &order.UploadedAt,
+		&order.Polls, &lastPolledAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Order{}, ErrOrderNotFound
+	}
+	if err != nil {
+		s.logger.Error("failed to get order", zap.Error(err))
+		return Order{}, err
+	}
+	order.LastPolledAt = lastPolledAt.Time
+
+	rows, err := s.db.Query(
+		"SELECT status, accrual, created_at FROM order_events WHERE order_id = $1 ORDER BY id",
+		order.ID,
+	)
+	if err != nil {
+		s.logger.Error("failed to get order events", zap.Error(err))
+		return Order{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.logger.Error("failed to close rows", zap.Error(err))
+		}
+	}()
+
+	for rows.Next() {
+		var event OrderEvent
+		if err := rows.Scan(&event.Status, &event.Accrual, &event.CreatedAt); err != nil {
+			s.logger.Error("failed to scan order event", zap.Error(err))
+			return Order{}, err
+		}
+		order.Events = append(order.Events, event)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("failed to iterate over rows", zap.Error(err))
+		return Order{}, err
+	}
+	return order, nil
 }
 
-func (s *OrderStoragePostgres) GetPendingOrders() ([]Order, error) {
+// RecordPoll counts a request made to the accrual system about the order.
+func (s *OrderStoragePostgres) RecordPoll(orderID int) error {
+	_, err := s.db.Exec("UPDATE orders SET polls = polls + 1, last_polled_at = now() WHERE id = $1", orderID)
+	if err != nil {
+		s.logger.Error("failed to record poll", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// ClaimPendingOrders leases up to limit pending orders that are due for a
+// check to the worker. Orders leased to other workers are skipped until their
+// lease expires, so any number of schedulers can split the work.
+func (s *OrderStoragePostgres) ClaimPendingOrders(workerID string, limit int, lease time.Duration) ([]Order, error) {
 	rows, err := s.db.Query(
-		"SELECT id, user_id, number, status, uploaded_at FROM orders WHERE status IN ('NEW', 'PROCESSING')",
+		`UPDATE orders SET locked_by = $1, locked_until = now() + $3 * interval '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM orders
+			WHERE status IN ('NEW', 'PROCESSING') AND next_check_at <= now()
+				AND (locked_until IS NULL OR locked_until < now())
+			ORDER BY next_check_at LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, number, status, uploaded_at, attempts`,
+		workerID, limit, lease.Milliseconds(),
 	)
 	if err != nil {
+		s.logger.Error("failed to claim pending orders", zap.Error(err))
 		return nil, err
 	}
 	defer func() {
@@ -122,17 +366,107 @@ func (s *OrderStoragePostgres) GetPendingOrders() ([]Order, error) {
 	var orders []Order
 	for rows.Next() {
 		var order Order
-		if err := rows.Scan(&order.ID, &order.UserID, &order.Number, &order.Status, &order.UploadedAt); err != nil {
+		if err := rows.Scan(
+			&order.ID, &order.UserID, &order.Number, &order.Status, &order.UploadedAt, &order.Attempts,
+		); err != nil {
+			s.logger.Error("failed to scan order", zap.Error(err))
 			return nil, err
 		}
 		orders = append(orders, order)
 	}
 	if err := rows.Err(); err != nil {
+		s.logger.Error("failed to iterate over rows", zap.Error(err))
 		return nil, err
 	}
 	return orders, nil
 }
 
+// ReleaseOrders gives up the leases the worker holds, so other workers can
+// check the orders right away instead of waiting for the leases to expire.
+func (s *OrderStoragePostgres) ReleaseOrders(workerID string) (int, error) {
+	res, err := s.db.Exec(
+		"UPDATE orders SET locked_by = NULL, locked_until = NULL WHERE locked_by = $1 AND locked_until >= now()",
+		workerID,
+	)
+	if err != nil {
+		s.logger.Error("failed to release orders", zap.Error(err))
+		return 0, err
+	}
+	released, err := res.RowsAffected()
+	if err != nil {
+		s.logger.Error("failed to get affected rows", zap.Error(err))
+		return 0, err
+	}
+	return int(released), nil
+}
+
+// RetryOrder counts a failed check and postpones the next one by delay.
+func (s *OrderStoragePostgres) RetryOrder(orderID int, delay time.Duration) error {
+	return s.failCheck(
+		`UPDATE orders SET attempts = attempts + 1, next_check_at = now() + $2 * interval '1 millisecond',
+			locked_by = NULL, locked_until = NULL
+		WHERE id = $1 AND status IN ('NEW', 'PROCESSING')`,
+		orderID, delay.Milliseconds(),
+	)
+}
+
+// MarkOrderStuck counts a failed check and stops checking the order until
+// it is requeued.
+func (s *OrderStoragePostgres) MarkOrderStuck(orderID int) error {
+	return s.failCheck(
+		`UPDATE orders SET status = 'STUCK', attempts = attempts + 1, locked_by = NULL, locked_until = NULL
+		WHERE id = $1 AND status IN ('NEW', 'PROCESSING')`,
+		orderID,
+	)
+}
+
+func (s *OrderStoragePostgres) failCheck(query string, args ...any) error {
+	res, err := s.db.Exec(query, args...)
+	if err != nil {
+		s.logger.Error("failed to record failed check", zap.Error(err))
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		s.logger.Error("failed to get affected rows", zap.Error(err))
+		return err
+	}
+	if updated == 0 {
+		return ErrOrderFinal
+	}
+	return nil
+}
+
+// RequeueStuckOrders puts stuck orders back to the status they had before
+// and makes them due right away. It requeues every stuck order if numbers is
+// empty, and returns how many orders were requeued.
+func (s *OrderStoragePostgres) RequeueStuckOrders(numbers []string) (int, error) {
+	res, err := s.db.Exec(
+		`UPDATE orders o SET attempts = 0, next_check_at = now(),
+			status = COALESCE((
+				SELECT e.status FROM order_events e
+				WHERE e.order_id = o.id AND e.status <> 'STUCK'
+				ORDER BY e.id DESC LIMIT 1
+			), 'NEW')
+		WHERE o.status = 'STUCK' AND (COALESCE(cardinality($1::text[]), 0) = 0 OR o.number = ANY($1))`,
+		pq.Array(numbers),
+	)
+	if err != nil {
+		s.logger.Error("failed to requeue stuck orders", zap.Error(err))
+		return 0, err
+	}
+	requeued, err := res.RowsAffected()
+	if err != nil {
+		s.logger.Error("failed to get affected rows", zap.Error(err))
+		return 0, err
+	}
+	return int(requeued), nil
+}
+
+// ProcessOrder stores the status reported by the accrual system, resets the
+// failed attempts and releases the lease on the order. Only pending orders
+// are updated, so an order is never credited twice; processing a final order
+// returns ErrOrderFinal.
 func (s *OrderStoragePostgres) ProcessOrder(order *Order) error {
 	tx, err := s.db.Begin()
 	if err != nil {
@@ -140,24 +474,66 @@ func (s *OrderStoragePostgres) ProcessOrder(order *Order) error {
 		return err
 	}
 
-	_, err = tx.Exec("UPDATE orders SET status = $1, accrual = $2 WHERE id = $3", order.Status, order.Accrual, order.ID)
+	res, err := tx.Exec(
+		`UPDATE orders SET status = $1, accrual = $2, attempts = 0,
+			next_check_at = now() + $4 * interval '1 millisecond', locked_by = NULL, locked_until = NULL
+		WHERE id = $3 AND status IN ('NEW', 'PROCESSING')`,
+		order.Status, order.Accrual, order.ID, s.pollInterval.Milliseconds(),
+	)
 	if err != nil {
-		if err := tx.Rollback(); err != nil {
-			s.logger.Error("failed to rollback transaction", zap.Error(err))
-		}
+		rollback(tx, s.logger)
 		s.logger.Error("failed to update status", zap.Error(err))
 		return err
 	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to get affected rows", zap.Error(err))
+		return err
+	}
+	if updated == 0 {
+		rollback(tx, s.logger)
+		return ErrOrderFinal
+	}
 
 	if order.Status == StatusProcessed {
 		_, err = tx.Exec("UPDATE balances SET current = current + $1 WHERE user_id = $2", order.Accrual, order.UserID)
 		if err != nil {
-			if err := tx.Rollback(); err != nil {
-				s.logger.Error("failed to rollback transaction", zap.Error(err))
-			}
+			rollback(tx, s.logger)
 			s.logger.Error("failed to update balance", zap.Error(err))
 			return err
 		}
+
+		err = postTransaction(tx, OperationAccrual, order.Number,
+			systemAccount(accountAccrual), userAccount(order.UserID), order.Accrual)
+		if err != nil {
+			rollback(tx, s.logger)
+			s.logger.Error("failed to post ledger transaction", zap.Error(err))
+			return err
+		}
+
+		var expiresAt sql.NullTime
+		if s.pointsLifetime > 0 {
+			expiresAt = sql.NullTime{Time: time.Now().AddDate(0, s.pointsLifetime, 0), Valid: true}
+		}
+		if err := addLot(tx, order.UserID, order.Number, order.Accrual, expiresAt); err != nil {
+			rollback(tx, s.logger)
+			s.logger.Error("failed to add point lot", zap.Error(err))
+			return err
+		}
+	}
+
+	if event, ok := orderEvents[order.Status]; ok {
+		err := enqueueWebhookEvent(tx, order.UserID, event, orderEventData{
+			Number:  order.Number,
+			Status:  order.Status,
+			Accrual: order.Accrual,
+		})
+		if err != nil {
+			rollback(tx, s.logger)
+			s.logger.Error("failed to enqueue webhook event", zap.Error(err))
+			return err
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
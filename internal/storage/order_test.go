@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestAddOrders(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+	otherID := testUser(t, db)
+
+	orders, err := NewOrderStorage(db, zap.NewNop(), 0, 10*time.Second)
+	require.NoError(t, err)
+
+	mine, theirs, fresh := testOrderNumber(), testOrderNumber(), testOrderNumber()
+	require.NoError(t, orders.AddOrder(&Order{UserID: userID, Number: mine, Status: StatusNew}))
+	require.NoError(t, orders.AddOrder(&Order{UserID: otherID, Number: theirs, Status: StatusNew}))
+
+	results, err := orders.AddOrders(userID, []string{mine, theirs, fresh})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]UploadResult{
+		mine:   UploadAlreadyUploaded,
+		theirs: UploadConflict,
+		fresh:  UploadAccepted,
+	}, results)
+
+	holderID, ok, err := orders.GetOrderHolder(fresh)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, userID, holderID)
+}
+
+func TestGetOrder(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	orders, err := NewOrderStorage(db, zap.NewNop(), 0, 10*time.Second)
+	require.NoError(t, err)
+
+	order := Order{UserID: userID, Number: testOrderNumber(), Status: StatusNew}
+	require.NoError(t, orders.AddOrder(&order))
+	require.NoError(t, db.QueryRow("SELECT id FROM orders WHERE number = $1", order.Number).Scan(&order.ID))
+
+	require.NoError(t, orders.RecordPoll(order.ID))
+	order.Status = StatusProcessing
+	require.NoError(t, orders.ProcessOrder(&order))
+	// Polling without a change leaves the timeline as it is.
+	require.NoError(t, orders.RecordPoll(order.ID))
+	require.NoError(t, orders.ProcessOrder(&order))
+	order.Status = StatusProcessed
+	order.Accrual = money.FromPoints(500)
+	require.NoError(t, orders.ProcessOrder(&order))
+
+	got, err := orders.GetOrder(userID, order.Number)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Polls)
+	assert.False(t, got.LastPolledAt.IsZero())
+	require.Len(t, got.Events, 3)
+	assert.Equal(t, StatusNew, got.Events[0].Status)
+	assert.Equal(t, StatusProcessing, got.Events[1].Status)
+	assert.Equal(t, StatusProcessed, got.Events[2].Status)
+	assert.Equal(t, money.FromPoints(500), got.Events[2].Accrual)
+
+	_, err = orders.GetOrder(userID+1, order.Number)
+	assert.ErrorIs(t, err, ErrOrderNotFound)
+}
+
+func TestClaimPendingOrders(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	orders, err := NewOrderStorage(db, zap.NewNop(), 0, 10*time.Second)
+	require.NoError(t, err)
+
+	order := Order{UserID: userID, Number: testOrderNumber(), Status: StatusNew}
+	require.NoError(t, orders.AddOrder(&order))
+
+	claim := func(workerID string, lease time.Duration) *Order {
+		claimed, err := orders.ClaimPendingOrders(workerID, 1000, lease)
+		require.NoError(t, err)
+		for _, o := range claimed {
+			if o.Number == order.Number {
+				return &o
+			}
+		}
+		return nil
+	}
+
+	t.Run("Leased Once", func(t *testing.T) {
+		claimed := claim("first", -time.Second)
+		require.NotNil(t, claimed)
+		order = *claimed
+		assert.Equal(t, userID, order.UserID)
+		assert.Equal(t, StatusNew, order.Status)
+
+		// The lease above has already expired, so the order can be taken over.
+		require.NotNil(t, claim("second", time.Minute))
+		assert.Nil(t, claim("first", time.Minute))
+	})
+
+	t.Run("Released", func(t *testing.T) {
+		released, err := orders.ReleaseOrders("second")
+		require.NoError(t, err)
+		assert.Positive(t, released)
+		require.NotNil(t, claim("first", time.Minute))
+	})
+
+	t.Run("Credited Once", func(t *testing.T) {
+		order.Status = StatusProcessed
+		order.Accrual = money.FromPoints(100)
+		require.NoError(t, orders.ProcessOrder(&order))
+		assert.ErrorIs(t, orders.ProcessOrder(&order), ErrOrderFinal)
+		assert.Nil(t, claim("first", time.Minute))
+
+		var current money.Amount
+		require.NoError(t, db.QueryRow("SELECT current FROM balances WHERE user_id = $1", userID).Scan(&current))
+		assert.Equal(t, money.FromPoints(100), current)
+	})
+}
+
+func TestStuckOrders(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+
+	orders, err := NewOrderStorage(db, zap.NewNop(), 0, 10*time.Second)
+	require.NoError(t, err)
+
+	order := Order{UserID: userID, Number: testOrderNumber(), Status: StatusNew}
+	require.NoError(t, orders.AddOrder(&order))
+	require.NoError(t, db.QueryRow("SELECT id FROM orders WHERE number = $1", order.Number).Scan(&order.ID))
+
+	due := func() *Order {
+		claimed, err := orders.ClaimPendingOrders("test", 1000, -time.Second)
+		require.NoError(t, err)
+		for _, o := range claimed {
+			if o.ID == order.ID {
+				return &o
+			}
+		}
+		return nil
+	}
+
+	order.Status = StatusProcessing
+	require.NoError(t, orders.ProcessOrder(&order))
+	// A checked order waits for the poll interval.
+	assert.Nil(t, due())
+
+	require.NoError(t, orders.RetryOrder(order.ID, -time.Second))
+	claimed := due()
+	require.NotNil(t, claimed)
+	assert.Equal(t, 1, claimed.Attempts)
+
+	require.NoError(t, orders.MarkOrderStuck(order.ID))
+	assert.Nil(t, due())
+	assert.ErrorIs(t, orders.RetryOrder(order.ID, 0), ErrOrderFinal)
+
+	got, err := orders.GetOrder(userID, order.Number)
+	require.NoError(t, err)
+	assert.Equal(t, StatusStuck, got.Status)
+
+	requeued, err := orders.RequeueStuckOrders([]string{order.Number})
+	require.NoError(t, err)
+	assert.Equal(t, 1, requeued)
+
+	claimed = due()
+	require.NotNil(t, claimed)
+	assert.Equal(t, StatusProcessing, claimed.Status)
+	assert.Zero(t, claimed.Attempts)
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions narrows and pages a listing. The zero value lists everything,
+// newest first.
+type ListOptions struct {
+	// From and To bound the listing to [From, To), zero leaves a side open.
+	From time.Time
+	To   time.Time
+	// Cursor continues a listing after the last row of the previous page.
+	Cursor string
+	// Status filters orders by status, other listings ignore it.
+	Status OrderStatus
+	// Limit caps the page size, zero means no limit.
+	Limit     int
+	Ascending bool
+}
+
+// pageQuery appends the time range, the cursor position, the order and the
+// limit of opts to query. Rows are ordered by timeColumn and then by id,
+// which is also what the cursor points at. One extra row is requested so that
+// nextPage can tell whether there is anything after the page.
+func pageQuery(query string, args []any, timeColumn string, opts ListOptions) (string, []any, error) {
+	var b strings.Builder
+	b.WriteString(query)
+
+	arg := func(value any) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if !opts.From.IsZero() {
+		fmt.Fprintf(&b, " AND %s >= %s", timeColumn, arg(opts.From.UTC()))
+	}
+	if !opts.To.IsZero() {
+		fmt.Fprintf(&b, " AND %s < %s", timeColumn, arg(opts.To.UTC()))
+	}
+
+	direction, cmp := "DESC", "<"
+	if opts.Ascending {
+		direction, cmp = "ASC", ">"
+	}
+
+	if opts.Cursor != "" {
+		at, id, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		fmt.Fprintf(&b, " AND (%s, id) %s (%s, %s)", timeColumn, cmp, arg(at), arg(id))
+	}
+
+	fmt.Fprintf(&b, " ORDER BY %s %s, id %s", timeColumn, direction, direction)
+	if opts.Limit > 0 {
+		fmt.Fprintf(&b, " LIMIT %s", arg(opts.Limit+1))
+	}
+	return b.String(), args, nil
+}
+
+// nextPage trims the extra row requested by pageQuery and returns the cursor
+// of the next page, or an empty one if this page is the last.
+func nextPage[T any](items []T, limit int, key func(T) (time.Time, int)) ([]T, string) {
+	if limit <= 0 || len(items) <= limit {
+		return items, ""
+	}
+	items = items[:limit]
+	return items, encodeCursor(key(items[limit-1]))
+}
+
+func encodeCursor(at time.Time, id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", at.UnixMicro(), id)))
+}
+
+func decodeCursor(cursor string) (time.Time, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	micros, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	at, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	return time.UnixMicro(at).UTC(), n, nil
+}
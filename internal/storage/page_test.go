@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursor(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC)
+
+	decodedAt, id, err := decodeCursor(encodeCursor(at, 42))
+	require.NoError(t, err)
+	assert.True(t, at.Equal(decodedAt))
+	assert.Equal(t, 42, id)
+
+	for _, cursor := range []string{"not base64!", "MTIz", "YWJjOjE"} {
+		_, _, err := decodeCursor(cursor)
+		assert.ErrorIs(t, err, ErrInvalidCursor, cursor)
+	}
+}
+
+func TestPageQuery(t *testing.T) {
+	query, args, err := pageQuery("SELECT id FROM orders WHERE user_id = $1", []any{7}, "uploaded_at", ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT id FROM orders WHERE user_id = $1 ORDER BY uploaded_at DESC, id DESC", query)
+	assert.Equal(t, []any{7}, args)
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	query, args, err = pageQuery("SELECT id FROM orders WHERE user_id = $1", []any{7}, "uploaded_at", ListOptions{
+		From:      from,
+		Cursor:    encodeCursor(from, 3),
+		Limit:     10,
+		Ascending: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "SELECT id FROM orders WHERE user_id = $1 AND uploaded_at >= $2"+
+		" AND (uploaded_at, id) > ($3, $4) ORDER BY uploaded_at ASC, id ASC LIMIT $5", query)
+	assert.Equal(t, []any{7, from, from, 3, 11}, args)
+}
+
+func TestNextPage(t *testing.T) {
+	key := func(n int) (time.Time, int) { return time.Unix(int64(n), 0), n }
+
+	items, cursor := nextPage([]int{1, 2, 3}, 2, key)
+	assert.Equal(t, []int{1, 2}, items)
+	assert.Equal(t, encodeCursor(key(2)), cursor)
+
+	items, cursor = nextPage([]int{1, 2}, 2, key)
+	assert.Equal(t, []int{1, 2}, items)
+	assert.Empty(t, cursor)
+}
@@ -0,0 +1,233 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+type Refund struct {
+	ProcessedAt time.Time
+	// RefundID is chosen by the caller and identifies the refund across
+	// retries.
+	RefundID     string
+	ID           int
+	WithdrawalID int
+	Sum          money.Amount
+}
+
+var (
+	ErrWithdrawalNotFound  = errors.New("withdrawal not found")
+	ErrWithdrawalAmbiguous = errors.New("several withdrawals for the order")
+	ErrWithdrawalRefunded  = errors.New("withdrawal already refunded")
+	ErrRefundTooLarge      = errors.New("refund exceeds withdrawal")
+	ErrRefundIDReused      = errors.New("refund id already used for another refund")
+)
+
+// RefundWithdrawal returns points spent on the user's withdrawal for the
+// given order. A zero sum refunds whatever is left of the withdrawal. The
+// withdrawal row is locked, so concurrent refunds never exceed its sum.
+//
+// Retrying a refund with the same refundID returns the first refund instead
+// of crediting the points again. Orders with several withdrawals are
+// rejected, since it is not known which of them to refund.
+func (s *BalanceStoragePostgres) RefundWithdrawal(
+	userID int,
+	refundID, orderNumber string,
+	sum money.Amount,
+) (Refund, error) {
+	if sum < 0 {
+		return Refund{}, ErrInvalidSum
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.Error("failed to begin transaction", zap.Error(err))
+		return Refund{}, err
+	}
+
+	refund := Refund{RefundID: refundID, Sum: sum}
+	withdrawn, refunded, err := s.lockWithdrawal(tx, userID, orderNumber, &refund.WithdrawalID)
+	if err != nil {
+		rollback(tx, s.logger)
+		return Refund{}, err
+	}
+
+	// A retry finds the refund made by the first attempt.
+	var done Refund
+	err = tx.QueryRow(
+		"SELECT id, withdrawal_id, sum, processed_at FROM withdrawal_refunds WHERE refund_id = $1",
+		refundID,
+	).Scan(&done.ID, &done.WithdrawalID, &done.Sum, &done.ProcessedAt)
+	switch {
+	case err == nil:
+		rollback(tx, s.logger)
+		if done.WithdrawalID != refund.WithdrawalID || (sum != 0 && sum != done.Sum) {
+			return Refund{}, ErrRefundIDReused
+		}
+		done.RefundID = refundID
+		return done, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		rollback(tx, s.logger)
+		s.logger.Error("failed to get refund", zap.Error(err))
+		return Refund{}, err
+	}
+
+	remaining := withdrawn - refunded
+	if remaining == 0 {
+		rollback(tx, s.logger)
+		return Refund{}, ErrWithdrawalRefunded
+	}
+	if refund.Sum == 0 {
+		refund.Sum = remaining
+	}
+	if refund.Sum > remaining {
+		rollback(tx, s.logger)
+		return Refund{}, ErrRefundTooLarge
+	}
+
+	// The refund id may be taken concurrently by a refund of another
+	// withdrawal, which does not lock the same row.
+	err = tx.QueryRow(
+		`INSERT INTO withdrawal_refunds (withdrawal_id, refund_id, sum) VALUES ($1, $2, $3)
+		ON CONFLICT (refund_id) DO NOTHING RETURNING id, processed_at`,
+		refund.WithdrawalID, refundID, refund.Sum,
+	).Scan(&refund.ID, &refund.ProcessedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		rollback(tx, s.logger)
+		return Refund{}, ErrRefundIDReused
+	}
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to insert refund", zap.Error(err))
+		return Refund{}, err
+	}
+
+	_, err = tx.Exec("UPDATE withdrawals SET refunded = refunded + $1 WHERE id = $2", refund.Sum, refund.WithdrawalID)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to update withdrawal", zap.Error(err))
+		return Refund{}, err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE balances SET current = current + $1, withdrawn = withdrawn - $1 WHERE user_id = $2",
+		refund.Sum, userID,
+	)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to update balance", zap.Error(err))
+		return Refund{}, err
+	}
+
+	err = postTransaction(tx, OperationRefund, orderNumber,
+		systemAccount(accountWithdrawal), userAccount(userID), refund.Sum)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to post ledger transaction", zap.Error(err))
+		return Refund{}, err
+	}
+
+	// Refunded points keep the expiry of the lots they were withdrawn from.
+	parts, err := takeWithdrawalLots(tx, refund.WithdrawalID, refund.Sum)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to take withdrawal lots", zap.Error(err))
+		return Refund{}, err
+	}
+	if err := addLots(tx, userID, orderNumber, parts); err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to add point lots", zap.Error(err))
+		return Refund{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("failed to commit transaction", zap.Error(err))
+		return Refund{}, err
+	}
+
+	return refund, nil
+}
+
+// lockWithdrawal locks the only withdrawal of the user for the order and
+// returns its sum and the part of it already refunded.
+func (s *BalanceStoragePostgres) lockWithdrawal(
+	tx *sql.Tx,
+	userID int,
+	orderNumber string,
+	id *int,
+) (money.Amount, money.Amount, error) {
+	rows, err := tx.Query(
+		"SELECT id, sum, refunded FROM withdrawals WHERE user_id = $1 AND order_number = $2 FOR UPDATE",
+		userID, orderNumber,
+	)
+	if err != nil {
+		s.logger.Error("failed to get withdrawal", zap.Error(err))
+		return 0, 0, err
+	}
+
+	var withdrawn, refunded money.Amount
+	found := 0
+	for rows.Next() {
+		if err := rows.Scan(id, &withdrawn, &refunded); err != nil {
+			_ = rows.Close()
+			s.logger.Error("failed to scan withdrawal", zap.Error(err))
+			return 0, 0, err
+		}
+		found++
+	}
+	if err := rows.Close(); err != nil {
+		s.logger.Error("failed to close rows", zap.Error(err))
+		return 0, 0, err
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("failed to iterate over rows", zap.Error(err))
+		return 0, 0, err
+	}
+
+	switch found {
+	case 0:
+		return 0, 0, ErrWithdrawalNotFound
+	case 1:
+		return withdrawn, refunded, nil
+	default:
+		return 0, 0, ErrWithdrawalAmbiguous
+	}
+}
+
+func (s *BalanceStoragePostgres) getRefunds(withdrawalIDs []int) (map[int][]Refund, error) {
+	rows, err := s.db.Query(
+		`SELECT id, withdrawal_id, COALESCE(refund_id, ''), sum, processed_at FROM withdrawal_refunds
+		WHERE withdrawal_id = ANY($1) ORDER BY processed_at`,
+		pq.Array(withdrawalIDs),
+	)
+	if err != nil {
+		s.logger.Error("failed to get refunds", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.logger.Error("failed to close rows", zap.Error(err))
+		}
+	}()
+
+	refunds := make(map[int][]Refund)
+	for rows.Next() {
+		var refund Refund
+		err := rows.Scan(&refund.ID, &refund.WithdrawalID, &refund.RefundID, &refund.Sum, &refund.ProcessedAt)
+		if err != nil {
+			s.logger.Error("failed to scan refund", zap.Error(err))
+			return nil, err
+		}
+		refunds[refund.WithdrawalID] = append(refunds[refund.WithdrawalID], refund)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("failed to iterate over rows", zap.Error(err))
+		return nil, err
+	}
+	return refunds, nil
+}
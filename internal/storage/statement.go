@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"go.uber.org/zap"
+)
+
+// StatementLine is a movement on the user's balance together with the
+// balance right after it.
+type StatementLine struct {
+	CreatedAt   time.Time
+	Operation   LedgerOperation
+	OrderNumber string
+	Amount      money.Amount
+	Balance     money.Amount
+}
+
+// Statement lists the balance movements within a period. The opening balance
+// is the balance at the start of the period, the closing one at its end.
+type Statement struct {
+	Lines          []StatementLine
+	OpeningBalance money.Amount
+	ClosingBalance money.Amount
+}
+
+type StatementStorage interface {
+	GetStatement(userID int, from, to time.Time) (Statement, error)
+}
+
+// GetStatement builds the user's statement for [from, to) from the ledger,
+// which journals accruals of processed orders along with every other balance
+// movement. A zero from or to leaves that side of the period open.
+func (s *BalanceStoragePostgres) GetStatement(userID int, from, to time.Time) (Statement, error) {
+	var statement Statement
+	err := s.db.QueryRow(
+		`SELECT COALESCE(SUM(e.amount), 0)
+		FROM ledger_entries e
+		JOIN ledger_transactions t ON t.id = e.transaction_id
+		JOIN ledger_accounts a ON a.id = e.account_id
+		WHERE a.user_id = $1 AND t.created_at < $2`,
+		userID, nullTime(from),
+	).Scan(&statement.OpeningBalance)
+	if err != nil {
+		s.logger.Error("failed to get opening balance", zap.Error(err))
+		return Statement{}, err
+	}
+
+	rows, err := s.db.Query(
+		`SELECT t.operation, COALESCE(t.order_number, ''), e.amount, t.created_at
+		FROM ledger_entries e
+		JOIN ledger_transactions t ON t.id = e.transaction_id
+		JOIN ledger_accounts a ON a.id = e.account_id
+		WHERE a.user_id = $1
+			AND ($2::timestamp IS NULL OR t.created_at >= $2)
+			AND ($3::timestamp IS NULL OR t.created_at < $3)
+		ORDER BY t.created_at, e.id`,
+		userID, nullTime(from), nullTime(to),
+	)
+	if err != nil {
+		s.logger.Error("failed to get statement lines", zap.Error(err))
+		return Statement{}, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.logger.Error("failed to close rows", zap.Error(err))
+		}
+	}()
+
+	balance := statement.OpeningBalance
+	for rows.Next() {
+		var line StatementLine
+		if err := rows.Scan(&line.Operation, &line.OrderNumber, &line.Amount, &line.CreatedAt); err != nil {
+			s.logger.Error("failed to scan statement line", zap.Error(err))
+			return Statement{}, err
+		}
+		balance += line.Amount
+		line.Balance = balance
+		statement.Lines = append(statement.Lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("failed to iterate over rows", zap.Error(err))
+		return Statement{}, err
+	}
+
+	statement.ClosingBalance = balance
+	return statement, nil
+}
+
+// nullTime maps the zero time to NULL.
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
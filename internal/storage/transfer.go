@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"go.uber.org/zap"
+)
+
+// Transfer is a gift of points from one user to another.
+type Transfer struct {
+	ProcessedAt    time.Time
+	SenderLogin    string
+	RecipientLogin string
+	ID             int
+	SenderID       int
+	RecipientID    int
+	Sum            money.Amount
+}
+
+var (
+	ErrSelfTransfer          = errors.New("cannot transfer points to yourself")
+	ErrTransferLimitExceeded = errors.New("daily transfer limit exceeded")
+)
+
+type TransferStorage interface {
+	Transfer(senderID int, recipientLogin string, sum money.Amount) (Transfer, error)
+	GetTransfers(userID int) ([]Transfer, error)
+}
+
+// Transfer moves sum from the sender's balance to the recipient's. Both
+// balance rows are locked in user id order, so opposite transfers between the
+// same users cannot deadlock, and the sender's lock serializes the daily limit
+// check with the sender's other transfers.
+func (s *BalanceStoragePostgres) Transfer(senderID int, recipientLogin string, sum money.Amount) (Transfer, error) {
+	if sum <= 0 {
+		return Transfer{}, ErrInvalidSum
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		s.logger.Error("failed to begin transaction", zap.Error(err))
+		return Transfer{}, err
+	}
+
+	transfer := Transfer{SenderID: senderID, RecipientLogin: recipientLogin, Sum: sum}
+	err = tx.QueryRow("SELECT id FROM users WHERE login = $1", recipientLogin).Scan(&transfer.RecipientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		rollback(tx, s.logger)
+		return Transfer{}, ErrUserNotFound
+	}
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to get recipient", zap.Error(err))
+		return Transfer{}, err
+	}
+	if transfer.RecipientID == senderID {
+		rollback(tx, s.logger)
+		return Transfer{}, ErrSelfTransfer
+	}
+
+	for _, userID := range []int{min(senderID, transfer.RecipientID), max(senderID, transfer.RecipientID)} {
+		if _, err := tx.Exec("SELECT 1 FROM balances WHERE user_id = $1 FOR UPDATE", userID); err != nil {
+			rollback(tx, s.logger)
+			s.logger.Error("failed to lock balance", zap.Error(err))
+			return Transfer{}, err
+		}
+	}
+
+	if s.transferDailyLimit > 0 {
+		var sent money.Amount
+		err := tx.QueryRow(
+			"SELECT COALESCE(SUM(sum), 0) FROM transfers WHERE sender_id = $1 AND processed_at > now() - interval '1 day'",
+			senderID,
+		).Scan(&sent)
+		if err != nil {
+			rollback(tx, s.logger)
+			s.logger.Error("failed to get transferred sum", zap.Error(err))
+			return Transfer{}, err
+		}
+		if sent+sum > s.transferDailyLimit {
+			rollback(tx, s.logger)
+			return Transfer{}, ErrTransferLimitExceeded
+		}
+	}
+
+	res, err := tx.Exec(
+		"UPDATE balances SET current = current - $1 WHERE user_id = $2 AND current >= $1",
+		sum, senderID,
+	)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to update sender balance", zap.Error(err))
+		return Transfer{}, err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to get affected rows", zap.Error(err))
+		return Transfer{}, err
+	}
+	if updated == 0 {
+		rollback(tx, s.logger)
+		return Transfer{}, ErrInsufficientFunds
+	}
+
+	_, err = tx.Exec("UPDATE balances SET current = current + $1 WHERE user_id = $2", sum, transfer.RecipientID)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to update recipient balance", zap.Error(err))
+		return Transfer{}, err
+	}
+
+	err = postTransaction(tx, OperationTransfer, "", userAccount(senderID), userAccount(transfer.RecipientID), sum)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to post ledger transaction", zap.Error(err))
+		return Transfer{}, err
+	}
+
+	// Gifted points keep their expiry, so a round trip cannot refresh them.
+	parts, err := consumeLots(tx, senderID, sum)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to consume point lots", zap.Error(err))
+		return Transfer{}, err
+	}
+	if err := addLots(tx, transfer.RecipientID, "", parts); err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to add point lots", zap.Error(err))
+		return Transfer{}, err
+	}
+
+	err = tx.QueryRow(
+		`INSERT INTO transfers (sender_id, recipient_id, sum) VALUES ($1, $2, $3)
+		RETURNING id, processed_at, (SELECT login FROM users WHERE id = $1)`,
+		senderID, transfer.RecipientID, sum,
+	).Scan(&transfer.ID, &transfer.ProcessedAt, &transfer.SenderLogin)
+	if err != nil {
+		rollback(tx, s.logger)
+		s.logger.Error("failed to insert transfer", zap.Error(err))
+		return Transfer{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("failed to commit transaction", zap.Error(err))
+		return Transfer{}, err
+	}
+	return transfer, nil
+}
+
+// GetTransfers returns transfers sent or received by the user, newest first.
+func (s *BalanceStoragePostgres) GetTransfers(userID int) ([]Transfer, error) {
+	rows, err := s.db.Query(
+		`SELECT t.id, t.sender_id, sender.login, t.recipient_id, recipient.login, t.sum, t.processed_at
+		FROM transfers t
+		JOIN users sender ON sender.id = t.sender_id
+		JOIN users recipient ON recipient.id = t.recipient_id
+		WHERE t.sender_id = $1 OR t.recipient_id = $1
+		ORDER BY t.processed_at DESC, t.id DESC`,
+		userID,
+	)
+	if err != nil {
+		s.logger.Error("failed to get transfers", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.logger.Error("failed to close rows", zap.Error(err))
+		}
+	}()
+
+	var transfers []Transfer
+	for rows.Next() {
+		var t Transfer
+		if err := rows.Scan(
+			&t.ID, &t.SenderID, &t.SenderLogin, &t.RecipientID, &t.RecipientLogin, &t.Sum, &t.ProcessedAt,
+		); err != nil {
+			s.logger.Error("failed to scan transfer", zap.Error(err))
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("failed to iterate over rows", zap.Error(err))
+		return nil, err
+	}
+	return transfers, nil
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -15,6 +16,8 @@ type User struct {
 	ID        int
 }
 
+var ErrUserNotFound = errors.New("user not found")
+
 type UserStorage interface {
 	AddUser(user User) (int, error)
 	GetUser(login string) (User, error)
@@ -64,6 +67,14 @@ func (s *UserStoragePostgres) AddUser(user User) (int, error) {
 		return 0, err
 	}
 
+	if err := createUserAccount(tx, userID); err != nil {
+		s.logger.Error("failed to create ledger account", zap.Error(err))
+		if err := tx.Rollback(); err != nil {
+			s.logger.Error("failed to rollback transaction", zap.Error(err))
+		}
+		return 0, err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		s.logger.Error("failed to commit transaction", zap.Error(err))
@@ -77,6 +88,9 @@ func (s *UserStoragePostgres) GetUser(login string) (User, error) {
 	var user User
 	err := s.db.QueryRow("SELECT id, login, password, created_at FROM users WHERE login = $1", login).
 		Scan(&user.ID, &user.Login, &user.Password, &user.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrUserNotFound
+	}
 	if err != nil {
 		s.logger.Error("failed to get user", zap.Error(err))
 		return User{}, err
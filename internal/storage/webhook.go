@@ -0,0 +1,321 @@
+package storage
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"go.uber.org/zap"
+)
+
+const (
+	EventOrderProcessed    = "order.processed"
+	EventOrderInvalid      = "order.invalid"
+	EventWithdrawalCreated = "withdrawal.created"
+)
+
+// orderEvents maps final order statuses to the webhook events they raise.
+var orderEvents = map[OrderStatus]string{
+	StatusProcessed: EventOrderProcessed,
+	StatusInvalid:   EventOrderInvalid,
+}
+
+type orderEventData struct {
+	Number  string       `json:"number"`
+	Status  OrderStatus  `json:"status"`
+	Accrual money.Amount `json:"accrual"`
+}
+
+type withdrawalEventData struct {
+	Order string       `json:"order"`
+	Sum   money.Amount `json:"sum"`
+}
+
+const (
+	webhookSecretSize = 32
+	// deliveriesLogSize is how many recent deliveries the delivery log shows.
+	deliveriesLogSize = 100
+)
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "PENDING"
+	DeliveryDelivered DeliveryStatus = "DELIVERED"
+	DeliveryFailed    DeliveryStatus = "FAILED"
+)
+
+// Webhook is an endpoint a user registered to receive events. Secret signs
+// the deliveries and is only returned when the webhook is created.
+type Webhook struct {
+	CreatedAt time.Time
+	URL       string
+	Secret    string
+	ID        int
+	UserID    int
+}
+
+// WebhookDelivery is an event queued for a webhook.
+type WebhookDelivery struct {
+	CreatedAt     time.Time
+	NextAttemptAt time.Time
+	DeliveredAt   time.Time
+	Event         string
+	URL           string
+	Secret        string
+	Status        DeliveryStatus
+	LastError     string
+	Payload       []byte
+	ID            int
+	WebhookID     int
+	Attempts      int
+	// LastStatusCode is the HTTP status of the last attempt, zero if no
+	// response was received.
+	LastStatusCode int
+}
+
+// DeliveryAttempt is the outcome of sending a delivery. NextAttemptAt only
+// matters for pending deliveries.
+type DeliveryAttempt struct {
+	NextAttemptAt time.Time
+	Status        DeliveryStatus
+	Error         string
+	StatusCode    int
+}
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+type WebhookStorage interface {
+	AddWebhook(userID int, url string) (Webhook, error)
+	GetWebhooks(userID int) ([]Webhook, error)
+	DeleteWebhook(userID, webhookID int) error
+	GetDeliveries(userID, webhookID int) ([]WebhookDelivery, error)
+}
+
+type WebhookStoragePostgres struct {
+	logger *zap.Logger
+	db     *sql.DB
+}
+
+func NewWebhookStorage(db *sql.DB, logger *zap.Logger) (*WebhookStoragePostgres, error) {
+	return &WebhookStoragePostgres{
+		logger: logger,
+		db:     db,
+	}, nil
+}
+
+// enqueueWebhookEvent queues the event for every webhook of the user. It must
+// run in the transaction that makes the change the event is about.
+func enqueueWebhookEvent(tx *sql.Tx, userID int, event string, data any) error {
+	payload, err := json.Marshal(struct {
+		OccurredAt time.Time `json:"occurred_at"`
+		Data       any       `json:"data"`
+		Event      string    `json:"event"`
+	}{
+		OccurredAt: time.Now().UTC(),
+		Data:       data,
+		Event:      event,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT id, $2, $3 FROM webhooks WHERE user_id = $1`,
+		userID, event, string(payload),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue webhook event: %w", err)
+	}
+	return nil
+}
+
+func (s *WebhookStoragePostgres) AddWebhook(userID int, url string) (Webhook, error) {
+	secret := make([]byte, webhookSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		s.logger.Error("failed to generate webhook secret", zap.Error(err))
+		return Webhook{}, err
+	}
+
+	webhook := Webhook{UserID: userID, URL: url, Secret: hex.EncodeToString(secret)}
+	err := s.db.QueryRow(
+		"INSERT INTO webhooks (user_id, url, secret) VALUES ($1, $2, $3) RETURNING id, created_at",
+		userID, url, webhook.Secret,
+	).Scan(&webhook.ID, &webhook.CreatedAt)
+	if err != nil {
+		s.logger.Error("failed to add webhook", zap.Error(err))
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+// GetWebhooks returns the user's webhooks without their secrets.
+func (s *WebhookStoragePostgres) GetWebhooks(userID int) ([]Webhook, error) {
+	rows, err := s.db.Query(
+		"SELECT id, user_id, url, created_at FROM webhooks WHERE user_id = $1 ORDER BY id",
+		userID,
+	)
+	if err != nil {
+		s.logger.Error("failed to get webhooks", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.logger.Error("failed to close rows", zap.Error(err))
+		}
+	}()
+
+	var webhooks []Webhook
+	for rows.Next() {
+		var webhook Webhook
+		if err := rows.Scan(&webhook.ID, &webhook.UserID, &webhook.URL, &webhook.CreatedAt); err != nil {
+			s.logger.Error("failed to scan webhook", zap.Error(err))
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("failed to iterate over rows", zap.Error(err))
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook removes the webhook together with its queued deliveries.
+func (s *WebhookStoragePostgres) DeleteWebhook(userID, webhookID int) error {
+	res, err := s.db.Exec("DELETE FROM webhooks WHERE id = $1 AND user_id = $2", webhookID, userID)
+	if err != nil {
+		s.logger.Error("failed to delete webhook", zap.Error(err))
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		s.logger.Error("failed to get affected rows", zap.Error(err))
+		return err
+	}
+	if deleted == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// GetDeliveries returns the latest deliveries of the user's webhook, newest
+// first.
+func (s *WebhookStoragePostgres) GetDeliveries(userID, webhookID int) ([]WebhookDelivery, error) {
+	var exists bool
+	err := s.db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1 AND user_id = $2)",
+		webhookID, userID,
+	).Scan(&exists)
+	if err != nil {
+		s.logger.Error("failed to get webhook", zap.Error(err))
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrWebhookNotFound
+	}
+
+	rows, err := s.db.Query(
+		`SELECT id, webhook_id, event, payload, status, attempts, next_attempt_at,
+			COALESCE(last_status_code, 0), COALESCE(last_error, ''), created_at, delivered_at
+		FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2`,
+		webhookID, deliveriesLogSize,
+	)
+	if err != nil {
+		s.logger.Error("failed to get webhook deliveries", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.logger.Error("failed to close rows", zap.Error(err))
+		}
+	}()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(
+			&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.NextAttemptAt,
+			&d.LastStatusCode, &d.LastError, &d.CreatedAt, &deliveredAt,
+		); err != nil {
+			s.logger.Error("failed to scan webhook delivery", zap.Error(err))
+			return nil, err
+		}
+		d.DeliveredAt = deliveredAt.Time
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("failed to iterate over rows", zap.Error(err))
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// ClaimDeliveries takes up to limit due deliveries for sending and counts the
+// attempt. Claimed deliveries are not due again until lease passes, so
+// concurrent workers never send the same delivery twice at once.
+func (s *WebhookStoragePostgres) ClaimDeliveries(limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	rows, err := s.db.Query(
+		`UPDATE webhook_deliveries d
+		SET attempts = d.attempts + 1, next_attempt_at = now() + $2 * interval '1 millisecond'
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'PENDING' AND next_attempt_at <= now()
+			ORDER BY next_attempt_at LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, d.webhook_id, d.event, d.payload, d.attempts, d.created_at, w.url, w.secret`,
+		limit, lease.Milliseconds(),
+	)
+	if err != nil {
+		s.logger.Error("failed to claim webhook deliveries", zap.Error(err))
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			s.logger.Error("failed to close rows", zap.Error(err))
+		}
+	}()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		d := WebhookDelivery{Status: DeliveryPending}
+		if err := rows.Scan(
+			&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Attempts, &d.CreatedAt, &d.URL, &d.Secret,
+		); err != nil {
+			s.logger.Error("failed to scan webhook delivery", zap.Error(err))
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		s.logger.Error("failed to iterate over rows", zap.Error(err))
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// RecordAttempt stores the outcome of sending a claimed delivery.
+func (s *WebhookStoragePostgres) RecordAttempt(deliveryID int, attempt DeliveryAttempt) error {
+	_, err := s.db.Exec(
+		`UPDATE webhook_deliveries
+		SET status = $2, last_status_code = NULLIF($3, 0), last_error = NULLIF($4, ''),
+			next_attempt_at = CASE WHEN $2 = 'PENDING' THEN $5 ELSE next_attempt_at END,
+			delivered_at = CASE WHEN $2 = 'DELIVERED' THEN now() END
+		WHERE id = $1`,
+		deliveryID, attempt.Status, attempt.StatusCode, attempt.Error, attempt.NextAttemptAt.UTC(),
+	)
+	if err != nil {
+		s.logger.Error("failed to record webhook delivery attempt", zap.Error(err))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/krasvl/market/internal/money"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWebhookDeliveries(t *testing.T) {
+	db := testDB(t)
+	userID := testUser(t, db)
+	testFund(t, db, userID, money.FromPoints(100))
+
+	webhooks, err := NewWebhookStorage(db, zap.NewNop())
+	require.NoError(t, err)
+	balances, err := NewBalanceStorage(db, zap.NewNop(), 0)
+	require.NoError(t, err)
+
+	webhook, err := webhooks.AddWebhook(userID, "https://example.com/hooks")
+	require.NoError(t, err)
+	assert.Len(t, webhook.Secret, 2*webhookSecretSize)
+
+	orderNumber := testOrderNumber()
+	require.NoError(t, balances.Withdraw(userID, Withdrawal{
+		UserID:      userID,
+		OrderNumber: orderNumber,
+		Sum:         money.FromPoints(40),
+		ProcessedAt: time.Now(),
+	}))
+
+	deliveries, err := webhooks.GetDeliveries(userID, webhook.ID)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, EventWithdrawalCreated, deliveries[0].Event)
+	assert.Equal(t, DeliveryPending, deliveries[0].Status)
+
+	var payload struct {
+		Event string              `json:"event"`
+		Data  withdrawalEventData `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(deliveries[0].Payload, &payload))
+	assert.Equal(t, EventWithdrawalCreated, payload.Event)
+	assert.Equal(t, orderNumber, payload.Data.Order)
+	assert.Equal(t, money.FromPoints(40), payload.Data.Sum)
+
+	claim := func() *WebhookDelivery {
+		claimed, err := webhooks.ClaimDeliveries(1000, time.Minute)
+		require.NoError(t, err)
+		for _, d := range claimed {
+			if d.ID == deliveries[0].ID {
+				return &d
+			}
+		}
+		return nil
+	}
+
+	t.Run("Claim", func(t *testing.T) {
+		claimed := claim()
+		require.NotNil(t, claimed)
+		assert.Equal(t, 1, claimed.Attempts)
+		assert.Equal(t, webhook.URL, claimed.URL)
+		assert.Equal(t, webhook.Secret, claimed.Secret)
+
+		// The lease keeps the delivery from being claimed again.
+		assert.Nil(t, claim())
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		require.NoError(t, webhooks.RecordAttempt(deliveries[0].ID, DeliveryAttempt{
+			Status:        DeliveryPending,
+			StatusCode:    500,
+			Error:         "unexpected status 500",
+			NextAttemptAt: time.Now().Add(-time.Second),
+		}))
+
+		claimed := claim()
+		require.NotNil(t, claimed)
+		assert.Equal(t, 2, claimed.Attempts)
+	})
+
+	t.Run("Delivered", func(t *testing.T) {
+		require.NoError(t, webhooks.RecordAttempt(deliveries[0].ID, DeliveryAttempt{
+			Status:     DeliveryDelivered,
+			StatusCode: 200,
+		}))
+		assert.Nil(t, claim())
+
+		log, err := webhooks.GetDeliveries(userID, webhook.ID)
+		require.NoError(t, err)
+		require.Len(t, log, 1)
+		assert.Equal(t, DeliveryDelivered, log[0].Status)
+		assert.Equal(t, 200, log[0].LastStatusCode)
+		assert.Empty(t, log[0].LastError)
+		assert.False(t, log[0].DeliveredAt.IsZero())
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		_, err := webhooks.GetDeliveries(userID+1, webhook.ID)
+		assert.ErrorIs(t, err, ErrWebhookNotFound)
+
+		require.NoError(t, webhooks.DeleteWebhook(userID, webhook.ID))
+		assert.ErrorIs(t, webhooks.DeleteWebhook(userID, webhook.ID), ErrWebhookNotFound)
+	})
+}
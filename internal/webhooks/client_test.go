@@ -0,0 +1,112 @@
+package webhooks
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/krasvl/market/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "93.184.216.34", want: true},
+		{ip: "2606:2800:220:1:248:1893:25c8:1946", want: true},
+		{ip: "127.0.0.1"},
+		{ip: "::1"},
+		{ip: "::ffff:127.0.0.1"},
+		{ip: "10.1.2.3"},
+		{ip: "172.16.0.1"},
+		{ip: "192.168.1.1"},
+		{ip: "fd00::1"},
+		{ip: "169.254.169.254"},
+		{ip: "fe80::1"},
+		{ip: "224.0.0.1"},
+		{ip: "ff02::1"},
+		{ip: "0.0.0.0"},
+		{ip: "::"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, IsPublicIP(net.ParseIP(tt.ip)), tt.ip)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	t.Run("Private Address Rejected", func(t *testing.T) {
+		called := false
+		server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			called = true
+		}))
+		defer server.Close()
+
+		resp, err := NewClient(time.Second).Post(server.URL, "application/json", nil)
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		require.ErrorIs(t, err, ErrForbiddenAddress)
+		assert.False(t, called)
+	})
+
+	t.Run("Private Address Not Delivered", func(t *testing.T) {
+		called := false
+		server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			called = true
+		}))
+		defer server.Close()
+
+		store := &MockStore{
+			attempts: make(map[int]storage.DeliveryAttempt),
+			deliveries: []storage.WebhookDelivery{{
+				ID: 1, URL: server.URL, Secret: "secret", Payload: []byte("{}"), Attempts: 1,
+			}},
+		}
+		dispatcher := NewDispatcher(zap.NewNop(), store, NewClient(time.Second), 5)
+
+		_, err := dispatcher.Deliver(context.Background())
+		require.NoError(t, err)
+		assert.False(t, called)
+		assert.Contains(t, store.attempts[1].Error, ErrForbiddenAddress.Error())
+	})
+
+	t.Run("Redirect Not Followed", func(t *testing.T) {
+		followed := false
+		mux := http.NewServeMux()
+		mux.HandleFunc("/hook", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/internal", http.StatusFound)
+		})
+		mux.HandleFunc("/internal", func(http.ResponseWriter, *http.Request) {
+			followed = true
+		})
+		server := httptest.NewTLSServer(mux)
+		defer server.Close()
+
+		// The test server listens on the loopback, which the client refuses
+		// to dial, so only its redirect policy is under test here.
+		client := NewClient(time.Second)
+		client.Transport = server.Client().Transport
+
+		store := &MockStore{
+			attempts: make(map[int]storage.DeliveryAttempt),
+			deliveries: []storage.WebhookDelivery{{
+				ID: 2, URL: server.URL + "/hook", Secret: "secret", Payload: []byte("{}"), Attempts: 1,
+			}},
+		}
+		dispatcher := NewDispatcher(zap.NewNop(), store, client, 5)
+
+		_, err := dispatcher.Deliver(context.Background())
+		require.NoError(t, err)
+		assert.False(t, followed)
+		assert.Equal(t, storage.DeliveryPending, store.attempts[2].Status)
+		assert.Equal(t, http.StatusFound, store.attempts[2].StatusCode)
+	})
+}
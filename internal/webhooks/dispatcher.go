@@ -0,0 +1,158 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/krasvl/market/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	EventHeader     = "X-Gophermart-Event"
+	DeliveryHeader  = "X-Gophermart-Delivery"
+	TimestampHeader = "X-Gophermart-Timestamp"
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the
+	// timestamp, a dot and the body, keyed with the webhook secret.
+	SignatureHeader = "X-Gophermart-Signature"
+)
+
+// Store is the delivery queue the dispatcher works off.
+type Store interface {
+	ClaimDeliveries(limit int, lease time.Duration) ([]storage.WebhookDelivery, error)
+	RecordAttempt(deliveryID int, attempt storage.DeliveryAttempt) error
+}
+
+// Dispatcher sends queued webhook deliveries and reschedules the failed ones
+// with exponential backoff until they run out of attempts.
+type Dispatcher struct {
+	logger      *zap.Logger
+	store       Store
+	client      *http.Client
+	now         func() time.Time
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	maxAttempts int
+	workers     int
+}
+
+// NewDispatcher creates a dispatcher sending up to workers deliveries at the
+// same time.
+func NewDispatcher(logger *zap.Logger, store Store, client *http.Client, workers int) *Dispatcher {
+	return &Dispatcher{
+		logger:      logger,
+		store:       store,
+		client:      client,
+		now:         time.Now,
+		baseDelay:   30 * time.Second,
+		maxDelay:    6 * time.Hour,
+		maxAttempts: 10,
+		workers:     workers,
+	}
+}
+
+// Sign returns the signature of a delivery body sent at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Deliver sends one batch of due deliveries and returns how many it handled.
+// The deliveries of a batch are sent at the same time, so a slow endpoint only
+// holds up its own delivery.
+func (d *Dispatcher) Deliver(ctx context.Context) (int, error) {
+	// A batch is no larger than the number of workers, so every delivery is
+	// sent right away and the lease outlives the client timeout. A delivery is
+	// never claimed again while it is still being sent.
+	deliveries, err := d.store.ClaimDeliveries(d.workers, 2*d.client.Timeout+time.Minute)
+	if err != nil {
+		return 0, err
+	}
+
+	attempts := make([]storage.DeliveryAttempt, len(deliveries))
+	var wg sync.WaitGroup
+	for i, delivery := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempts[i] = d.send(ctx, delivery)
+		}()
+	}
+	wg.Wait()
+
+	for i, delivery := range deliveries {
+		attempt := attempts[i]
+		if err := d.store.RecordAttempt(delivery.ID, attempt); err != nil {
+			return 0, err
+		}
+		if attempt.Status != storage.DeliveryDelivered {
+			d.logger.Warn("webhook delivery failed",
+				zap.Int("delivery", delivery.ID),
+				zap.Int("attempt", delivery.Attempts),
+				zap.String("status", string(attempt.Status)),
+				zap.String("error", attempt.Error),
+			)
+		}
+	}
+	return len(deliveries), nil
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery storage.WebhookDelivery) storage.DeliveryAttempt {
+	timestamp := d.now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		// The URL will not get any better, retrying is pointless.
+		return storage.DeliveryAttempt{Status: storage.DeliveryFailed, Error: err.Error()}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return d.retry(delivery, 0, err.Error())
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		if err := resp.Body.Close(); err != nil {
+			d.logger.Error("failed to close response body", zap.Error(err))
+		}
+	}()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return storage.DeliveryAttempt{Status: storage.DeliveryDelivered, StatusCode: resp.StatusCode}
+	}
+	return d.retry(delivery, resp.StatusCode, fmt.Sprintf("unexpected status %d", resp.StatusCode))
+}
+
+// retry schedules the next attempt, doubling the delay after every failure.
+func (d *Dispatcher) retry(delivery storage.WebhookDelivery, statusCode int, reason string) storage.DeliveryAttempt {
+	if delivery.Attempts >= d.maxAttempts {
+		return storage.DeliveryAttempt{Status: storage.DeliveryFailed, StatusCode: statusCode, Error: reason}
+	}
+
+	delay := d.maxDelay
+	if shift := delivery.Attempts - 1; shift < 30 {
+		delay = min(d.baseDelay<<shift, d.maxDelay)
+	}
+	return storage.DeliveryAttempt{
+		Status:        storage.DeliveryPending,
+		StatusCode:    statusCode,
+		Error:         reason,
+		NextAttemptAt: d.now().Add(delay),
+	}
+}
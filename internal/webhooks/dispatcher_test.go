@@ -0,0 +1,185 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krasvl/market/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type MockStore struct {
+	attempts   map[int]storage.DeliveryAttempt
+	deliveries []storage.WebhookDelivery
+}
+
+func (m *MockStore) ClaimDeliveries(limit int, _ time.Duration) ([]storage.WebhookDelivery, error) {
+	claimed := m.deliveries[:min(limit, len(m.deliveries))]
+	m.deliveries = m.deliveries[len(claimed):]
+	return claimed, nil
+}
+
+func (m *MockStore) RecordAttempt(deliveryID int, attempt storage.DeliveryAttempt) error {
+	m.attempts[deliveryID] = attempt
+	return nil
+}
+
+func TestSign(t *testing.T) {
+	signature := Sign("secret", 1700000000, []byte(`{"event":"order.processed"}`))
+	assert.Equal(t, "sha256=72bc88175ee04ab1dc7920d68159ea12673d969c95646f773ea186080944b90b", signature)
+	assert.NotEqual(t, signature, Sign("other", 1700000000, []byte(`{"event":"order.processed"}`)))
+	assert.NotEqual(t, signature, Sign("secret", 1700000001, []byte(`{"event":"order.processed"}`)))
+}
+
+func TestDeliver(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	payload := []byte(`{"event":"withdrawal.created","data":{"order":"2377225624","sum":100}}`)
+
+	tests := []struct {
+		wantNext   time.Time
+		name       string
+		wantStatus storage.DeliveryStatus
+		status     int
+		attempts   int
+	}{
+		{
+			name:       "Delivered",
+			status:     http.StatusNoContent,
+			attempts:   1,
+			wantStatus: storage.DeliveryDelivered,
+		},
+		{
+			name:       "Retry Scheduled",
+			status:     http.StatusInternalServerError,
+			attempts:   3,
+			wantStatus: storage.DeliveryPending,
+			wantNext:   now.Add(2 * time.Minute),
+		},
+		{
+			name:       "Backoff Doubles",
+			status:     http.StatusBadGateway,
+			attempts:   9,
+			wantStatus: storage.DeliveryPending,
+			wantNext:   now.Add(2*time.Hour + 8*time.Minute),
+		},
+		{
+			name:       "Failed After Last Attempt",
+			status:     http.StatusInternalServerError,
+			attempts:   10,
+			wantStatus: storage.DeliveryFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received *http.Request
+			var body []byte
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received = r
+				body, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			store := &MockStore{
+				attempts: make(map[int]storage.DeliveryAttempt),
+				deliveries: []storage.WebhookDelivery{{
+					ID:       7,
+					Event:    storage.EventWithdrawalCreated,
+					URL:      server.URL + "/hook",
+					Secret:   "secret",
+					Payload:  payload,
+					Attempts: tt.attempts,
+				}},
+			}
+			client := server.Client()
+			client.Timeout = time.Second
+			dispatcher := NewDispatcher(zap.NewNop(), store, client, 5)
+			dispatcher.now = func() time.Time { return now }
+
+			handled, err := dispatcher.Deliver(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, 1, handled)
+
+			require.NotNil(t, received)
+			assert.Equal(t, http.MethodPost, received.Method)
+			assert.Equal(t, "/hook", received.URL.Path)
+			assert.Equal(t, payload, body)
+			assert.Equal(t, storage.EventWithdrawalCreated, received.Header.Get(EventHeader))
+			assert.Equal(t, "7", received.Header.Get(DeliveryHeader))
+
+			timestamp, err := strconv.ParseInt(received.Header.Get(TimestampHeader), 10, 64)
+			require.NoError(t, err)
+			assert.Equal(t, now.Unix(), timestamp)
+			assert.Equal(t, Sign("secret", timestamp, body), received.Header.Get(SignatureHeader))
+
+			attempt := store.attempts[7]
+			assert.Equal(t, tt.wantStatus, attempt.Status)
+			assert.Equal(t, tt.status, attempt.StatusCode)
+			assert.True(t, tt.wantNext.Equal(attempt.NextAttemptAt), "next attempt at %v", attempt.NextAttemptAt)
+		})
+	}
+
+	t.Run("Unreachable Endpoint Retried", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.NotFoundHandler())
+		url := server.URL
+		client := server.Client()
+		server.Close()
+
+		store := &MockStore{
+			attempts: make(map[int]storage.DeliveryAttempt),
+			deliveries: []storage.WebhookDelivery{{
+				ID: 8, URL: url, Secret: "secret", Payload: payload, Attempts: 1,
+			}},
+		}
+		dispatcher := NewDispatcher(zap.NewNop(), store, client, 5)
+		dispatcher.now = func() time.Time { return now }
+
+		_, err := dispatcher.Deliver(context.Background())
+		require.NoError(t, err)
+
+		attempt := store.attempts[8]
+		assert.Equal(t, storage.DeliveryPending, attempt.Status)
+		assert.Zero(t, attempt.StatusCode)
+		assert.NotEmpty(t, attempt.Error)
+		assert.Equal(t, now.Add(30*time.Second), attempt.NextAttemptAt)
+	})
+
+	t.Run("Batch Sent At Once", func(t *testing.T) {
+		// Every request waits until all of the batch arrived, which only
+		// happens if the deliveries are sent at the same time.
+		var arrived sync.WaitGroup
+		arrived.Add(2)
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			arrived.Done()
+			arrived.Wait()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		store := &MockStore{attempts: make(map[int]storage.DeliveryAttempt)}
+		for id := 1; id <= 3; id++ {
+			store.deliveries = append(store.deliveries, storage.WebhookDelivery{
+				ID: id, URL: server.URL, Secret: "secret", Payload: payload, Attempts: 1,
+			})
+		}
+		client := server.Client()
+		client.Timeout = time.Second
+		dispatcher := NewDispatcher(zap.NewNop(), store, client, 2)
+
+		handled, err := dispatcher.Deliver(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 2, handled)
+		assert.Equal(t, storage.DeliveryDelivered, store.attempts[1].Status)
+		assert.Equal(t, storage.DeliveryDelivered, store.attempts[2].Status)
+		assert.Len(t, store.deliveries, 1)
+	})
+}